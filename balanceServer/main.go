@@ -6,6 +6,8 @@ import (
 	"balance/internal/tls"
 	"log/slog"
 	"os"
+
+	"google.golang.org/grpc"
 )
 
 const ApiPort = "50051"
@@ -28,7 +30,8 @@ func main() {
 	}
 
 	slbServiceImpl := balanceService.NewBalanceService()
-	slbServer := apiService.NewApiServer(serverCreds, slbServiceImpl, ApiPort)
+	slbServer := apiService.NewApiServer(serverCreds, slbServiceImpl, ApiPort,
+		grpc.ChainUnaryInterceptor(slbServiceImpl.TracingInterceptor(), slbServiceImpl.MetricsInterceptor()))
 	defer slbServer.Stop()
 	go slbServer.Start()
 
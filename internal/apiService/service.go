@@ -53,6 +53,26 @@ func (b *BalanceServer) Remove(ctx context.Context, server *api.Server) (*emptyp
 	return b.Client.Remove(ctx, server)
 }
 
+func (b *BalanceServer) Drain(ctx context.Context, req *api.DrainRequest) (*emptypb.Empty, error) {
+	return b.Client.Drain(ctx, req)
+}
+
+func (b *BalanceServer) SetPool(ctx context.Context, pool *api.Pool) (*emptypb.Empty, error) {
+	return b.Client.SetPool(ctx, pool)
+}
+
+func (b *BalanceServer) DeletePool(ctx context.Context, pool *api.Pool) (*emptypb.Empty, error) {
+	return b.Client.DeletePool(ctx, pool)
+}
+
+func (b *BalanceServer) SetRoute(ctx context.Context, route *api.Route) (*emptypb.Empty, error) {
+	return b.Client.SetRoute(ctx, route)
+}
+
+func (b *BalanceServer) DeleteRoute(ctx context.Context, route *api.Route) (*emptypb.Empty, error) {
+	return b.Client.DeleteRoute(ctx, route)
+}
+
 type ApiServer struct {
 	Server *grpc.Server
 	Port   string
@@ -77,9 +97,9 @@ func (a *ApiServer) Stop() {
 	a.Server.GracefulStop()
 }
 
-func NewApiServer(creds credentials.TransportCredentials, slbServer api.BalanceServer, port string) *ApiServer {
+func NewApiServer(creds credentials.TransportCredentials, slbServer api.BalanceServer, port string, opts ...grpc.ServerOption) *ApiServer {
 	return &ApiServer{
-		Server: NewGrpcServer(creds, slbServer),
+		Server: NewGrpcServer(creds, slbServer, opts...),
 		Port:   port,
 	}
 }
@@ -89,8 +109,8 @@ func NewApiClient(creds credentials.TransportCredentials, serverAddress string,
 	return api.NewBalanceClient(conn), err
 }
 
-func NewGrpcServer(creds credentials.TransportCredentials, slbServer api.BalanceServer) *grpc.Server {
-	s := grpc.NewServer(grpc.Creds(creds))
+func NewGrpcServer(creds credentials.TransportCredentials, slbServer api.BalanceServer, opts ...grpc.ServerOption) *grpc.Server {
+	s := grpc.NewServer(append([]grpc.ServerOption{grpc.Creds(creds)}, opts...)...)
 	api.RegisterBalanceServer(s, slbServer)
 	reflection.Register(s)
 	return s
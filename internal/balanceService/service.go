@@ -3,27 +3,50 @@ package balanceService
 
 import (
 	api "balance/gen"
+	"balance/internal/selectors/consistentHash"
+	"balance/internal/selectors/leastConnections"
+	"balance/internal/selectors/p2c"
 	randomSelector "balance/internal/selectors/random"
 	"balance/internal/selectors/roundRobin"
+	"balance/internal/selectors/weightedRoundRobin"
 	"balance/slb"
 	"context"
 	"fmt"
 	"log/slog"
-	"net/http"
+	"net"
+	"net/url"
+	"path"
 	"reflect"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.25.0"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
 	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 const DefaultAddress = "0.0.0.0"
 const DefaultPort = "8080"
 
 var ErrNotConfigured = fmt.Errorf("slb not configured correctly")
+var ErrNoServer = fmt.Errorf("no server provided")
 
 type BalanceServer struct {
 	api.UnimplementedBalanceServer
 	selector slb.Selector
 	slb      *slb.Slb
+	// calls of the api by method and status code, served with the metrics of the slb
+	apiCalls *prometheus.CounterVec
 }
 
 func (b *BalanceServer) Configuration(ctx context.Context, _ *emptypb.Empty) (*api.Config, error) {
@@ -31,66 +54,111 @@ func (b *BalanceServer) Configuration(ctx context.Context, _ *emptypb.Empty) (*a
 		return nil, ErrNotConfigured
 	}
 	cfg := b.slb.Configuration()
-	endpoints := []*api.Server{}
-	for _, endpoint := range cfg.Endpoints {
-		endpoints = append(endpoints, &api.Server{
-			Address: endpoint.Addr,
-		})
-	}
-	strategy := api.SelectorStrategy_SELECTOR_STRATEGY_UNSPECIFIED
-	t := reflect.TypeOf(b.selector)
-	if reflect.TypeOf(&roundRobin.RoundRobin{}) == t {
-		strategy = api.SelectorStrategy_SELECTOR_STRATEGY_ROUND_ROBIN
-	}
-	if reflect.TypeOf(&randomSelector.Random{}) == t {
-		strategy = api.SelectorStrategy_SELECTOR_STRATEGY_RANDOM
-	}
+	strategy := strategyOf(b.selector)
 	if strategy == api.SelectorStrategy_SELECTOR_STRATEGY_UNSPECIFIED {
 		slog.Warn("No strategy configured")
 	}
+	pools := []*api.Pool{}
+	for _, pool := range cfg.Pools {
+		pools = append(pools, &api.Pool{
+			Name:      pool.Name,
+			Strategy:  strategyOf(pool.Selector),
+			Endpoints: serversToApi(pool.Endpoints, cfg),
+			Discovery: discoveryToApi(pool.Discovery, cfg.DiscoveryStatus[pool.Name]),
+		})
+	}
+	routes := []*api.Route{}
+	for _, route := range cfg.Routes {
+		routes = append(routes, routeToApi(route))
+	}
 
 	return &api.Config{
-		Endpoints:     endpoints,
-		ListenPort:    cfg.ListenPort,
-		ListenAddress: cfg.ListenAddress,
-		HandlePostfix: cfg.HandlePostfix,
-		Strategy:      strategy,
+		Endpoints:        serversToApi(cfg.Endpoints, cfg),
+		ListenPort:       cfg.ListenPort,
+		ListenAddress:    cfg.ListenAddress,
+		HandlePostfix:    cfg.HandlePostfix,
+		Strategy:         strategy,
+		HealthCheck:      healthCheckToApi(cfg.HealthCheck),
+		OutlierDetection: outlierDetectionToApi(cfg.OutlierDetection),
+		HashKey:          hashKeyToApi(cfg.HashKey),
+		SessionAffinity:  sessionAffinityToApi(cfg.SessionAffinity),
+		RetryPolicy:      retryPolicyToApi(cfg.RetryPolicy),
+		UpstreamTimeout:  optionalDurationToApi(cfg.UpstreamTimeout),
+		ErrorResponses:   errorResponsesToApi(cfg.ErrorResponses),
+		Metrics:          metricsToApi(cfg.Metrics),
+		AccessLog:        accessLogToApi(cfg.AccessLog),
+		Tracing:          tracingToApi(cfg.Tracing),
+		Tls:              tlsToApi(cfg.TLS),
+		UpstreamTls:      upstreamTlsToApi(cfg.UpstreamTLS),
+		Discovery:        discoveryToApi(cfg.Discovery, cfg.DiscoveryStatus[slb.DefaultPoolName]),
+		SlowStart:        slowStartToApi(cfg.SlowStart),
+		Pools:            pools,
+		Routes:           routes,
 	}, nil
 }
 
+// Configures a new SLB, that replaces the running SLB without dropping requests.
+// The previous SLB keeps running if the configuration is invalid.
 func (b *BalanceServer) Configure(ctx context.Context, config *api.Config) (*emptypb.Empty, error) {
-	if b.slb != nil {
-		slog.Info(fmt.Sprintf("Stopping server with previous configuration %v", b.slb.Configuration()))
-		if err := b.slb.Stop(); err != nil {
-			return &emptypb.Empty{}, err
-		}
-	}
-
-	slog.Info(fmt.Sprintf("Setting new configuration: %v", config))
 	newConfig := slb.Config{
-		Endpoints:     make([]*http.Server, 0),
-		ListenAddress: config.ListenAddress,
-		ListenPort:    config.ListenPort,
-		HandlePostfix: config.HandlePostfix,
+		ListenAddress:    config.ListenAddress,
+		ListenPort:       config.ListenPort,
+		HandlePostfix:    config.HandlePostfix,
+		HealthCheck:      healthCheckFromApi(config.HealthCheck),
+		OutlierDetection: outlierDetectionFromApi(config.OutlierDetection),
+		HashKey:          hashKeyFromApi(config.HashKey),
+		SessionAffinity:  sessionAffinityFromApi(config.SessionAffinity),
+		RetryPolicy:      retryPolicyFromApi(config.RetryPolicy),
+		UpstreamTimeout:  config.UpstreamTimeout.AsDuration(),
+		ErrorResponses:   errorResponsesFromApi(config.ErrorResponses),
+		Metrics:          metricsFromApi(config.Metrics),
+		AccessLog:        accessLogFromApi(config.AccessLog),
+		Tracing:          tracingFromApi(config.Tracing),
+		TLS:              tlsFromApi(config.Tls),
+		UpstreamTLS:      upstreamTlsFromApi(config.UpstreamTls),
+		Discovery:        discoveryFromApi(config.Discovery),
+		SlowStart:        slowStartFromApi(config.SlowStart),
 	}
-	for _, server := range config.Endpoints {
-		newConfig.Endpoints = append(newConfig.Endpoints, &http.Server{Addr: server.Address})
+	newConfig.Endpoints, newConfig.EndpointTLS = serversFromApi(config.Endpoints)
+	for _, pool := range config.Pools {
+		poolConfig, endpointTLS := poolFromApi(pool)
+		newConfig.Pools = append(newConfig.Pools, poolConfig)
+		for addr, cfg := range endpointTLS {
+			if newConfig.EndpointTLS == nil {
+				newConfig.EndpointTLS = make(map[string]slb.UpstreamTLSConfig)
+			}
+			newConfig.EndpointTLS[addr] = cfg
+		}
 	}
-
-	switch config.Strategy {
-	case api.SelectorStrategy_SELECTOR_STRATEGY_ROUND_ROBIN:
-		b.selector = roundRobin.New()
-	case api.SelectorStrategy_SELECTOR_STRATEGY_RANDOM:
-		b.selector = randomSelector.New()
-	default:
-		b.selector = roundRobin.New()
+	for _, route := range config.Routes {
+		newConfig.Routes = append(newConfig.Routes, routeFromApi(route))
+	}
+	selector := newSelector(config.Strategy)
+	if b.slb == nil {
+		// servers can be added to the selector until the configuration is valid
+		b.selector = selector
 	}
 
-	slb, err := slb.New(newConfig, b.selector)
+	next, err := slb.New(newConfig, selector)
 	if err != nil {
 		return &emptypb.Empty{}, err
 	}
-	b.slb = slb
+	// the configuration of the SLB omits the secrets of the request (e.g the signing key and private keys)
+	slog.Info(fmt.Sprintf("Setting new configuration: %v", next.Configuration()))
+	if b.apiCalls != nil {
+		if err := next.RegisterMetrics(b.apiCalls); err != nil {
+			next.Stop()
+			return &emptypb.Empty{}, err
+		}
+	}
+	if b.slb != nil {
+		slog.Info(fmt.Sprintf("Replacing server with previous configuration %v", b.slb.Configuration()))
+		if err := b.slb.Replace(next); err != nil {
+			next.Stop()
+			return &emptypb.Empty{}, err
+		}
+	}
+	b.selector, b.slb = selector, next
 	return &emptypb.Empty{}, nil
 }
 
@@ -98,6 +166,9 @@ func (b *BalanceServer) Run(ctx context.Context, req *emptypb.Empty) (*emptypb.E
 	if b.slb == nil {
 		return nil, ErrNotConfigured
 	}
+	if b.slb.Running() {
+		return req, nil
+	}
 	go b.slb.Run()
 	slog.Info("Running ")
 	return req, nil
@@ -114,20 +185,146 @@ func (b *BalanceServer) Add(ctx context.Context, server *api.Server) (*emptypb.E
 	if b.selector == nil {
 		return nil, ErrNotConfigured
 	}
-	s := &http.Server{Addr: server.Address}
-	return &emptypb.Empty{}, b.selector.Add(s)
+	s := serverFromApi(server)
+	if b.slb == nil {
+		return &emptypb.Empty{}, b.selector.Add(s)
+	}
+	if server.Tls != nil {
+		if err := b.slb.SetEndpointTLS(s.Identity(), upstreamTlsFromApi(server.Tls)); err != nil {
+			return &emptypb.Empty{}, err
+		}
+	}
+	add := b.slb.Add
+	if server.SlowStart != nil {
+		add = func(s *slb.Endpoint) error { return b.slb.AddWithSlowStart(s, server.SlowStart.AsDuration()) }
+	}
+	if _, ok := b.selector.(slb.Weighter); !ok || server.Weight == 0 {
+		// the default weight is accepted by every strategy
+		return &emptypb.Empty{}, add(s)
+	}
+	if b.slb.SetWeight(s, int(server.Weight)) == nil {
+		// the server exists, only its weight is updated
+		return &emptypb.Empty{}, nil
+	}
+	return &emptypb.Empty{}, add(s)
 }
 
 func (b *BalanceServer) Remove(ctx context.Context, server *api.Server) (*emptypb.Empty, error) {
 	if b.selector == nil {
 		return nil, ErrNotConfigured
 	}
-	s := &http.Server{Addr: server.Address}
+	s := serverFromApi(server)
+	if b.slb != nil {
+		return &emptypb.Empty{}, b.slb.Remove(s)
+	}
 	return &emptypb.Empty{}, b.selector.Remove(s)
 }
 
+func (b *BalanceServer) Drain(ctx context.Context, req *api.DrainRequest) (*emptypb.Empty, error) {
+	if b.slb == nil {
+		return nil, ErrNotConfigured
+	}
+	if req.Server == nil {
+		return nil, ErrNoServer
+	}
+	return &emptypb.Empty{}, b.slb.Drain(serverFromApi(req.Server), req.Timeout.AsDuration())
+}
+
+func (b *BalanceServer) SetPool(ctx context.Context, pool *api.Pool) (*emptypb.Empty, error) {
+	if b.slb == nil {
+		return nil, ErrNotConfigured
+	}
+	poolConfig, endpointTLS := poolFromApi(pool)
+	for addr, cfg := range endpointTLS {
+		if err := b.slb.SetEndpointTLS(addr, cfg); err != nil {
+			return &emptypb.Empty{}, err
+		}
+	}
+	return &emptypb.Empty{}, b.slb.SetPool(poolConfig)
+}
+
+func (b *BalanceServer) DeletePool(ctx context.Context, pool *api.Pool) (*emptypb.Empty, error) {
+	if b.slb == nil {
+		return nil, ErrNotConfigured
+	}
+	return &emptypb.Empty{}, b.slb.DeletePool(pool.Name)
+}
+
+func (b *BalanceServer) SetRoute(ctx context.Context, route *api.Route) (*emptypb.Empty, error) {
+	if b.slb == nil {
+		return nil, ErrNotConfigured
+	}
+	return &emptypb.Empty{}, b.slb.SetRoute(routeFromApi(route))
+}
+
+func (b *BalanceServer) DeleteRoute(ctx context.Context, route *api.Route) (*emptypb.Empty, error) {
+	if b.slb == nil {
+		return nil, ErrNotConfigured
+	}
+	return &emptypb.Empty{}, b.slb.DeleteRoute(route.Name)
+}
+
+// Returns an interceptor that counts the calls of the api
+func (b *BalanceServer) MetricsInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		resp, err := handler(ctx, req)
+		if b.apiCalls != nil {
+			b.apiCalls.WithLabelValues(path.Base(info.FullMethod), status.Code(err).String()).Inc()
+		}
+		return resp, err
+	}
+}
+
+// Returns an interceptor that traces the calls of the api with the tracer of the slb,
+// continuing the W3C trace context of the call metadata.
+// Spans of calls that replace the slb (Configure) are ended after its tracer is shut down, and are not exported.
+func (b *BalanceServer) TracingInterceptor() grpc.UnaryServerInterceptor {
+	propagator := propagation.TraceContext{}
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		tracer := noop.NewTracerProvider().Tracer("")
+		if b.slb != nil {
+			tracer = b.slb.Tracer()
+		}
+		md, _ := metadata.FromIncomingContext(ctx)
+		ctx = propagator.Extract(ctx, metadataCarrier(md))
+		service, method := path.Split(info.FullMethod)
+		ctx, span := tracer.Start(ctx, strings.TrimPrefix(info.FullMethod, "/"), trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(semconv.RPCSystemGRPC, semconv.RPCService(strings.Trim(service, "/")), semconv.RPCMethod(method)))
+		defer span.End()
+
+		resp, err := handler(ctx, req)
+		span.SetAttributes(semconv.RPCGRPCStatusCodeKey.Int(int(status.Code(err))))
+		if err != nil {
+			span.SetStatus(codes.Error, err.Error())
+		}
+		return resp, err
+	}
+}
+
+// metadataCarrier adapts grpc metadata to a propagation.TextMapCarrier
+type metadataCarrier metadata.MD
+
+func (m metadataCarrier) Get(key string) string {
+	if values := metadata.MD(m).Get(key); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+func (m metadataCarrier) Set(key string, value string) {
+	metadata.MD(m).Set(key, value)
+}
+
+func (m metadataCarrier) Keys() []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
 func NewBalanceService() *BalanceServer {
-	slbServer := &BalanceServer{}
+	slbServer := &BalanceServer{apiCalls: newApiCalls()}
 	ctx, cancelFunc := context.WithCancel(context.Background())
 	defer cancelFunc()
 	// Configure with default values
@@ -139,3 +336,654 @@ func NewBalanceService() *BalanceServer {
 	})
 	return slbServer
 }
+
+func newApiCalls() *prometheus.CounterVec {
+	return prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "balance",
+		Name:      "api_calls_total",
+		Help:      "Calls of the balance api by method and status code.",
+	}, []string{"method", "code"})
+}
+
+// returns a new selector of strategy, round robin if the strategy is unspecified
+func newSelector(strategy api.SelectorStrategy) slb.Selector {
+	switch strategy {
+	case api.SelectorStrategy_SELECTOR_STRATEGY_ROUND_ROBIN:
+		return roundRobin.New()
+	case api.SelectorStrategy_SELECTOR_STRATEGY_RANDOM:
+		return randomSelector.New()
+	case api.SelectorStrategy_SELECTOR_STRATEGY_WEIGHTED_ROUND_ROBIN:
+		return weightedRoundRobin.New()
+	case api.SelectorStrategy_SELECTOR_STRATEGY_LEAST_CONNECTIONS:
+		return leastConnections.New()
+	case api.SelectorStrategy_SELECTOR_STRATEGY_WEIGHTED_LEAST_CONNECTIONS:
+		return leastConnections.NewWeighted()
+	case api.SelectorStrategy_SELECTOR_STRATEGY_CONSISTENT_HASH:
+		return consistentHash.New()
+	case api.SelectorStrategy_SELECTOR_STRATEGY_POWER_OF_TWO_CHOICES:
+		return p2c.New()
+	case api.SelectorStrategy_SELECTOR_STRATEGY_WEIGHTED_RANDOM:
+		return randomSelector.NewWeighted()
+	default:
+		return roundRobin.New()
+	}
+}
+
+// returns the strategy of selector
+func strategyOf(selector slb.Selector) api.SelectorStrategy {
+	strategy := api.SelectorStrategy_SELECTOR_STRATEGY_UNSPECIFIED
+	t := reflect.TypeOf(selector)
+	if reflect.TypeOf(&roundRobin.RoundRobin{}) == t {
+		strategy = api.SelectorStrategy_SELECTOR_STRATEGY_ROUND_ROBIN
+	}
+	if reflect.TypeOf(&randomSelector.Random{}) == t {
+		strategy = api.SelectorStrategy_SELECTOR_STRATEGY_RANDOM
+	}
+	if reflect.TypeOf(&weightedRoundRobin.WeightedRoundRobin{}) == t {
+		strategy = api.SelectorStrategy_SELECTOR_STRATEGY_WEIGHTED_ROUND_ROBIN
+	}
+	if reflect.TypeOf(&leastConnections.LeastConnections{}) == t {
+		strategy = api.SelectorStrategy_SELECTOR_STRATEGY_LEAST_CONNECTIONS
+	}
+	if reflect.TypeOf(&leastConnections.WeightedLeastConnections{}) == t {
+		strategy = api.SelectorStrategy_SELECTOR_STRATEGY_WEIGHTED_LEAST_CONNECTIONS
+	}
+	if reflect.TypeOf(&consistentHash.ConsistentHash{}) == t {
+		strategy = api.SelectorStrategy_SELECTOR_STRATEGY_CONSISTENT_HASH
+	}
+	if reflect.TypeOf(&p2c.P2C{}) == t {
+		strategy = api.SelectorStrategy_SELECTOR_STRATEGY_POWER_OF_TWO_CHOICES
+	}
+	if reflect.TypeOf(&randomSelector.WeightedRandom{}) == t {
+		strategy = api.SelectorStrategy_SELECTOR_STRATEGY_WEIGHTED_RANDOM
+	}
+	return strategy
+}
+
+// returns the endpoints of servers, and the tls of the servers that set it by address
+func serversFromApi(servers []*api.Server) ([]*slb.Endpoint, map[string]slb.UpstreamTLSConfig) {
+	endpoints := make([]*slb.Endpoint, 0, len(servers))
+	var endpointTLS map[string]slb.UpstreamTLSConfig
+	for _, server := range servers {
+		endpoint := serverFromApi(server)
+		endpoints = append(endpoints, endpoint)
+		if server.Tls != nil {
+			if endpointTLS == nil {
+				endpointTLS = make(map[string]slb.UpstreamTLSConfig)
+			}
+			endpointTLS[endpoint.Identity()] = upstreamTlsFromApi(server.Tls)
+		}
+	}
+	return endpoints, endpointTLS
+}
+
+// returns the endpoint of server, the state set by Configuration is ignored
+func serverFromApi(server *api.Server) *slb.Endpoint {
+	return &slb.Endpoint{
+		ID:     server.Id,
+		Addr:   serverAddress(server),
+		Weight: int(server.Weight),
+		Zone:   server.Zone,
+		Labels: server.Labels,
+	}
+}
+
+// returns the address of server, that is composed of its host, port and scheme if the host is set
+func serverAddress(server *api.Server) string {
+	if server.Host == "" {
+		return server.Address
+	}
+	addr := server.Host
+	if server.Port != 0 {
+		addr = net.JoinHostPort(server.Host, strconv.FormatUint(uint64(server.Port), 10))
+	}
+	if server.Scheme != "" {
+		addr = server.Scheme + "://" + addr
+	}
+	return addr
+}
+
+// returns the servers of endpoints, with the tls and drain status of cfg
+func serversToApi(endpoints []*slb.Endpoint, cfg slb.Config) []*api.Server {
+	servers := []*api.Server{}
+	for _, endpoint := range endpoints {
+		server := &api.Server{
+			Id:      endpoint.ID,
+			Address: endpoint.Addr,
+			Weight:  uint32(endpoint.Weight),
+			Zone:    endpoint.Zone,
+			Labels:  endpoint.Labels,
+		}
+		if url, err := url.Parse(endpoint.Addr); err == nil && url.Scheme != "" {
+			server.Host, server.Scheme = url.Hostname(), url.Scheme
+			if port, err := strconv.ParseUint(url.Port(), 10, 16); err == nil {
+				server.Port = uint32(port)
+			}
+		}
+		if tls, ok := cfg.EndpointTLS[endpoint.Identity()]; ok {
+			server.Tls = upstreamTlsToApi(tls)
+		}
+		if endpoint.Health != nil {
+			server.Health = healthStatusToApi(*endpoint.Health)
+		}
+		if endpoint.Stats != nil {
+			server.Stats = &api.EndpointStats{
+				Requests: endpoint.Stats.Requests,
+				Failures: endpoint.Stats.Failures,
+				InFlight: uint32(endpoint.Stats.InFlight),
+			}
+		}
+		if status, ok := cfg.Draining[endpoint.Identity()]; ok {
+			server.Drain = &api.DrainStatus{
+				InFlight: uint32(status.InFlight),
+				Started:  timestamppb.New(status.Started),
+				Deadline: timestamppb.New(status.Deadline),
+			}
+		}
+		servers = append(servers, server)
+	}
+	return servers
+}
+
+// returns the configuration of pool, and the tls of its servers that set it by address
+func poolFromApi(pool *api.Pool) (slb.PoolConfig, map[string]slb.UpstreamTLSConfig) {
+	endpoints, endpointTLS := serversFromApi(pool.Endpoints)
+	return slb.PoolConfig{
+		Name:      pool.Name,
+		Endpoints: endpoints,
+		Discovery: discoveryFromApi(pool.Discovery),
+		Selector:  newSelector(pool.Strategy),
+	}, endpointTLS
+}
+
+func routeFromApi(route *api.Route) slb.RouteConfig {
+	return slb.RouteConfig{
+		Name:       route.Name,
+		Host:       route.Host,
+		PathPrefix: route.PathPrefix,
+		PathRegex:  route.PathRegex,
+		Methods:    route.Methods,
+		Headers:    route.Headers,
+		Pool:       route.Pool,
+	}
+}
+
+func routeToApi(route slb.RouteConfig) *api.Route {
+	return &api.Route{
+		Name:       route.Name,
+		Host:       route.Host,
+		PathPrefix: route.PathPrefix,
+		PathRegex:  route.PathRegex,
+		Methods:    route.Methods,
+		Headers:    route.Headers,
+		Pool:       route.Pool,
+	}
+}
+
+func healthCheckFromApi(healthCheck *api.HealthCheck) slb.HealthCheckConfig {
+	if healthCheck == nil {
+		return slb.HealthCheckConfig{}
+	}
+	return slb.HealthCheckConfig{
+		Path:               healthCheck.Path,
+		Interval:           healthCheck.Interval.AsDuration(),
+		Timeout:            healthCheck.Timeout.AsDuration(),
+		HealthyThreshold:   int(healthCheck.HealthyThreshold),
+		UnhealthyThreshold: int(healthCheck.UnhealthyThreshold),
+	}
+}
+
+func healthCheckToApi(healthCheck slb.HealthCheckConfig) *api.HealthCheck {
+	if !healthCheck.Enabled() {
+		return nil
+	}
+	return &api.HealthCheck{
+		Path:               healthCheck.Path,
+		Interval:           durationpb.New(healthCheck.Interval),
+		Timeout:            durationpb.New(healthCheck.Timeout),
+		HealthyThreshold:   uint32(healthCheck.HealthyThreshold),
+		UnhealthyThreshold: uint32(healthCheck.UnhealthyThreshold),
+	}
+}
+
+func outlierDetectionFromApi(outlierDetection *api.OutlierDetection) slb.OutlierDetectionConfig {
+	if outlierDetection == nil {
+		return slb.OutlierDetectionConfig{}
+	}
+	return slb.OutlierDetectionConfig{
+		ConsecutiveFailures: int(outlierDetection.ConsecutiveFailures),
+		BaseEjectionTime:    outlierDetection.BaseEjectionTime.AsDuration(),
+		MaxEjectionTime:     outlierDetection.MaxEjectionTime.AsDuration(),
+		MaxEjectionPercent:  int(outlierDetection.MaxEjectionPercent),
+	}
+}
+
+func outlierDetectionToApi(outlierDetection slb.OutlierDetectionConfig) *api.OutlierDetection {
+	if !outlierDetection.Enabled() {
+		return nil
+	}
+	return &api.OutlierDetection{
+		ConsecutiveFailures: uint32(outlierDetection.ConsecutiveFailures),
+		BaseEjectionTime:    durationpb.New(outlierDetection.BaseEjectionTime),
+		MaxEjectionTime:     durationpb.New(outlierDetection.MaxEjectionTime),
+		MaxEjectionPercent:  uint32(outlierDetection.MaxEjectionPercent),
+	}
+}
+
+func slowStartFromApi(slowStart *api.SlowStart) slb.SlowStartConfig {
+	if slowStart == nil {
+		return slb.SlowStartConfig{}
+	}
+	return slb.SlowStartConfig{
+		Duration:         slowStart.Duration.AsDuration(),
+		MinWeightPercent: int(slowStart.MinWeightPercent),
+		Aggression:       slowStart.Aggression,
+	}
+}
+
+func slowStartToApi(slowStart slb.SlowStartConfig) *api.SlowStart {
+	if !slowStart.Enabled() {
+		return nil
+	}
+	return &api.SlowStart{
+		Duration:         durationpb.New(slowStart.Duration),
+		MinWeightPercent: uint32(slowStart.MinWeightPercent),
+		Aggression:       slowStart.Aggression,
+	}
+}
+
+var hashKeySources = map[api.HashKeySource]slb.HashKeySource{
+	api.HashKeySource_HASH_KEY_SOURCE_UNSPECIFIED: "",
+	api.HashKeySource_HASH_KEY_SOURCE_CLIENT_IP:   slb.HashKeySourceClientIP,
+	api.HashKeySource_HASH_KEY_SOURCE_HEADER:      slb.HashKeySourceHeader,
+	api.HashKeySource_HASH_KEY_SOURCE_COOKIE:      slb.HashKeySourceCookie,
+	api.HashKeySource_HASH_KEY_SOURCE_PATH:        slb.HashKeySourcePath,
+}
+
+func hashKeyFromApi(hashKey *api.HashKey) slb.HashKeyConfig {
+	if hashKey == nil {
+		return slb.HashKeyConfig{}
+	}
+	return slb.HashKeyConfig{
+		Source: hashKeySources[hashKey.Source],
+		Name:   hashKey.Name,
+	}
+}
+
+func hashKeyToApi(hashKey slb.HashKeyConfig) *api.HashKey {
+	if hashKey.Source == "" {
+		return nil
+	}
+	for source, s := range hashKeySources {
+		if s == hashKey.Source {
+			return &api.HashKey{Source: source, Name: hashKey.Name}
+		}
+	}
+	return nil
+}
+
+func sessionAffinityFromApi(sessionAffinity *api.SessionAffinity) slb.SessionAffinityConfig {
+	if sessionAffinity == nil {
+		return slb.SessionAffinityConfig{}
+	}
+	return slb.SessionAffinityConfig{
+		CookieName: sessionAffinity.CookieName,
+		TTL:        sessionAffinity.Ttl.AsDuration(),
+		SigningKey: sessionAffinity.SigningKey,
+	}
+}
+
+// the signing key is not returned
+func sessionAffinityToApi(sessionAffinity slb.SessionAffinityConfig) *api.SessionAffinity {
+	if !sessionAffinity.Enabled() {
+		return nil
+	}
+	return &api.SessionAffinity{
+		CookieName: sessionAffinity.CookieName,
+		Ttl:        durationpb.New(sessionAffinity.TTL),
+	}
+}
+
+func retryPolicyFromApi(retryPolicy *api.RetryPolicy) slb.RetryPolicyConfig {
+	if retryPolicy == nil {
+		return slb.RetryPolicyConfig{}
+	}
+	statusCodes := []int{}
+	for _, code := range retryPolicy.StatusCodes {
+		statusCodes = append(statusCodes, int(code))
+	}
+	return slb.RetryPolicyConfig{
+		MaxRetries:    int(retryPolicy.MaxRetries),
+		StatusCodes:   statusCodes,
+		MaxBodyBytes:  int64(retryPolicy.MaxBodyBytes),
+		BudgetPercent: int(retryPolicy.BudgetPercent),
+	}
+}
+
+func retryPolicyToApi(retryPolicy slb.RetryPolicyConfig) *api.RetryPolicy {
+	if !retryPolicy.Enabled() {
+		return nil
+	}
+	statusCodes := []uint32{}
+	for _, code := range retryPolicy.StatusCodes {
+		statusCodes = append(statusCodes, uint32(code))
+	}
+	return &api.RetryPolicy{
+		MaxRetries:    uint32(retryPolicy.MaxRetries),
+		StatusCodes:   statusCodes,
+		MaxBodyBytes:  uint64(retryPolicy.MaxBodyBytes),
+		BudgetPercent: uint32(retryPolicy.BudgetPercent),
+	}
+}
+
+// returns nil for a zero duration, so that it is omitted
+func optionalDurationToApi(d time.Duration) *durationpb.Duration {
+	if d == 0 {
+		return nil
+	}
+	return durationpb.New(d)
+}
+
+func errorResponsesFromApi(errorResponses *api.ErrorResponses) map[slb.ErrorKind]slb.ErrorResponseConfig {
+	if errorResponses == nil {
+		return nil
+	}
+	responses := map[slb.ErrorKind]slb.ErrorResponseConfig{}
+	for kind, response := range map[slb.ErrorKind]*api.ErrorResponse{
+		slb.ErrorNoEndpoints:       errorResponses.NoEndpoints,
+		slb.ErrorAllUnhealthy:      errorResponses.AllUnhealthy,
+		slb.ErrorUpstreamTimeout:   errorResponses.UpstreamTimeout,
+		slb.ErrorConnectionRefused: errorResponses.ConnectionRefused,
+		slb.ErrorUpstream:          errorResponses.Upstream,
+	} {
+		if response == nil {
+			continue
+		}
+		responses[kind] = slb.ErrorResponseConfig{
+			StatusCode:  int(response.StatusCode),
+			ContentType: response.ContentType,
+			Body:        response.Body,
+			RetryAfter:  response.RetryAfter.AsDuration(),
+		}
+	}
+	return responses
+}
+
+func errorResponsesToApi(errorResponses map[slb.ErrorKind]slb.ErrorResponseConfig) *api.ErrorResponses {
+	if len(errorResponses) == 0 {
+		return nil
+	}
+	toApi := func(kind slb.ErrorKind) *api.ErrorResponse {
+		response, ok := errorResponses[kind]
+		if !ok {
+			return nil
+		}
+		return &api.ErrorResponse{
+			StatusCode:  uint32(response.StatusCode),
+			ContentType: response.ContentType,
+			Body:        response.Body,
+			RetryAfter:  optionalDurationToApi(response.RetryAfter),
+		}
+	}
+	return &api.ErrorResponses{
+		NoEndpoints:       toApi(slb.ErrorNoEndpoints),
+		AllUnhealthy:      toApi(slb.ErrorAllUnhealthy),
+		UpstreamTimeout:   toApi(slb.ErrorUpstreamTimeout),
+		ConnectionRefused: toApi(slb.ErrorConnectionRefused),
+		Upstream:          toApi(slb.ErrorUpstream),
+	}
+}
+
+func metricsFromApi(metrics *api.Metrics) slb.MetricsConfig {
+	if metrics == nil {
+		return slb.MetricsConfig{}
+	}
+	return slb.MetricsConfig{
+		Port:    metrics.Port,
+		Address: metrics.Address,
+		Path:    metrics.Path,
+	}
+}
+
+func metricsToApi(metrics slb.MetricsConfig) *api.Metrics {
+	if !metrics.Enabled() {
+		return nil
+	}
+	return &api.Metrics{
+		Port:    metrics.Port,
+		Address: metrics.Address,
+		Path:    metrics.Path,
+	}
+}
+
+var accessLogFormats = map[api.AccessLogFormat]slb.AccessLogFormat{
+	api.AccessLogFormat_ACCESS_LOG_FORMAT_UNSPECIFIED: "",
+	api.AccessLogFormat_ACCESS_LOG_FORMAT_JSON:        slb.AccessLogJSON,
+	api.AccessLogFormat_ACCESS_LOG_FORMAT_COMMON:      slb.AccessLogCommon,
+	api.AccessLogFormat_ACCESS_LOG_FORMAT_COMBINED:    slb.AccessLogCombined,
+	api.AccessLogFormat_ACCESS_LOG_FORMAT_LOGFMT:      slb.AccessLogLogfmt,
+}
+
+func accessLogFromApi(accessLog *api.AccessLog) slb.AccessLogConfig {
+	if accessLog == nil {
+		return slb.AccessLogConfig{}
+	}
+	return slb.AccessLogConfig{
+		Output:          accessLog.Output,
+		Format:          accessLogFormats[accessLog.Format],
+		MaxFileBytes:    accessLog.MaxFileBytes,
+		MaxBackups:      int(accessLog.MaxBackups),
+		SampleRate:      accessLog.SampleRate,
+		Redact:          accessLog.Redact,
+		RequestIDHeader: accessLog.RequestIdHeader,
+	}
+}
+
+func accessLogToApi(accessLog slb.AccessLogConfig) *api.AccessLog {
+	if !accessLog.Enabled() {
+		return nil
+	}
+	apiAccessLog := &api.AccessLog{
+		Output:          accessLog.Output,
+		MaxFileBytes:    accessLog.MaxFileBytes,
+		MaxBackups:      uint32(accessLog.MaxBackups),
+		SampleRate:      accessLog.SampleRate,
+		Redact:          accessLog.Redact,
+		RequestIdHeader: accessLog.RequestIDHeader,
+	}
+	for format, f := range accessLogFormats {
+		if f == accessLog.Format {
+			apiAccessLog.Format = format
+		}
+	}
+	return apiAccessLog
+}
+
+var tracingExporters = map[api.TracingExporter]slb.TracingExporter{
+	api.TracingExporter_TRACING_EXPORTER_UNSPECIFIED: "",
+	api.TracingExporter_TRACING_EXPORTER_OTLP:        slb.TracingExporterOTLP,
+	api.TracingExporter_TRACING_EXPORTER_FILE:        slb.TracingExporterFile,
+}
+
+func tracingFromApi(tracing *api.Tracing) slb.TracingConfig {
+	if tracing == nil {
+		return slb.TracingConfig{}
+	}
+	return slb.TracingConfig{
+		Exporter:    tracingExporters[tracing.Exporter],
+		Endpoint:    tracing.Endpoint,
+		ServiceName: tracing.ServiceName,
+		SampleRatio: tracing.SampleRatio,
+	}
+}
+
+func tracingToApi(tracing slb.TracingConfig) *api.Tracing {
+	if !tracing.Enabled() {
+		return nil
+	}
+	apiTracing := &api.Tracing{
+		Endpoint:    tracing.Endpoint,
+		ServiceName: tracing.ServiceName,
+		SampleRatio: tracing.SampleRatio,
+	}
+	for exporter, e := range tracingExporters {
+		if e == tracing.Exporter {
+			apiTracing.Exporter = exporter
+		}
+	}
+	return apiTracing
+}
+
+func tlsFromApi(tls *api.Tls) slb.TLSConfig {
+	if tls == nil {
+		return slb.TLSConfig{}
+	}
+	cfg := slb.TLSConfig{
+		MinVersion:     tls.MinVersion,
+		CipherSuites:   tls.CipherSuites,
+		ReloadInterval: tls.ReloadInterval.AsDuration(),
+	}
+	for _, cert := range tls.Certificates {
+		cfg.Certificates = append(cfg.Certificates, slb.CertificateConfig{
+			CertFile: cert.CertFile,
+			KeyFile:  cert.KeyFile,
+			CertPEM:  cert.CertPem,
+			KeyPEM:   cert.KeyPem,
+		})
+	}
+	return cfg
+}
+
+func tlsToApi(tls slb.TLSConfig) *api.Tls {
+	if !tls.Enabled() {
+		return nil
+	}
+	apiTls := &api.Tls{
+		MinVersion:     tls.MinVersion,
+		CipherSuites:   tls.CipherSuites,
+		ReloadInterval: optionalDurationToApi(tls.ReloadInterval),
+	}
+	for _, cert := range tls.Certificates {
+		apiTls.Certificates = append(apiTls.Certificates, &api.Certificate{
+			CertFile: cert.CertFile,
+			KeyFile:  cert.KeyFile,
+			CertPem:  cert.CertPEM,
+		})
+	}
+	return apiTls
+}
+
+var dnsRecordTypes = map[api.DnsRecordType]slb.DNSRecordType{
+	api.DnsRecordType_DNS_RECORD_TYPE_UNSPECIFIED: "",
+	api.DnsRecordType_DNS_RECORD_TYPE_A:           slb.DNSRecordA,
+	api.DnsRecordType_DNS_RECORD_TYPE_SRV:         slb.DNSRecordSRV,
+}
+
+func discoveryFromApi(discovery *api.Discovery) slb.DiscoveryConfig {
+	if discovery == nil {
+		return slb.DiscoveryConfig{}
+	}
+	cfg := slb.DiscoveryConfig{}
+	if discovery.Dns != nil {
+		cfg.DNS = slb.DNSDiscoveryConfig{
+			Name:            discovery.Dns.Name,
+			RecordType:      dnsRecordTypes[discovery.Dns.RecordType],
+			Scheme:          discovery.Dns.Scheme,
+			Nameserver:      discovery.Dns.Nameserver,
+			RefreshInterval: discovery.Dns.RefreshInterval.AsDuration(),
+		}
+		if discovery.Dns.Port != 0 {
+			cfg.DNS.Port = strconv.FormatUint(uint64(discovery.Dns.Port), 10)
+		}
+	}
+	if discovery.File != nil {
+		cfg.File = slb.FileDiscoveryConfig{
+			Path:           discovery.File.Path,
+			ReloadInterval: discovery.File.ReloadInterval.AsDuration(),
+		}
+	}
+	if discovery.Docker != nil {
+		cfg.Docker = slb.DockerDiscoveryConfig{
+			Host:            discovery.Docker.Host,
+			Network:         discovery.Docker.Network,
+			RefreshInterval: discovery.Docker.RefreshInterval.AsDuration(),
+		}
+	}
+	return cfg
+}
+
+func discoveryToApi(discovery slb.DiscoveryConfig, status slb.DiscoveryStatus) *api.Discovery {
+	if !discovery.Enabled() {
+		return nil
+	}
+	apiDiscovery := &api.Discovery{LastError: status.LastError}
+	if !status.LastRefresh.IsZero() {
+		apiDiscovery.LastRefresh = timestamppb.New(status.LastRefresh)
+	}
+	if discovery.DNS.Enabled() {
+		apiDiscovery.Dns = &api.DnsDiscovery{
+			Name:            discovery.DNS.Name,
+			Scheme:          discovery.DNS.Scheme,
+			Nameserver:      discovery.DNS.Nameserver,
+			RefreshInterval: optionalDurationToApi(discovery.DNS.RefreshInterval),
+		}
+		for recordType, t := range dnsRecordTypes {
+			if t == discovery.DNS.RecordType {
+				apiDiscovery.Dns.RecordType = recordType
+			}
+		}
+		if port, err := strconv.ParseUint(discovery.DNS.Port, 10, 16); err == nil {
+			apiDiscovery.Dns.Port = uint32(port)
+		}
+	}
+	if discovery.File.Enabled() {
+		apiDiscovery.File = &api.FileDiscovery{
+			Path:           discovery.File.Path,
+			ReloadInterval: optionalDurationToApi(discovery.File.ReloadInterval),
+		}
+	}
+	if discovery.Docker.Enabled() {
+		apiDiscovery.Docker = &api.DockerDiscovery{
+			Host:            discovery.Docker.Host,
+			Network:         discovery.Docker.Network,
+			RefreshInterval: optionalDurationToApi(discovery.Docker.RefreshInterval),
+		}
+	}
+	return apiDiscovery
+}
+
+func upstreamTlsFromApi(tls *api.UpstreamTls) slb.UpstreamTLSConfig {
+	if tls == nil {
+		return slb.UpstreamTLSConfig{}
+	}
+	return slb.UpstreamTLSConfig{
+		CAFile:             tls.CaFile,
+		CertFile:           tls.CertFile,
+		KeyFile:            tls.KeyFile,
+		ServerName:         tls.ServerName,
+		InsecureSkipVerify: tls.InsecureSkipVerify,
+	}
+}
+
+func upstreamTlsToApi(tls slb.UpstreamTLSConfig) *api.UpstreamTls {
+	if tls == (slb.UpstreamTLSConfig{}) {
+		return nil
+	}
+	return &api.UpstreamTls{
+		CaFile:             tls.CAFile,
+		CertFile:           tls.CertFile,
+		KeyFile:            tls.KeyFile,
+		ServerName:         tls.ServerName,
+		InsecureSkipVerify: tls.InsecureSkipVerify,
+	}
+}
+
+func healthStatusToApi(status slb.HealthStatus) *api.HealthStatus {
+	return &api.HealthStatus{
+		Healthy:              status.Healthy,
+		ConsecutiveSuccesses: uint32(status.ConsecutiveSuccesses),
+		ConsecutiveFailures:  uint32(status.ConsecutiveFailures),
+		LastError:            status.LastError,
+		LastCheck:            timestamppb.New(status.LastCheck),
+	}
+}
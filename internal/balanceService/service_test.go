@@ -2,11 +2,34 @@ package balanceService
 
 import (
 	"balance/gen"
+	"balance/internal/mock"
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/require"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/durationpb"
 	"google.golang.org/protobuf/types/known/emptypb"
 )
 
@@ -86,6 +109,51 @@ func TestRunWithSLBShouldRunSuccessfully(t *testing.T) {
 	require.NoError(t, err)
 }
 
+func TestConfigureShouldReplaceRunningSLB(t *testing.T) {
+	_, balanceServer := setupServer()
+	backend := func(body string) string {
+		server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) { rw.Write([]byte(body)) }))
+		t.Cleanup(server.Close)
+		return server.URL
+	}
+	port := mock.RandomPort()
+	slbConfig := &gen.Config{
+		ListenAddress: localAddress,
+		ListenPort:    port,
+		Endpoints:     []*gen.Server{{Address: backend("first")}},
+		Strategy:      gen.SelectorStrategy_SELECTOR_STRATEGY_ROUND_ROBIN,
+	}
+	_, err := balanceServer.Configure(context.Background(), slbConfig)
+	require.NoError(t, err)
+	_, err = balanceServer.Run(context.Background(), &emptypb.Empty{})
+	require.NoError(t, err)
+	t.Cleanup(func() { balanceServer.Stop(context.Background(), &emptypb.Empty{}) })
+	get := func() string {
+		resp, err := http.Get("http://" + localAddress + ":" + port + "/")
+		if err != nil {
+			return err.Error()
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return string(body)
+	}
+	require.Eventually(t, func() bool { return get() == "first" }, time.Second, time.Millisecond*10)
+
+	slbConfig.Endpoints = []*gen.Server{{Address: backend("second")}}
+	slbConfig.Strategy = gen.SelectorStrategy_SELECTOR_STRATEGY_RANDOM
+	_, err = balanceServer.Configure(context.Background(), slbConfig)
+	require.NoError(t, err)
+	require.Equal(t, "second", get(), "the new configuration is served without running the SLB again")
+
+	slbConfig.Endpoints = nil
+	_, err = balanceServer.Configure(context.Background(), slbConfig)
+	require.Error(t, err)
+	require.Equal(t, "second", get(), "the running SLB is kept if the configuration is invalid")
+	config, err := balanceServer.Configuration(context.Background(), &emptypb.Empty{})
+	require.NoError(t, err)
+	require.Equal(t, gen.SelectorStrategy_SELECTOR_STRATEGY_RANDOM, config.Strategy)
+}
+
 func TestStopNoSLBShouldReturnError(t *testing.T) {
 	_, balanceServer := setupServer()
 
@@ -155,3 +223,736 @@ func TestRemoveWithSelectorShouldRemoveServer(t *testing.T) {
 	_, err := balanceServer.Remove(context.Background(), &gen.Server{Address: localAddress + serverPort})
 	require.NoError(t, err)
 }
+
+func TestDrainShouldRemoveServer(t *testing.T) {
+	_, balanceServer := setupServer()
+	_, err := balanceServer.Drain(context.Background(), &gen.DrainRequest{Server: &gen.Server{Address: localAddress}})
+	require.Equal(t, ErrNotConfigured, err)
+
+	slbConfig := &gen.Config{
+		ListenAddress: localAddress,
+		ListenPort:    defaultPort,
+		Endpoints:     []*gen.Server{{Address: localAddress}, {Address: localAddress + serverPort}},
+		Strategy:      gen.SelectorStrategy_SELECTOR_STRATEGY_RANDOM,
+	}
+	_, err = balanceServer.Configure(context.Background(), slbConfig)
+	require.NoError(t, err)
+	_, err = balanceServer.Drain(context.Background(), &gen.DrainRequest{})
+	require.Equal(t, ErrNoServer, err)
+	_, err = balanceServer.Drain(context.Background(), &gen.DrainRequest{Server: &gen.Server{Address: "127.0.0.2"}})
+	require.Error(t, err, "unknown servers cannot be drained")
+
+	_, err = balanceServer.Drain(context.Background(), &gen.DrainRequest{
+		Server:  &gen.Server{Address: localAddress + serverPort},
+		Timeout: durationpb.New(time.Minute),
+	})
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		config, err := balanceServer.Configuration(context.Background(), &emptypb.Empty{})
+		return err == nil && len(config.Endpoints) == 1 && config.Endpoints[0].Drain == nil
+	}, time.Second, time.Millisecond*10, "a server without requests in flight is removed")
+}
+
+func TestConfigurationShouldReturnHealthCheck(t *testing.T) {
+	_, balanceServer := setupServer()
+	slbConfig := &gen.Config{
+		ListenAddress: localAddress,
+		ListenPort:    defaultPort,
+		Endpoints:     []*gen.Server{{Address: localAddress}},
+		HealthCheck: &gen.HealthCheck{
+			Path:               "/health",
+			Interval:           durationpb.New(time.Second * 5),
+			Timeout:            durationpb.New(time.Second),
+			HealthyThreshold:   1,
+			UnhealthyThreshold: 2,
+		},
+	}
+	_, err := balanceServer.Configure(context.Background(), slbConfig)
+	require.NoError(t, err)
+	config, err := balanceServer.Configuration(context.Background(), &emptypb.Empty{})
+
+	require.NoError(t, err)
+	require.True(t, proto.Equal(slbConfig.HealthCheck, config.HealthCheck))
+}
+
+func TestConfigureShouldRejectInvalidHealthCheck(t *testing.T) {
+	_, balanceServer := setupServer()
+	slbConfig := &gen.Config{
+		ListenAddress: localAddress,
+		ListenPort:    defaultPort,
+		Endpoints:     []*gen.Server{{Address: localAddress}},
+		HealthCheck:   &gen.HealthCheck{Path: "health"},
+	}
+	_, err := balanceServer.Configure(context.Background(), slbConfig)
+	require.Error(t, err)
+}
+
+func TestConfigurationShouldReturnOutlierDetection(t *testing.T) {
+	_, balanceServer := setupServer()
+	slbConfig := &gen.Config{
+		ListenAddress: localAddress,
+		ListenPort:    defaultPort,
+		Endpoints:     []*gen.Server{{Address: localAddress}},
+		OutlierDetection: &gen.OutlierDetection{
+			ConsecutiveFailures: 5,
+			BaseEjectionTime:    durationpb.New(time.Second * 30),
+			MaxEjectionTime:     durationpb.New(time.Minute * 5),
+			MaxEjectionPercent:  20,
+		},
+	}
+	_, err := balanceServer.Configure(context.Background(), slbConfig)
+	require.NoError(t, err)
+	config, err := balanceServer.Configuration(context.Background(), &emptypb.Empty{})
+
+	require.NoError(t, err)
+	require.True(t, proto.Equal(slbConfig.OutlierDetection, config.OutlierDetection))
+}
+
+func TestConfigurationShouldReturnSlowStart(t *testing.T) {
+	_, balanceServer := setupServer()
+	slbConfig := &gen.Config{
+		ListenAddress: localAddress,
+		ListenPort:    defaultPort,
+		Endpoints:     []*gen.Server{{Address: localAddress}},
+		SlowStart: &gen.SlowStart{
+			Duration:         durationpb.New(time.Minute),
+			MinWeightPercent: 20,
+			Aggression:       2,
+		},
+	}
+	_, err := balanceServer.Configure(context.Background(), slbConfig)
+	require.NoError(t, err)
+	_, err = balanceServer.Add(context.Background(), &gen.Server{Address: "127.0.0.2", SlowStart: durationpb.New(time.Second)})
+	require.NoError(t, err)
+	_, err = balanceServer.Add(context.Background(), &gen.Server{Address: "127.0.0.3", SlowStart: durationpb.New(-time.Second)})
+	require.Error(t, err)
+	config, err := balanceServer.Configuration(context.Background(), &emptypb.Empty{})
+
+	require.NoError(t, err)
+	require.True(t, proto.Equal(slbConfig.SlowStart, config.SlowStart))
+	require.Len(t, config.Endpoints, 2)
+}
+
+func TestAddWithSLBShouldSetServerProxy(t *testing.T) {
+	_, balanceServer := setupServer()
+	slbConfig := &gen.Config{
+		ListenAddress: localAddress,
+		ListenPort:    defaultPort,
+		Endpoints:     []*gen.Server{{Address: localAddress}},
+	}
+	_, err := balanceServer.Configure(context.Background(), slbConfig)
+	require.NoError(t, err)
+	_, err = balanceServer.Add(context.Background(), &gen.Server{Address: "127.0.0.2"})
+	require.NoError(t, err)
+
+	endpoints, err := balanceServer.selector.EndPoints()
+	require.NoError(t, err)
+	require.Len(t, endpoints, 2)
+	for _, endpoint := range endpoints {
+		require.NotNil(t, endpoint.Handler)
+	}
+}
+
+func TestWeightedStrategiesShouldSetWeights(t *testing.T) {
+	for _, strategy := range []gen.SelectorStrategy{
+		gen.SelectorStrategy_SELECTOR_STRATEGY_WEIGHTED_ROUND_ROBIN,
+		gen.SelectorStrategy_SELECTOR_STRATEGY_WEIGHTED_RANDOM,
+	} {
+		_, balanceServer := setupServer()
+		slbConfig := &gen.Config{
+			ListenAddress: localAddress,
+			ListenPort:    defaultPort,
+			Endpoints:     []*gen.Server{{Address: localAddress, Weight: 3}, {Address: "127.0.0.2"}},
+			Strategy:      strategy,
+		}
+		_, err := balanceServer.Configure(context.Background(), slbConfig)
+		require.NoError(t, err)
+
+		// adding an existing server updates its weight
+		_, err = balanceServer.Add(context.Background(), &gen.Server{Address: "127.0.0.2", Weight: 2})
+		require.NoError(t, err)
+		_, err = balanceServer.Add(context.Background(), &gen.Server{Address: "127.0.0.3", Weight: 5})
+		require.NoError(t, err)
+
+		config, err := balanceServer.Configuration(context.Background(), &emptypb.Empty{})
+		require.NoError(t, err)
+		require.Exactly(t, strategy, config.Strategy)
+		weights := []uint32{}
+		for _, endpoint := range config.Endpoints {
+			weights = append(weights, endpoint.Weight)
+		}
+		require.Equal(t, []uint32{3, 2, 5}, weights)
+	}
+}
+
+func TestConfigurationShouldReturnEndpoints(t *testing.T) {
+	_, balanceServer := setupServer()
+	slbConfig := &gen.Config{
+		ListenAddress: localAddress,
+		ListenPort:    defaultPort,
+		Endpoints: []*gen.Server{
+			{Address: localAddress, Id: "backend-1", Zone: "a", Labels: map[string]string{"version": "2"}},
+			{Address: "127.0.0.2"},
+		},
+		Strategy: gen.SelectorStrategy_SELECTOR_STRATEGY_ROUND_ROBIN,
+	}
+	_, err := balanceServer.Configure(context.Background(), slbConfig)
+	require.NoError(t, err)
+
+	config, err := balanceServer.Configuration(context.Background(), &emptypb.Empty{})
+	require.NoError(t, err)
+	require.Len(t, config.Endpoints, 2)
+	endpoint := config.Endpoints[0]
+	require.Equal(t, "backend-1", endpoint.Id)
+	require.Equal(t, "a", endpoint.Zone)
+	require.Equal(t, map[string]string{"version": "2"}, endpoint.Labels)
+	require.NotNil(t, endpoint.Stats)
+	require.Zero(t, endpoint.Stats.Requests)
+
+	// the server is removed by its address, though the selector keeps another instance of it
+	_, err = balanceServer.Remove(context.Background(), &gen.Server{Address: "127.0.0.2"})
+	require.NoError(t, err)
+	config, err = balanceServer.Configuration(context.Background(), &emptypb.Empty{})
+	require.NoError(t, err)
+	require.Len(t, config.Endpoints, 1)
+	require.Equal(t, "backend-1", config.Endpoints[0].Id)
+}
+
+func TestAddWithWeightShouldFailWithoutWeightedStrategy(t *testing.T) {
+	_, balanceServer := setupServer()
+	slbConfig := &gen.Config{
+		ListenAddress: localAddress,
+		ListenPort:    defaultPort,
+		Endpoints:     []*gen.Server{{Address: localAddress}},
+		Strategy:      gen.SelectorStrategy_SELECTOR_STRATEGY_ROUND_ROBIN,
+	}
+	_, err := balanceServer.Configure(context.Background(), slbConfig)
+	require.NoError(t, err)
+	_, err = balanceServer.Add(context.Background(), &gen.Server{Address: "127.0.0.2", Weight: 2})
+	require.Error(t, err)
+}
+
+func TestConfigureShouldSetTrackingStrategies(t *testing.T) {
+	for _, strategy := range []gen.SelectorStrategy{
+		gen.SelectorStrategy_SELECTOR_STRATEGY_LEAST_CONNECTIONS,
+		gen.SelectorStrategy_SELECTOR_STRATEGY_WEIGHTED_LEAST_CONNECTIONS,
+		gen.SelectorStrategy_SELECTOR_STRATEGY_POWER_OF_TWO_CHOICES,
+	} {
+		_, balanceServer := setupServer()
+		slbConfig := &gen.Config{
+			ListenAddress: localAddress,
+			ListenPort:    defaultPort,
+			Endpoints:     []*gen.Server{{Address: localAddress}},
+			Strategy:      strategy,
+		}
+		_, err := balanceServer.Configure(context.Background(), slbConfig)
+		require.NoError(t, err)
+		config, err := balanceServer.Configuration(context.Background(), &emptypb.Empty{})
+		require.NoError(t, err)
+		require.Exactly(t, strategy, config.Strategy)
+	}
+}
+
+func TestConfigureShouldSetConsistentHash(t *testing.T) {
+	_, balanceServer := setupServer()
+	slbConfig := &gen.Config{
+		ListenAddress: localAddress,
+		ListenPort:    defaultPort,
+		Endpoints:     []*gen.Server{{Address: localAddress}},
+		Strategy:      gen.SelectorStrategy_SELECTOR_STRATEGY_CONSISTENT_HASH,
+		HashKey:       &gen.HashKey{Source: gen.HashKeySource_HASH_KEY_SOURCE_HEADER, Name: "X-User-ID"},
+	}
+	_, err := balanceServer.Configure(context.Background(), slbConfig)
+	require.NoError(t, err)
+	config, err := balanceServer.Configuration(context.Background(), &emptypb.Empty{})
+	require.NoError(t, err)
+	require.Exactly(t, gen.SelectorStrategy_SELECTOR_STRATEGY_CONSISTENT_HASH, config.Strategy)
+	require.True(t, proto.Equal(slbConfig.HashKey, config.HashKey))
+
+	slbConfig.HashKey = &gen.HashKey{Source: gen.HashKeySource_HASH_KEY_SOURCE_COOKIE}
+	_, err = balanceServer.Configure(context.Background(), slbConfig)
+	require.Error(t, err, "cookie name is required")
+}
+
+func TestConfigurationShouldNotReturnSigningKey(t *testing.T) {
+	_, balanceServer := setupServer()
+	logs := &strings.Builder{}
+	logger := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(logs, nil)))
+	t.Cleanup(func() { slog.SetDefault(logger) })
+	slbConfig := &gen.Config{
+		ListenAddress: localAddress,
+		ListenPort:    defaultPort,
+		Endpoints:     []*gen.Server{{Address: localAddress}},
+		SessionAffinity: &gen.SessionAffinity{
+			CookieName: "balance",
+			Ttl:        durationpb.New(time.Hour),
+			SigningKey: "secret",
+		},
+	}
+	_, err := balanceServer.Configure(context.Background(), slbConfig)
+	require.NoError(t, err)
+	config, err := balanceServer.Configuration(context.Background(), &emptypb.Empty{})
+	require.NoError(t, err)
+	require.Equal(t, "balance", config.SessionAffinity.CookieName)
+	require.Equal(t, time.Hour, config.SessionAffinity.Ttl.AsDuration())
+	require.Empty(t, config.SessionAffinity.SigningKey)
+	require.Contains(t, logs.String(), "Setting new configuration")
+	require.NotContains(t, logs.String(), "secret", "the signing key is not logged")
+}
+
+func TestConfigureShouldSetPoolsAndRoutes(t *testing.T) {
+	_, balanceServer := setupServer()
+	slbConfig := &gen.Config{
+		ListenAddress: localAddress,
+		ListenPort:    defaultPort,
+		Endpoints:     []*gen.Server{{Address: localAddress}},
+		Strategy:      gen.SelectorStrategy_SELECTOR_STRATEGY_ROUND_ROBIN,
+		Pools: []*gen.Pool{{
+			Name:      "api",
+			Strategy:  gen.SelectorStrategy_SELECTOR_STRATEGY_WEIGHTED_ROUND_ROBIN,
+			Endpoints: []*gen.Server{{Address: "127.0.0.2", Weight: 2}},
+		}},
+		Routes: []*gen.Route{{Name: "api", Host: "api.example.com", PathPrefix: "/v1", Pool: "api"}},
+	}
+	_, err := balanceServer.Configure(context.Background(), slbConfig)
+	require.NoError(t, err)
+	config, err := balanceServer.Configuration(context.Background(), &emptypb.Empty{})
+	require.NoError(t, err)
+	require.Len(t, config.Pools, 1)
+	require.Equal(t, "api", config.Pools[0].Name)
+	require.Exactly(t, gen.SelectorStrategy_SELECTOR_STRATEGY_WEIGHTED_ROUND_ROBIN, config.Pools[0].Strategy)
+	require.Len(t, config.Pools[0].Endpoints, 1)
+	require.Equal(t, uint32(2), config.Pools[0].Endpoints[0].Weight)
+	require.Len(t, config.Routes, 1)
+	require.True(t, proto.Equal(slbConfig.Routes[0], config.Routes[0]))
+
+	slbConfig.Routes[0].Pool = "missing"
+	_, err = balanceServer.Configure(context.Background(), slbConfig)
+	require.Error(t, err)
+}
+
+func TestPoolAndRouteRPCs(t *testing.T) {
+	_, balanceServer := setupServer()
+	ctx := context.Background()
+	_, err := balanceServer.SetPool(ctx, &gen.Pool{Name: "api"})
+	require.ErrorIs(t, err, ErrNotConfigured)
+
+	_, err = balanceServer.Configure(ctx, &gen.Config{
+		ListenAddress: localAddress,
+		ListenPort:    defaultPort,
+		Endpoints:     []*gen.Server{{Address: localAddress}},
+	})
+	require.NoError(t, err)
+
+	_, err = balanceServer.SetRoute(ctx, &gen.Route{Name: "api", PathPrefix: "/api", Pool: "api"})
+	require.Error(t, err, "pool does not exist")
+	_, err = balanceServer.SetPool(ctx, &gen.Pool{Name: "api", Endpoints: []*gen.Server{{Address: "127.0.0.2"}}})
+	require.NoError(t, err)
+	_, err = balanceServer.SetRoute(ctx, &gen.Route{Name: "api", PathPrefix: "/api", Pool: "api"})
+	require.NoError(t, err)
+
+	config, err := balanceServer.Configuration(ctx, &emptypb.Empty{})
+	require.NoError(t, err)
+	require.Len(t, config.Pools, 1)
+	require.Exactly(t, gen.SelectorStrategy_SELECTOR_STRATEGY_ROUND_ROBIN, config.Pools[0].Strategy)
+	require.Len(t, config.Routes, 1)
+
+	_, err = balanceServer.DeletePool(ctx, &gen.Pool{Name: "api"})
+	require.Error(t, err, "pool is used by a route")
+	_, err = balanceServer.DeleteRoute(ctx, &gen.Route{Name: "api"})
+	require.NoError(t, err)
+	_, err = balanceServer.DeletePool(ctx, &gen.Pool{Name: "api"})
+	require.NoError(t, err)
+
+	config, err = balanceServer.Configuration(ctx, &emptypb.Empty{})
+	require.NoError(t, err)
+	require.Empty(t, config.Pools)
+	require.Empty(t, config.Routes)
+}
+
+func TestConfigurationShouldReturnRetryPolicy(t *testing.T) {
+	_, balanceServer := setupServer()
+	slbConfig := &gen.Config{
+		ListenAddress: localAddress,
+		ListenPort:    defaultPort,
+		Endpoints:     []*gen.Server{{Address: localAddress}},
+		RetryPolicy: &gen.RetryPolicy{
+			MaxRetries:    2,
+			StatusCodes:   []uint32{502, 503},
+			MaxBodyBytes:  1024,
+			BudgetPercent: 10,
+		},
+	}
+	_, err := balanceServer.Configure(context.Background(), slbConfig)
+	require.NoError(t, err)
+	config, err := balanceServer.Configuration(context.Background(), &emptypb.Empty{})
+	require.NoError(t, err)
+	require.True(t, proto.Equal(slbConfig.RetryPolicy, config.RetryPolicy))
+
+	slbConfig.RetryPolicy.BudgetPercent = 101
+	_, err = balanceServer.Configure(context.Background(), slbConfig)
+	require.Error(t, err)
+}
+
+func TestConfigurationShouldReturnErrorResponses(t *testing.T) {
+	_, balanceServer := setupServer()
+	slbConfig := &gen.Config{
+		ListenAddress:   localAddress,
+		ListenPort:      defaultPort,
+		Endpoints:       []*gen.Server{{Address: localAddress}},
+		UpstreamTimeout: durationpb.New(time.Second * 5),
+		ErrorResponses: &gen.ErrorResponses{
+			NoEndpoints: &gen.ErrorResponse{
+				StatusCode:  503,
+				ContentType: "application/json",
+				Body:        `{"error": {{json .Error}}}`,
+				RetryAfter:  durationpb.New(time.Second * 30),
+			},
+			UpstreamTimeout: &gen.ErrorResponse{StatusCode: 504},
+		},
+	}
+	_, err := balanceServer.Configure(context.Background(), slbConfig)
+	require.NoError(t, err)
+	config, err := balanceServer.Configuration(context.Background(), &emptypb.Empty{})
+	require.NoError(t, err)
+	require.True(t, proto.Equal(slbConfig.ErrorResponses, config.ErrorResponses))
+	require.Equal(t, time.Second*5, config.UpstreamTimeout.AsDuration())
+
+	slbConfig.ErrorResponses.Upstream = &gen.ErrorResponse{Body: "{{.Error"}
+	_, err = balanceServer.Configure(context.Background(), slbConfig)
+	require.Error(t, err)
+}
+
+func TestMetricsInterceptorShouldCountApiCalls(t *testing.T) {
+	_, balanceServer := setupServer()
+	balanceServer.apiCalls = newApiCalls()
+	slbConfig := &gen.Config{
+		ListenAddress: localAddress,
+		ListenPort:    defaultPort,
+		Endpoints:     []*gen.Server{{Address: localAddress}},
+		Metrics:       &gen.Metrics{Port: "9090", Path: "/stats"},
+	}
+	_, err := balanceServer.Configure(context.Background(), slbConfig)
+	require.NoError(t, err)
+	config, err := balanceServer.Configuration(context.Background(), &emptypb.Empty{})
+	require.NoError(t, err)
+	require.True(t, proto.Equal(slbConfig.Metrics, config.Metrics))
+
+	interceptor := balanceServer.MetricsInterceptor()
+	info := &grpc.UnaryServerInfo{FullMethod: "/balance.Balance/Run"}
+	ok := func(ctx context.Context, req any) (any, error) { return &emptypb.Empty{}, nil }
+	failed := func(ctx context.Context, req any) (any, error) { return nil, ErrNotConfigured }
+	for _, handler := range []grpc.UnaryHandler{ok, ok, failed} {
+		interceptor(context.Background(), &emptypb.Empty{}, info, handler)
+	}
+	require.Equal(t, 2.0, testutil.ToFloat64(balanceServer.apiCalls.WithLabelValues("Run", codes.OK.String())))
+	require.Equal(t, 1.0, testutil.ToFloat64(balanceServer.apiCalls.WithLabelValues("Run", status.Code(ErrNotConfigured).String())))
+
+	slbConfig.Metrics.Path = "stats"
+	_, err = balanceServer.Configure(context.Background(), slbConfig)
+	require.Error(t, err)
+}
+
+func TestConfigurationShouldReturnAccessLog(t *testing.T) {
+	_, balanceServer := setupServer()
+	slbConfig := &gen.Config{
+		ListenAddress: localAddress,
+		ListenPort:    defaultPort,
+		Endpoints:     []*gen.Server{{Address: localAddress}},
+		AccessLog: &gen.AccessLog{
+			Output:          "stdout",
+			Format:          gen.AccessLogFormat_ACCESS_LOG_FORMAT_LOGFMT,
+			SampleRate:      0.5,
+			Redact:          []string{"query"},
+			RequestIdHeader: "X-Trace",
+		},
+	}
+	_, err := balanceServer.Configure(context.Background(), slbConfig)
+	require.NoError(t, err)
+	config, err := balanceServer.Configuration(context.Background(), &emptypb.Empty{})
+	require.NoError(t, err)
+	require.True(t, proto.Equal(slbConfig.AccessLog, config.AccessLog))
+
+	slbConfig.AccessLog.Redact = []string{"password"}
+	_, err = balanceServer.Configure(context.Background(), slbConfig)
+	require.Error(t, err)
+}
+
+func TestTracingInterceptorShouldTraceApiCalls(t *testing.T) {
+	_, balanceServer := setupServer()
+	spans := filepath.Join(t.TempDir(), "spans.json")
+	slbConfig := &gen.Config{
+		ListenAddress: localAddress,
+		ListenPort:    defaultPort,
+		Endpoints:     []*gen.Server{{Address: localAddress}},
+		Tracing: &gen.Tracing{
+			Exporter:    gen.TracingExporter_TRACING_EXPORTER_FILE,
+			Endpoint:    spans,
+			ServiceName: "balance-test",
+			SampleRatio: 1,
+		},
+	}
+	_, err := balanceServer.Configure(context.Background(), slbConfig)
+	require.NoError(t, err)
+	config, err := balanceServer.Configuration(context.Background(), &emptypb.Empty{})
+	require.NoError(t, err)
+	require.True(t, proto.Equal(slbConfig.Tracing, config.Tracing))
+
+	traceID := "4bf92f3577b34da6a3ce929d0e0e4736"
+	ctx := metadata.NewIncomingContext(context.Background(),
+		metadata.Pairs("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01"))
+	info := &grpc.UnaryServerInfo{FullMethod: "/balance.Balance/Configuration"}
+	_, err = balanceServer.TracingInterceptor()(ctx, &emptypb.Empty{}, info, func(ctx context.Context, req any) (any, error) {
+		return balanceServer.Configuration(ctx, req.(*emptypb.Empty))
+	})
+	require.NoError(t, err)
+	_, err = balanceServer.Stop(context.Background(), &emptypb.Empty{})
+	require.NoError(t, err)
+
+	exported, err := os.ReadFile(spans)
+	require.NoError(t, err)
+	require.Contains(t, string(exported), traceID)
+	require.Contains(t, string(exported), "balance.Balance/Configuration")
+
+	slbConfig.Tracing.Endpoint = ""
+	_, err = balanceServer.Configure(context.Background(), slbConfig)
+	require.Error(t, err)
+}
+
+// returns a PEM encoded self signed certificate for name, and its key
+func newCertificatePEM(t *testing.T, name string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}))
+}
+
+func TestConfigureShouldSetTlsWithoutReturningKeys(t *testing.T) {
+	_, balanceServer := setupServer()
+	certPEM, keyPEM := newCertificatePEM(t, "a.example")
+	slbConfig := &gen.Config{
+		ListenAddress: localAddress,
+		ListenPort:    defaultPort,
+		Endpoints:     []*gen.Server{{Address: localAddress}},
+		Tls: &gen.Tls{
+			Certificates:   []*gen.Certificate{{CertPem: certPEM, KeyPem: keyPEM}},
+			MinVersion:     "1.3",
+			ReloadInterval: durationpb.New(time.Minute),
+		},
+	}
+	_, err := balanceServer.Configure(context.Background(), slbConfig)
+	require.NoError(t, err)
+	config, err := balanceServer.Configuration(context.Background(), &emptypb.Empty{})
+	require.NoError(t, err)
+	require.Equal(t, certPEM, config.Tls.Certificates[0].CertPem)
+	require.Empty(t, config.Tls.Certificates[0].KeyPem)
+	require.Equal(t, "1.3", config.Tls.MinVersion)
+	require.Equal(t, time.Minute, config.Tls.ReloadInterval.AsDuration())
+
+	slbConfig.Tls.Certificates[0].KeyPem = "invalid"
+	_, err = balanceServer.Configure(context.Background(), slbConfig)
+	require.Error(t, err)
+}
+
+func TestConfigureShouldSetUpstreamTls(t *testing.T) {
+	_, balanceServer := setupServer()
+	httpsServer := "https://" + localAddress + ":8443"
+	slbConfig := &gen.Config{
+		ListenAddress: localAddress,
+		ListenPort:    defaultPort,
+		Endpoints: []*gen.Server{
+			{Address: localAddress},
+			{Address: httpsServer, Tls: &gen.UpstreamTls{ServerName: "backend.example", InsecureSkipVerify: true}},
+		},
+		UpstreamTls: &gen.UpstreamTls{ServerName: "default.example"},
+	}
+	_, err := balanceServer.Configure(context.Background(), slbConfig)
+	require.NoError(t, err)
+	config, err := balanceServer.Configuration(context.Background(), &emptypb.Empty{})
+	require.NoError(t, err)
+	require.Equal(t, "default.example", config.UpstreamTls.ServerName)
+	for _, server := range config.Endpoints {
+		if server.Address == httpsServer {
+			require.Equal(t, "backend.example", server.Tls.ServerName)
+			require.True(t, server.Tls.InsecureSkipVerify)
+		} else {
+			require.Nil(t, server.Tls)
+		}
+	}
+
+	added := "https://" + localAddress + ":9443"
+	_, err = balanceServer.Add(context.Background(), &gen.Server{Address: added, Tls: &gen.UpstreamTls{ServerName: "added.example"}})
+	require.NoError(t, err)
+	config, err = balanceServer.Configuration(context.Background(), &emptypb.Empty{})
+	require.NoError(t, err)
+	require.Len(t, config.Endpoints, 3)
+	require.Equal(t, "added.example", config.Endpoints[2].Tls.ServerName)
+
+	_, err = balanceServer.Add(context.Background(), &gen.Server{Address: added, Tls: &gen.UpstreamTls{CertFile: "cert.pem"}})
+	require.Error(t, err, "client certificates require a key")
+}
+
+func TestConfigureShouldComposeServerAddresses(t *testing.T) {
+	_, balanceServer := setupServer()
+	slbConfig := &gen.Config{
+		ListenAddress: localAddress,
+		ListenPort:    defaultPort,
+		Strategy:      gen.SelectorStrategy_SELECTOR_STRATEGY_RANDOM,
+		Endpoints: []*gen.Server{
+			{Address: localAddress},
+			{Host: "127.0.0.1", Port: 9000},
+			{Host: "127.0.0.1", Port: 9443, Scheme: "https", Address: "ignored"},
+			{Address: "http://127.0.0.1:9001/api"},
+		},
+	}
+	_, err := balanceServer.Configure(context.Background(), slbConfig)
+	require.NoError(t, err)
+	config, err := balanceServer.Configuration(context.Background(), &emptypb.Empty{})
+	require.NoError(t, err)
+	addresses := []string{}
+	for _, server := range config.Endpoints {
+		addresses = append(addresses, server.Address)
+	}
+	// the endpoints of the random selector are not ordered
+	require.ElementsMatch(t, []string{
+		"http://127.0.0.1:" + defaultPort,
+		"http://127.0.0.1:9000",
+		"https://127.0.0.1:9443",
+		"http://127.0.0.1:9001/api",
+	}, addresses)
+	https := config.Endpoints[slices.Index(addresses, "https://127.0.0.1:9443")]
+	require.Equal(t, "127.0.0.1", https.Host)
+	require.Equal(t, uint32(9443), https.Port)
+	require.Equal(t, "https", https.Scheme)
+
+	_, err = balanceServer.Remove(context.Background(), &gen.Server{Host: "127.0.0.1", Port: 9000})
+	require.NoError(t, err)
+	config, err = balanceServer.Configuration(context.Background(), &emptypb.Empty{})
+	require.NoError(t, err)
+	require.Len(t, config.Endpoints, 3)
+
+	slbConfig.Endpoints = append(slbConfig.Endpoints, &gen.Server{Host: "127.0.0.1", Port: 70000})
+	_, err = balanceServer.Configure(context.Background(), slbConfig)
+	require.ErrorContains(t, err, "127.0.0.1:70000")
+}
+
+func TestConfigureShouldDiscoverServers(t *testing.T) {
+	_, balanceServer := setupServer()
+	slbConfig := &gen.Config{
+		ListenAddress: localAddress,
+		ListenPort:    defaultPort,
+		Strategy:      gen.SelectorStrategy_SELECTOR_STRATEGY_RANDOM,
+		Discovery: &gen.Discovery{Dns: &gen.DnsDiscovery{
+			Name:            "localhost",
+			RecordType:      gen.DnsRecordType_DNS_RECORD_TYPE_A,
+			Port:            9000,
+			RefreshInterval: durationpb.New(time.Minute),
+		}},
+	}
+	_, err := balanceServer.Configure(context.Background(), slbConfig)
+	require.NoError(t, err)
+	config, err := balanceServer.Configuration(context.Background(), &emptypb.Empty{})
+	require.NoError(t, err)
+	require.Equal(t, "localhost", config.Discovery.Dns.Name)
+	require.Equal(t, uint32(9000), config.Discovery.Dns.Port)
+	require.Equal(t, gen.DnsRecordType_DNS_RECORD_TYPE_A, config.Discovery.Dns.RecordType)
+	require.Equal(t, time.Minute, config.Discovery.Dns.RefreshInterval.AsDuration())
+	addresses := []string{}
+	for _, server := range config.Endpoints {
+		addresses = append(addresses, server.Address)
+	}
+	require.Contains(t, addresses, "http://127.0.0.1:9000")
+
+	slbConfig.Discovery.Dns.Scheme = "ftp"
+	_, err = balanceServer.Configure(context.Background(), slbConfig)
+	require.Error(t, err)
+}
+
+func TestConfigurationShouldReturnFileDiscovery(t *testing.T) {
+	_, balanceServer := setupServer()
+	path := filepath.Join(t.TempDir(), "endpoints.yaml")
+	require.NoError(t, os.WriteFile(path, []byte("endpoints:\n  - address: 127.0.0.1:9000\n    metadata:\n      zone: a\n"), 0o600))
+	slbConfig := &gen.Config{
+		ListenAddress: localAddress,
+		ListenPort:    defaultPort,
+		Pools: []*gen.Pool{{
+			Name:      "api",
+			Strategy:  gen.SelectorStrategy_SELECTOR_STRATEGY_RANDOM,
+			Endpoints: []*gen.Server{{Address: localAddress}},
+			Discovery: &gen.Discovery{File: &gen.FileDiscovery{Path: path, ReloadInterval: durationpb.New(time.Second)}},
+		}},
+		Endpoints: []*gen.Server{{Address: localAddress}},
+	}
+	_, err := balanceServer.Configure(context.Background(), slbConfig)
+	require.NoError(t, err)
+	config, err := balanceServer.Configuration(context.Background(), &emptypb.Empty{})
+	require.NoError(t, err)
+	require.Nil(t, config.Discovery)
+	discovery := config.Pools[0].Discovery
+	require.Equal(t, path, discovery.File.Path)
+	require.Equal(t, time.Second, discovery.File.ReloadInterval.AsDuration())
+	require.Empty(t, discovery.LastError)
+	require.NotNil(t, discovery.LastRefresh)
+	require.Len(t, config.Pools[0].Endpoints, 2)
+	// the endpoints of the random selector are not ordered
+	i := slices.IndexFunc(config.Pools[0].Endpoints, func(server *gen.Server) bool { return server.Address == "http://127.0.0.1:9000" })
+	require.GreaterOrEqual(t, i, 0)
+	require.Equal(t, map[string]string{"zone": "a"}, config.Pools[0].Endpoints[i].Labels)
+
+	require.NoError(t, os.WriteFile(path, []byte("endpoints: ["), 0o600))
+	_, err = balanceServer.Configure(context.Background(), slbConfig)
+	require.ErrorContains(t, err, "invalid discovery file")
+}
+
+func TestConfigureShouldDiscoverDockerContainers(t *testing.T) {
+	_, balanceServer := setupServer()
+	docker := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/_ping":
+			rw.Header().Set("Api-Version", "1.43")
+		case "/v1.43/containers/json":
+			rw.Write([]byte(`[{"Id":"web-1","Names":["/web-1"],"Image":"backend","State":"running",` +
+				`"Labels":{"balance.pool":"default","balance.port":"9000"},` +
+				`"NetworkSettings":{"Networks":{"bridge":{"IPAddress":"127.0.0.1"}}}}]`))
+		default:
+			http.NotFound(rw, r)
+		}
+	}))
+	t.Cleanup(docker.Close)
+	slbConfig := &gen.Config{
+		ListenAddress: localAddress,
+		ListenPort:    defaultPort,
+		Strategy:      gen.SelectorStrategy_SELECTOR_STRATEGY_RANDOM,
+		Discovery: &gen.Discovery{Docker: &gen.DockerDiscovery{
+			Host:            "tcp://" + docker.Listener.Addr().String(),
+			Network:         "bridge",
+			RefreshInterval: durationpb.New(time.Minute),
+		}},
+	}
+	_, err := balanceServer.Configure(context.Background(), slbConfig)
+	require.NoError(t, err)
+	config, err := balanceServer.Configuration(context.Background(), &emptypb.Empty{})
+	require.NoError(t, err)
+	require.Equal(t, slbConfig.Discovery.Docker.Host, config.Discovery.Docker.Host)
+	require.Equal(t, "bridge", config.Discovery.Docker.Network)
+	require.Equal(t, time.Minute, config.Discovery.Docker.RefreshInterval.AsDuration())
+	require.Len(t, config.Endpoints, 1)
+	require.Equal(t, "http://127.0.0.1:9000", config.Endpoints[0].Address)
+	require.Equal(t, "web-1", config.Endpoints[0].Labels["container"])
+
+	slbConfig.Discovery.Docker.Host = "docker.sock"
+	_, err = balanceServer.Configure(context.Background(), slbConfig)
+	require.Error(t, err)
+}
@@ -0,0 +1,16 @@
+package mockEndpoints
+
+import (
+	"balance/internal/mock"
+	"balance/slb"
+	"net/http"
+)
+
+// Returns n endpoints with random addresses
+func Generate(n int) []*slb.Endpoint {
+	endpoints := []*slb.Endpoint{}
+	for curr := 0; curr < n; curr++ {
+		endpoints = append(endpoints, &slb.Endpoint{Addr: mock.RandomAddress(), Handler: http.NewServeMux()})
+	}
+	return endpoints
+}
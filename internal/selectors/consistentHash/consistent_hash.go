@@ -0,0 +1,143 @@
+package consistentHash
+
+import (
+	"balance/internal/selectors/snapshot"
+	"balance/slb"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync/atomic"
+)
+
+// DefaultReplicas is the number of points of every endpoint on the ring
+const DefaultReplicas = 160
+
+type ringPoint struct {
+	hash   uint64
+	server *slb.Endpoint
+}
+
+// ConsistentHash selects targets by the hash key of the request on a hash ring (ketama style):
+// requests with the same key are sent to the same target, and adding or removing a target
+// only moves the keys of ~1/N of the ring.
+// Requests without a key are selected round robin.
+type ConsistentHash struct {
+	replicas  int
+	endpoints snapshot.List[*slb.Endpoint]
+	// ring of the current endpoints, it is replaced with them
+	ring atomic.Pointer[[]ringPoint]
+	// number of selections without a key
+	next atomic.Uint64
+}
+
+func New() *ConsistentHash {
+	return NewWithReplicas(DefaultReplicas)
+}
+
+// Returns a ConsistentHash that places replicas points of every endpoint on the ring.
+// More replicas spread the keys more evenly, at the cost of memory and Add / Remove time.
+func NewWithReplicas(replicas int) *ConsistentHash {
+	if replicas <= 0 {
+		replicas = DefaultReplicas
+	}
+	return &ConsistentHash{replicas: replicas}
+}
+
+// Selects the target owning key on the ring
+func (c *ConsistentHash) SelectRequest(_ *http.Request, key string) (*slb.Endpoint, error) {
+	var ring []ringPoint
+	if current := c.ring.Load(); current != nil {
+		ring = *current
+	}
+	if len(ring) <= 0 {
+		return nil, fmt.Errorf("selector has no endpoints to select")
+	}
+	h := hash(key)
+	idx := sort.Search(len(ring), func(i int) bool { return ring[i].hash >= h })
+	if idx == len(ring) {
+		idx = 0
+	}
+	return ring[idx].server, nil
+}
+
+// Selects the targets round robin, since there is no key to hash
+func (c *ConsistentHash) Select() (*slb.Endpoint, error) {
+	endpoints := c.endpoints.Load()
+	if len(endpoints) <= 0 {
+		return nil, fmt.Errorf("selector has no endpoints to select")
+	}
+	return endpoints[c.next.Add(1)%uint64(len(endpoints))], nil
+}
+
+func (c *ConsistentHash) EndPoints() ([]*slb.Endpoint, error) {
+	return append([]*slb.Endpoint{}, c.endpoints.Load()...), nil
+}
+
+func (c *ConsistentHash) Add(server *slb.Endpoint) error {
+	return c.endpoints.Update(func(endpoints []*slb.Endpoint) ([]*slb.Endpoint, error) {
+		if _, found := find(endpoints, server); found {
+			return nil, fmt.Errorf("server already exists %+v", server.Addr)
+		}
+		endpoints = append(endpoints, server)
+		c.buildRing(endpoints)
+		return endpoints, nil
+	})
+}
+
+func (c *ConsistentHash) Remove(server *slb.Endpoint) error {
+	return c.endpoints.Update(func(endpoints []*slb.Endpoint) ([]*slb.Endpoint, error) {
+		if idx, found := find(endpoints, server); found {
+			endpoints = append(endpoints[:idx], endpoints[idx+1:]...)
+			c.buildRing(endpoints)
+			return endpoints, nil
+		}
+		return nil, fmt.Errorf("could not find server to delete %+v", server)
+	})
+}
+
+// replaces the ring by the ring of endpoints, must be called while the endpoints are updated.
+// The points of an endpoint only depend on its identity, so they are stable across rebuilds.
+func (c *ConsistentHash) buildRing(endpoints []*slb.Endpoint) {
+	ring := make([]ringPoint, 0, len(endpoints)*c.replicas)
+	for _, server := range endpoints {
+		for replica := 0; replica < c.replicas; replica++ {
+			ring = append(ring, ringPoint{hash: hash(server.Identity() + "-" + strconv.Itoa(replica)), server: server})
+		}
+	}
+	sort.Slice(ring, func(i, j int) bool {
+		if ring[i].hash == ring[j].hash {
+			return ring[i].server.Identity() < ring[j].server.Identity()
+		}
+		return ring[i].hash < ring[j].hash
+	})
+	c.ring.Store(&ring)
+}
+
+// finds server by its identity
+func find(endpoints []*slb.Endpoint, server *slb.Endpoint) (int, bool) {
+	for i, s := range endpoints {
+		if s.Is(server) {
+			return i, true
+		}
+	}
+	return -1, false
+}
+
+func hash(key string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	// fnv alone does not spread similar keys (e.g addresses) well over the ring
+	return mix(h.Sum64())
+}
+
+// finalizer of splitmix64
+func mix(h uint64) uint64 {
+	h ^= h >> 30
+	h *= 0xbf58476d1ce4e5b9
+	h ^= h >> 27
+	h *= 0x94d049bb133111eb
+	h ^= h >> 31
+	return h
+}
@@ -0,0 +1,132 @@
+package consistentHash
+
+import (
+	"balance/internal/mock/mockEndpoints"
+	"balance/slb"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const nKeys = 10000
+
+func keys() []string {
+	keys := make([]string, 0, nKeys)
+	for i := 0; i < nKeys; i++ {
+		keys = append(keys, fmt.Sprintf("user-%d", i))
+	}
+	return keys
+}
+
+// returns the selected server of every key
+func selectKeys(t *testing.T, selector *ConsistentHash, keys []string) map[string]*slb.Endpoint {
+	selected := make(map[string]*slb.Endpoint, len(keys))
+	for _, key := range keys {
+		server, err := selector.SelectRequest(nil, key)
+		require.NoError(t, err)
+		selected[key] = server
+	}
+	return selected
+}
+
+// returns the fraction of keys that are selected differently
+func moved(before map[string]*slb.Endpoint, after map[string]*slb.Endpoint) float64 {
+	n := 0
+	for key, server := range before {
+		if after[key] != server {
+			n++
+		}
+	}
+	return float64(n) / float64(len(before))
+}
+
+func TestConsistentHashSameKeySameServer(t *testing.T) {
+	selector := New()
+	_, err := selector.SelectRequest(nil, "key")
+	require.Error(t, err, "expected no endpoints to select")
+	_, err = selector.Select()
+	require.Error(t, err, "expected no endpoints to select")
+
+	for _, server := range mockEndpoints.Generate(5) {
+		require.NoError(t, selector.Add(server))
+	}
+	first := selectKeys(t, selector, keys())
+	second := selectKeys(t, selector, keys())
+	require.Equal(t, first, second)
+}
+
+func TestConsistentHashDistribution(t *testing.T) {
+	selector := New()
+	servers := mockEndpoints.Generate(5)
+	for _, server := range servers {
+		require.NoError(t, selector.Add(server))
+	}
+	counts := map[*slb.Endpoint]int{}
+	for _, server := range selectKeys(t, selector, keys()) {
+		counts[server]++
+	}
+	require.Len(t, counts, len(servers))
+	for _, count := range counts {
+		// every server owns roughly 1/5 of the keys
+		require.InDelta(t, nKeys/len(servers), count, float64(nKeys/len(servers))*0.3)
+	}
+}
+
+func TestConsistentHashMinimalMovement(t *testing.T) {
+	selector := New()
+	servers := mockEndpoints.Generate(10)
+	for _, server := range servers[:9] {
+		require.NoError(t, selector.Add(server))
+	}
+	before := selectKeys(t, selector, keys())
+
+	// adding a server only moves keys to the new server
+	require.NoError(t, selector.Add(servers[9]))
+	afterAdd := selectKeys(t, selector, keys())
+	require.InDelta(t, 0.1, moved(before, afterAdd), 0.05)
+	for key, server := range afterAdd {
+		if before[key] != server {
+			require.Same(t, servers[9], server)
+		}
+	}
+
+	// removing it moves its keys back to where they were
+	require.NoError(t, selector.Remove(servers[9]))
+	require.Equal(t, before, selectKeys(t, selector, keys()))
+
+	// removing another server only moves its keys
+	require.NoError(t, selector.Remove(&slb.Endpoint{Addr: servers[0].Addr}))
+	afterRemove := selectKeys(t, selector, keys())
+	for key, server := range before {
+		if server != servers[0] {
+			require.Same(t, server, afterRemove[key])
+		}
+	}
+	require.Error(t, selector.Remove(servers[0]), "server was removed")
+}
+
+func TestConsistentHashSelectWithoutKey(t *testing.T) {
+	selector := NewWithReplicas(10)
+	servers := mockEndpoints.Generate(3)
+	for _, server := range servers {
+		require.NoError(t, selector.Add(server))
+	}
+	require.Error(t, selector.Add(servers[0]), "server already exists")
+	selected := map[*slb.Endpoint]bool{}
+	for i := 0; i < len(servers); i++ {
+		server, err := selector.Select()
+		require.NoError(t, err)
+		selected[server] = true
+	}
+	require.Len(t, selected, len(servers))
+
+	endpoints, err := selector.EndPoints()
+	require.NoError(t, err)
+	require.ElementsMatch(t, servers, endpoints)
+}
+
+func TestConsistentHashImplementsRequestSelector(t *testing.T) {
+	var _ slb.Selector = New()
+	var _ slb.RequestSelector = New()
+}
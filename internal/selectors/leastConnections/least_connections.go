@@ -0,0 +1,159 @@
+package leastConnections
+
+import (
+	"balance/internal/selectors/snapshot"
+	"balance/slb"
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+const DefaultWeight = 1
+
+type trackedEndpoint struct {
+	server   *slb.Endpoint
+	weight   atomic.Int64
+	inFlight atomic.Int64
+}
+
+// LeastConnections selects the target with the fewest requests in flight.
+// Targets with the same number of requests in flight are selected in turns.
+// The requests in flight are counted atomically, so that Select and Acquire are lock-free.
+type LeastConnections struct {
+	endpoints snapshot.List[*trackedEndpoint]
+	// rotates the start of the search, so that ties are broken round robin
+	next atomic.Uint64
+}
+
+func New() *LeastConnections {
+	return &LeastConnections{}
+}
+
+func (l *LeastConnections) Select() (*slb.Endpoint, error) {
+	endpoints := l.endpoints.Load()
+	if len(endpoints) <= 0 {
+		return nil, fmt.Errorf("selector has no endpoints to select")
+	}
+
+	next := int((l.next.Add(1) - 1) % uint64(len(endpoints)))
+	var selected *trackedEndpoint
+	var selectedInFlight, selectedWeight int64
+	for i := range endpoints {
+		e := endpoints[(next+i)%len(endpoints)]
+		inFlight, weight := e.inFlight.Load(), e.weight.Load()
+		// compares inFlight/weight without dividing
+		if selected == nil || inFlight*selectedWeight < selectedInFlight*weight {
+			selected, selectedInFlight, selectedWeight = e, inFlight, weight
+		}
+	}
+	return selected.server, nil
+}
+
+// Acquire counts a request in flight to server until the returned Done is called
+func (l *LeastConnections) Acquire(server *slb.Endpoint) slb.Done {
+	e, found := find(l.endpoints.Load(), server)
+	if !found {
+		return func(slb.Outcome) {}
+	}
+	e.inFlight.Add(1)
+	once := sync.Once{}
+	return func(slb.Outcome) {
+		once.Do(func() { e.inFlight.Add(-1) })
+	}
+}
+
+// Returns the number of requests in flight to server
+func (l *LeastConnections) InFlight(server *slb.Endpoint) (int, error) {
+	e, found := find(l.endpoints.Load(), server)
+	if !found {
+		return 0, fmt.Errorf("could not find server %+v", server)
+	}
+	return int(e.inFlight.Load()), nil
+}
+
+func (l *LeastConnections) EndPoints() ([]*slb.Endpoint, error) {
+	endpoints := l.endpoints.Load()
+	servers := make([]*slb.Endpoint, 0, len(endpoints))
+	for _, e := range endpoints {
+		servers = append(servers, e.server)
+	}
+	return servers, nil
+}
+
+func (l *LeastConnections) Add(server *slb.Endpoint) error {
+	return l.add(server, DefaultWeight)
+}
+
+// adds server with weight
+func (l *LeastConnections) add(server *slb.Endpoint, weight int) error {
+	return l.endpoints.Update(func(endpoints []*trackedEndpoint) ([]*trackedEndpoint, error) {
+		if _, found := find(endpoints, server); found {
+			return nil, fmt.Errorf("server already exists %+v", server.Addr)
+		}
+		e := &trackedEndpoint{server: server}
+		e.weight.Store(int64(weight))
+		return append(endpoints, e), nil
+	})
+}
+
+func (l *LeastConnections) Remove(server *slb.Endpoint) error {
+	return l.endpoints.Update(func(endpoints []*trackedEndpoint) ([]*trackedEndpoint, error) {
+		for i, e := range endpoints {
+			if e.server.Is(server) {
+				return append(endpoints[:i], endpoints[i+1:]...), nil
+			}
+		}
+		return nil, fmt.Errorf("could not find server to delete %+v", server)
+	})
+}
+
+// finds server by its identity
+func find(endpoints []*trackedEndpoint, server *slb.Endpoint) (*trackedEndpoint, bool) {
+	for _, e := range endpoints {
+		if e.server.Is(server) {
+			return e, true
+		}
+	}
+	return nil, false
+}
+
+// WeightedLeastConnections selects the target with the fewest requests in flight relative to its weight:
+// a target with weight 2 is selected until it has twice as many requests in flight as a target with weight 1.
+type WeightedLeastConnections struct {
+	*LeastConnections
+}
+
+func NewWeighted() *WeightedLeastConnections {
+	return &WeightedLeastConnections{LeastConnections: New()}
+}
+
+// Adds server with its weight (default 1)
+func (w *WeightedLeastConnections) Add(server *slb.Endpoint) error {
+	weight := DefaultWeight
+	if server.Weight > 0 {
+		weight = server.Weight
+	}
+	return w.add(server, weight)
+}
+
+// Sets the weight of server
+func (w *WeightedLeastConnections) SetWeight(server *slb.Endpoint, weight int) error {
+	if weight <= 0 {
+		return fmt.Errorf("weight must be positive, got %d", weight)
+	}
+	e, found := find(w.endpoints.Load(), server)
+	if !found {
+		return fmt.Errorf("could not find server to set weight %+v", server)
+	}
+	e.weight.Store(int64(weight))
+	return nil
+}
+
+// Returns the weight of server
+func (w *WeightedLeastConnections) Weight(server *slb.Endpoint) (int, error) {
+	e, found := find(w.endpoints.Load(), server)
+	if !found {
+		return 0, fmt.Errorf("could not find server %+v", server)
+	}
+	return int(e.weight.Load()), nil
+}
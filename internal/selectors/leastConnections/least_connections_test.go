@@ -0,0 +1,95 @@
+package leastConnections
+
+import (
+	"balance/internal/mock/mockEndpoints"
+	"balance/slb"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLeastConnections(t *testing.T) {
+	selector := New()
+	_, err := selector.Select()
+	require.Error(t, err, "expected no endpoints to select")
+
+	servers := mockEndpoints.Generate(3)
+	for _, server := range servers {
+		require.NoError(t, selector.Add(server))
+	}
+	require.Error(t, selector.Add(servers[0]), "server already exists")
+
+	// without requests in flight the servers are selected in turns
+	for i := 0; i < 6; i++ {
+		selected, err := selector.Select()
+		require.NoError(t, err)
+		require.Same(t, servers[i%3], selected)
+	}
+
+	doneFirst := selector.Acquire(servers[0])
+	doneSecond := selector.Acquire(servers[1])
+	selector.Acquire(servers[1])
+	for i := 0; i < 3; i++ {
+		selected, err := selector.Select()
+		require.NoError(t, err)
+		require.Same(t, servers[2], selected)
+	}
+
+	selector.Acquire(servers[2])
+	selector.Acquire(servers[2])
+	doneFirst(slb.Outcome{StatusCode: http.StatusOK})
+	doneFirst(slb.Outcome{StatusCode: http.StatusOK})
+	inFlight, err := selector.InFlight(servers[0])
+	require.NoError(t, err)
+	require.Zero(t, inFlight, "done should only be counted once")
+	selected, err := selector.Select()
+	require.NoError(t, err)
+	require.Same(t, servers[0], selected)
+
+	doneSecond(slb.Outcome{})
+	inFlight, err = selector.InFlight(servers[1])
+	require.NoError(t, err)
+	require.Equal(t, 1, inFlight)
+
+	for _, server := range servers {
+		require.NoError(t, selector.Remove(server))
+	}
+	require.Error(t, selector.Remove(servers[0]), "all endoints removed, expected error")
+	_, err = selector.InFlight(servers[0])
+	require.Error(t, err)
+	// acquiring a removed server is a no op
+	selector.Acquire(servers[0])(slb.Outcome{})
+}
+
+func TestWeightedLeastConnections(t *testing.T) {
+	selector := NewWeighted()
+	servers := mockEndpoints.Generate(2)
+	for _, server := range servers {
+		require.NoError(t, selector.Add(server))
+	}
+	require.NoError(t, selector.SetWeight(servers[0], 3))
+	require.Error(t, selector.SetWeight(servers[0], 0))
+	weight, err := selector.Weight(servers[0])
+	require.NoError(t, err)
+	require.Equal(t, 3, weight)
+
+	// requests are never finished, so they are spread by the weights
+	selections := map[*slb.Endpoint]int{}
+	for i := 0; i < 8; i++ {
+		selected, err := selector.Select()
+		require.NoError(t, err)
+		selector.Acquire(selected)
+		selections[selected]++
+	}
+	require.Equal(t, 6, selections[servers[0]])
+	require.Equal(t, 2, selections[servers[1]])
+}
+
+func TestLeastConnectionsImplementsTracker(t *testing.T) {
+	var _ slb.Tracker = New()
+	var _ slb.Selector = New()
+	var _ slb.Weighter = NewWeighted()
+	_, isWeighter := interface{}(New()).(slb.Weighter)
+	require.False(t, isWeighter)
+}
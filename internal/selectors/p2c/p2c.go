@@ -0,0 +1,185 @@
+package p2c
+
+import (
+	"balance/internal/selectors/snapshot"
+	"balance/slb"
+	"fmt"
+	"math"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// Time after which the weight of a latency sample decayed to 1/e
+	DefaultDecay = time.Second * 10
+	// Cost of an endpoint that has requests in flight, but no measured latency yet.
+	// Also used as latency sample of requests that failed (5xx) or could not reach the endpoint.
+	DefaultPenalty = time.Second
+)
+
+type scoredEndpoint struct {
+	server   *slb.Endpoint
+	inFlight atomic.Int64
+	// peak exponentially weighted moving average of the latency in nanoseconds (float64 bits)
+	ewma atomic.Uint64
+	// serializes the latency samples
+	mu         sync.Mutex
+	lastSample time.Time
+}
+
+// returns the cost of selecting the endpoint: its latency times the requests in flight
+func (e *scoredEndpoint) cost() float64 {
+	ewma, inFlight := math.Float64frombits(e.ewma.Load()), e.inFlight.Load()
+	if ewma == 0 && inFlight > 0 {
+		ewma = float64(DefaultPenalty)
+	}
+	return ewma * float64(inFlight+1)
+}
+
+// P2C (power of two choices) selects two random targets,
+// and chooses the one with the lower cost, which is its peak EWMA latency times its requests in flight.
+// Peak EWMA reacts to latency spikes immediately, and recovers gradually.
+// Select is lock-free, the latencies are sampled per endpoint.
+type P2C struct {
+	// the random choices are derived from the seed and the number of draws
+	seed      uint64
+	draws     atomic.Uint64
+	decay     time.Duration
+	now       func() time.Time
+	endpoints snapshot.List[*scoredEndpoint]
+}
+
+func New() *P2C {
+	return NewWithSeed(time.Now().UnixNano())
+}
+
+// Returns a P2C selector with a deterministic choice of targets
+func NewWithSeed(seed int64) *P2C {
+	return &P2C{
+		seed:  uint64(seed),
+		decay: DefaultDecay,
+		now:   time.Now,
+	}
+}
+
+func (p *P2C) Select() (*slb.Endpoint, error) {
+	endpoints := p.endpoints.Load()
+	switch len(endpoints) {
+	case 0:
+		return nil, fmt.Errorf("selector has no endpoints to select")
+	case 1:
+		return endpoints[0].server, nil
+	}
+	first := p.intn(len(endpoints))
+	// choose a second target that differs from the first
+	second := p.intn(len(endpoints) - 1)
+	if second >= first {
+		second++
+	}
+	a, b := endpoints[first], endpoints[second]
+	if b.cost() < a.cost() {
+		return b.server, nil
+	}
+	return a.server, nil
+}
+
+// returns a random number in [0, n), it is safe for concurrent use (splitmix64 of the draws)
+func (p *P2C) intn(n int) int {
+	h := p.seed + p.draws.Add(1)*0x9e3779b97f4a7c15
+	h = (h ^ (h >> 30)) * 0xbf58476d1ce4e5b9
+	h = (h ^ (h >> 27)) * 0x94d049bb133111eb
+	h ^= h >> 31
+	return int(h % uint64(n))
+}
+
+// Acquire counts a request in flight to server until the returned Done is called,
+// which records the latency of the request.
+func (p *P2C) Acquire(server *slb.Endpoint) slb.Done {
+	e, found := find(p.endpoints.Load(), server)
+	if !found {
+		return func(slb.Outcome) {}
+	}
+	e.inFlight.Add(1)
+	once := sync.Once{}
+	return func(outcome slb.Outcome) {
+		once.Do(func() {
+			e.inFlight.Add(-1)
+			p.observe(e, outcome)
+		})
+	}
+}
+
+// records the latency of outcome in the peak EWMA of e, outcomes of requests the client aborted are not recorded
+func (p *P2C) observe(e *scoredEndpoint, outcome slb.Outcome) {
+	if outcome.Aborted {
+		return
+	}
+	sample := float64(outcome.Duration)
+	// a failing endpoint must not look fast, e.g by responding 503 immediately
+	if outcome.StatusCode >= http.StatusInternalServerError {
+		sample = math.Max(sample, float64(DefaultPenalty))
+	}
+	defer e.mu.Unlock()
+	e.mu.Lock()
+	now := p.now()
+	ewma := math.Float64frombits(e.ewma.Load())
+	if sample > ewma {
+		ewma = sample
+	} else {
+		elapsed := now.Sub(e.lastSample)
+		weight := math.Exp(-float64(elapsed) / float64(p.decay))
+		ewma = ewma*weight + sample*(1-weight)
+	}
+	e.ewma.Store(math.Float64bits(ewma))
+	e.lastSample = now
+}
+
+// Returns the current cost of server
+func (p *P2C) Cost(server *slb.Endpoint) (float64, error) {
+	e, found := find(p.endpoints.Load(), server)
+	if !found {
+		return 0, fmt.Errorf("could not find server %+v", server)
+	}
+	return e.cost(), nil
+}
+
+func (p *P2C) EndPoints() ([]*slb.Endpoint, error) {
+	endpoints := p.endpoints.Load()
+	servers := make([]*slb.Endpoint, 0, len(endpoints))
+	for _, e := range endpoints {
+		servers = append(servers, e.server)
+	}
+	return servers, nil
+}
+
+func (p *P2C) Add(server *slb.Endpoint) error {
+	return p.endpoints.Update(func(endpoints []*scoredEndpoint) ([]*scoredEndpoint, error) {
+		if _, found := find(endpoints, server); found {
+			return nil, fmt.Errorf("server already exists %+v", server.Addr)
+		}
+		return append(endpoints, &scoredEndpoint{server: server, lastSample: p.now()}), nil
+	})
+}
+
+func (p *P2C) Remove(server *slb.Endpoint) error {
+	return p.endpoints.Update(func(endpoints []*scoredEndpoint) ([]*scoredEndpoint, error) {
+		for i, e := range endpoints {
+			if e.server.Is(server) {
+				return append(endpoints[:i], endpoints[i+1:]...), nil
+			}
+		}
+		return nil, fmt.Errorf("could not find server to delete %+v", server)
+	})
+}
+
+// finds server by its identity
+func find(endpoints []*scoredEndpoint, server *slb.Endpoint) (*scoredEndpoint, bool) {
+	for _, e := range endpoints {
+		if e.server.Is(server) {
+			return e, true
+		}
+	}
+	return nil, false
+}
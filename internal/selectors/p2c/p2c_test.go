@@ -0,0 +1,182 @@
+package p2c
+
+import (
+	"balance/internal/mock/mockEndpoints"
+	randomSelector "balance/internal/selectors/random"
+	"balance/internal/selectors/roundRobin"
+	"balance/slb"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestP2CSelect(t *testing.T) {
+	selector := NewWithSeed(1)
+	_, err := selector.Select()
+	require.Error(t, err, "expected no endpoints to select")
+
+	servers := mockEndpoints.Generate(3)
+	require.NoError(t, selector.Add(servers[0]))
+	require.Error(t, selector.Add(servers[0]), "server already exists")
+	selected, err := selector.Select()
+	require.NoError(t, err)
+	require.Same(t, servers[0], selected)
+
+	for _, server := range servers[1:] {
+		require.NoError(t, selector.Add(server))
+	}
+	// without measured latencies every server is selected
+	selections := map[*slb.Endpoint]int{}
+	for i := 0; i < 300; i++ {
+		selected, err := selector.Select()
+		require.NoError(t, err)
+		selections[selected]++
+	}
+	require.Len(t, selections, len(servers))
+
+	for _, server := range servers {
+		require.NoError(t, selector.Remove(server))
+	}
+	require.Error(t, selector.Remove(servers[0]), "all endoints removed, expected error")
+}
+
+func TestP2CAvoidsSlowServer(t *testing.T) {
+	selector := NewWithSeed(1)
+	servers := mockEndpoints.Generate(2)
+	for _, server := range servers {
+		require.NoError(t, selector.Add(server))
+	}
+	selector.Acquire(servers[0])(slb.Outcome{Duration: time.Second, StatusCode: http.StatusOK})
+	selector.Acquire(servers[1])(slb.Outcome{Duration: time.Millisecond, StatusCode: http.StatusOK})
+
+	// with two servers, both are always compared
+	for i := 0; i < 10; i++ {
+		selected, err := selector.Select()
+		require.NoError(t, err)
+		require.Same(t, servers[1], selected)
+	}
+}
+
+func TestP2CCost(t *testing.T) {
+	selector := NewWithSeed(1)
+	now := time.Now()
+	selector.now = func() time.Time { return now }
+	server := mockEndpoints.Generate(1)[0]
+	require.NoError(t, selector.Add(server))
+
+	cost, err := selector.Cost(server)
+	require.NoError(t, err)
+	require.Zero(t, cost)
+
+	// unmeasured servers with requests in flight are penalized
+	done := selector.Acquire(server)
+	cost, err = selector.Cost(server)
+	require.NoError(t, err)
+	require.Equal(t, float64(DefaultPenalty)*2, cost)
+
+	// the peak is taken immediately
+	done(slb.Outcome{Duration: time.Millisecond * 100, StatusCode: http.StatusOK})
+	done(slb.Outcome{Duration: time.Hour, StatusCode: http.StatusOK})
+	cost, err = selector.Cost(server)
+	require.NoError(t, err)
+	require.Equal(t, float64(time.Millisecond*100), cost, "done should only be counted once")
+
+	// lower latencies decay the average over time
+	now = now.Add(DefaultDecay)
+	selector.Acquire(server)(slb.Outcome{Duration: 0, StatusCode: http.StatusOK})
+	cost, err = selector.Cost(server)
+	require.NoError(t, err)
+	require.InDelta(t, float64(time.Millisecond*100)/2.718, cost, float64(time.Millisecond))
+
+	// requests the client aborted do not reflect the server
+	selector.Acquire(server)(slb.Outcome{Duration: time.Millisecond, StatusCode: http.StatusBadGateway, Aborted: true})
+	cost, err = selector.Cost(server)
+	require.NoError(t, err)
+	require.InDelta(t, float64(time.Millisecond*100)/2.718, cost, float64(time.Millisecond))
+
+	// unreachable servers are penalized
+	selector.Acquire(server)(slb.Outcome{Duration: time.Millisecond, StatusCode: http.StatusBadGateway})
+	cost, err = selector.Cost(server)
+	require.NoError(t, err)
+	require.Equal(t, float64(DefaultPenalty), cost)
+
+	// failed requests are penalized, however fast they failed
+	for _, status := range []int{http.StatusInternalServerError, http.StatusServiceUnavailable, http.StatusGatewayTimeout} {
+		failing := &slb.Endpoint{Addr: strconv.Itoa(status)}
+		require.NoError(t, selector.Add(failing))
+		selector.Acquire(failing)(slb.Outcome{Duration: time.Millisecond, StatusCode: status})
+		cost, err = selector.Cost(failing)
+		require.NoError(t, err)
+		require.Equal(t, float64(DefaultPenalty), cost, status)
+	}
+
+	_, err = selector.Cost(&slb.Endpoint{Addr: "unknown"})
+	require.Error(t, err)
+}
+
+// runs b.N requests against backends of which one is 10 times slower than the others,
+// and reports the mean and 99th percentile latency of the requests
+func benchmarkSkewedLatency(b *testing.B, selector slb.Selector) {
+	servers := mockEndpoints.Generate(5)
+	latencies := map[*slb.Endpoint]time.Duration{}
+	for i, server := range servers {
+		latencies[server] = time.Millisecond / 2
+		if i == 0 {
+			latencies[server] = time.Millisecond * 5
+		}
+		require.NoError(b, selector.Add(server))
+	}
+	tracker, tracked := selector.(slb.Tracker)
+
+	mu := sync.Mutex{}
+	measured := make([]time.Duration, 0, b.N)
+	b.SetParallelism(4)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		local := []time.Duration{}
+		for pb.Next() {
+			server, err := selector.Select()
+			if err != nil {
+				b.Error(err)
+				return
+			}
+			done := func(slb.Outcome) {}
+			if tracked {
+				done = tracker.Acquire(server)
+			}
+			start := time.Now()
+			time.Sleep(latencies[server])
+			duration := time.Since(start)
+			done(slb.Outcome{Duration: duration, StatusCode: http.StatusOK})
+			local = append(local, duration)
+		}
+		mu.Lock()
+		measured = append(measured, local...)
+		mu.Unlock()
+	})
+	b.StopTimer()
+
+	sort.Slice(measured, func(i, j int) bool { return measured[i] < measured[j] })
+	var total time.Duration
+	for _, d := range measured {
+		total += d
+	}
+	b.ReportMetric(float64(total.Microseconds())/float64(len(measured)), "mean-µs")
+	b.ReportMetric(float64(measured[len(measured)*99/100].Microseconds()), "p99-µs")
+}
+
+func BenchmarkSkewedLatency(b *testing.B) {
+	b.Run("p2c", func(b *testing.B) { benchmarkSkewedLatency(b, New()) })
+	b.Run("roundRobin", func(b *testing.B) { benchmarkSkewedLatency(b, roundRobin.New()) })
+	b.Run("random", func(b *testing.B) { benchmarkSkewedLatency(b, randomSelector.New()) })
+}
+
+func TestP2CImplementsTracker(t *testing.T) {
+	var _ slb.Selector = New()
+	var _ slb.Tracker = New()
+}
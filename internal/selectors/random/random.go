@@ -1,51 +1,168 @@
 package randomSelector
 
 import (
+	"balance/internal/selectors/snapshot"
+	"balance/slb"
 	"fmt"
-	"net/http"
-	"sync"
+	"math/rand/v2"
+	"sort"
+	"sync/atomic"
 )
 
-// Random Selects targets sequentially (in a structured order)
+const DefaultWeight = 1
+
+type weightedEndpoint struct {
+	server *slb.Endpoint
+	weight int
+	// sum of the weights of the endpoints up to this one, it is updated with the snapshot
+	cumulative int
+}
+
+// Random selects targets uniformly at random.
+// Select is lock-free, it is safe to call concurrently with Add and Remove.
 type Random struct {
-	mu        *sync.Mutex
-	endpoints map[string]*http.Server
+	endpoints snapshot.List[weightedEndpoint]
+	// returns a random number in [0, n)
+	intn func(n int) int
 }
 
 func New() *Random {
-	return &Random{endpoints: make(map[string]*http.Server, 0), mu: &sync.Mutex{}}
+	return &Random{intn: rand.IntN}
+}
+
+// Returns a Random selector with a deterministic choice of targets (e.g for reproducible tests)
+func NewWithSeed(seed int64) *Random {
+	return &Random{intn: seeded(uint64(seed))}
+}
+
+// returns a random number generator of seed, that is safe for concurrent use (splitmix64 of the draws)
+func seeded(seed uint64) func(n int) int {
+	draws := atomic.Uint64{}
+	return func(n int) int {
+		h := seed + draws.Add(1)*0x9e3779b97f4a7c15
+		h = (h ^ (h >> 30)) * 0xbf58476d1ce4e5b9
+		h = (h ^ (h >> 27)) * 0x94d049bb133111eb
+		h ^= h >> 31
+		return int(h % uint64(n))
+	}
+}
+
+// Selects a target with a probability proportional to its weight (uniformly if the weights are equal)
+func (r *Random) Select() (*slb.Endpoint, error) {
+	endpoints := r.endpoints.Load()
+	if len(endpoints) == 0 {
+		return nil, fmt.Errorf("selector has no endpoints to select")
+	}
+	n := r.intn(endpoints[len(endpoints)-1].cumulative)
+	idx := sort.Search(len(endpoints), func(i int) bool { return endpoints[i].cumulative > n })
+	return endpoints[idx].server, nil
+}
+
+func (r *Random) EndPoints() ([]*slb.Endpoint, error) {
+	endpoints := r.endpoints.Load()
+	servers := make([]*slb.Endpoint, 0, len(endpoints))
+	for _, e := range endpoints {
+		servers = append(servers, e.server)
+	}
+	return servers, nil
 }
 
-func (r *Random) Select() (*http.Server, error) {
-	// first value returns random item, since it retrieves from a hash map
-	for _, v := range r.endpoints {
-		return v, nil
+// Adds server with the default weight, or replaces the server with its identity
+func (r *Random) Add(server *slb.Endpoint) error {
+	return r.add(server, DefaultWeight)
+}
+
+// adds server with weight, or replaces the server with its identity and weight
+func (r *Random) add(server *slb.Endpoint, weight int) error {
+	return r.update(func(endpoints []weightedEndpoint) ([]weightedEndpoint, error) {
+		if idx, found := find(endpoints, server); found {
+			endpoints[idx].server, endpoints[idx].weight = server, weight
+			return endpoints, nil
+		}
+		return append(endpoints, weightedEndpoint{server: server, weight: weight}), nil
+	})
+}
+
+func (r *Random) Remove(server *slb.Endpoint) error {
+	return r.update(func(endpoints []weightedEndpoint) ([]weightedEndpoint, error) {
+		if idx, found := find(endpoints, server); found {
+			return append(endpoints[:idx], endpoints[idx+1:]...), nil
+		}
+		return nil, fmt.Errorf("could not find server to delete %+v", server)
+	})
+}
+
+// updates the endpoints, and their cumulative weights
+func (r *Random) update(update func([]weightedEndpoint) ([]weightedEndpoint, error)) error {
+	return r.endpoints.Update(func(endpoints []weightedEndpoint) ([]weightedEndpoint, error) {
+		endpoints, err := update(endpoints)
+		if err != nil {
+			return nil, err
+		}
+		total := 0
+		for i := range endpoints {
+			total += endpoints[i].weight
+			endpoints[i].cumulative = total
+		}
+		return endpoints, nil
+	})
+}
+
+// finds server by its identity
+func find(endpoints []weightedEndpoint, server *slb.Endpoint) (int, bool) {
+	for i, e := range endpoints {
+		if e.server.Is(server) {
+			return i, true
+		}
 	}
+	return -1, false
+}
+
+// WeightedRandom selects targets at random with a probability proportional to their weight:
+// a target with weight 3 is selected 3 times as often as a target with weight 1 (on average).
+type WeightedRandom struct {
+	*Random
+}
 
-	return nil, fmt.Errorf("selector has no endpoints to select")
+func NewWeighted() *WeightedRandom {
+	return &WeightedRandom{Random: New()}
 }
 
-func (r *Random) EndPoints() ([]*http.Server, error) {
-	eList := make([]*http.Server, 0)
-	for _, v := range r.endpoints {
-		eList = append(eList, v)
+// Returns a WeightedRandom selector with a deterministic choice of targets (e.g for reproducible tests)
+func NewWeightedWithSeed(seed int64) *WeightedRandom {
+	return &WeightedRandom{Random: NewWithSeed(seed)}
+}
+
+// Adds server with its weight (default 1), or replaces the server with its identity
+func (w *WeightedRandom) Add(server *slb.Endpoint) error {
+	weight := DefaultWeight
+	if server.Weight > 0 {
+		weight = server.Weight
 	}
-	return eList, nil
+	return w.add(server, weight)
 }
 
-func (r *Random) Add(server *http.Server) error {
-	defer r.mu.Unlock()
-	r.mu.Lock()
-	r.endpoints[server.Addr] = server
-	return nil
+// Sets the weight of server
+func (w *WeightedRandom) SetWeight(server *slb.Endpoint, weight int) error {
+	if weight <= 0 {
+		return fmt.Errorf("weight must be positive, got %d", weight)
+	}
+	return w.update(func(endpoints []weightedEndpoint) ([]weightedEndpoint, error) {
+		idx, found := find(endpoints, server)
+		if !found {
+			return nil, fmt.Errorf("could not find server to set weight %+v", server)
+		}
+		endpoints[idx].weight = weight
+		return endpoints, nil
+	})
 }
 
-func (r *Random) Remove(server *http.Server) error {
-	defer r.mu.Unlock()
-	r.mu.Lock()
-	if _, ok := r.endpoints[server.Addr]; ok {
-		delete(r.endpoints, server.Addr)
-		return nil
+// Returns the weight of server
+func (w *WeightedRandom) Weight(server *slb.Endpoint) (int, error) {
+	endpoints := w.endpoints.Load()
+	idx, found := find(endpoints, server)
+	if !found {
+		return 0, fmt.Errorf("could not find server %+v", server)
 	}
-	return fmt.Errorf("could not find server to delete %+v", server)
+	return endpoints[idx].weight, nil
 }
@@ -1,18 +1,17 @@
 package randomSelector
 
 import (
-	"net/http"
+	"balance/internal/mock/mockEndpoints"
+	"balance/slb"
 	"testing"
 
 	"github.com/stretchr/testify/require"
-
-	"balance/internal/mock"
 )
 
 type RRTest struct {
 	name        string
 	t           *testing.T
-	endpoints   []*http.Server
+	endpoints   []*slb.Endpoint
 	nSelections int
 }
 
@@ -31,7 +30,7 @@ func (r *RRTest) Run() {
 	require.NoError(r.t, err)
 	require.NotEmpty(r.t, eList)
 
-	var selected []*http.Server
+	var selected []*slb.Endpoint
 	for nSelection := 1; nSelection <= r.nSelections; nSelection++ {
 		s, err := selector.Select()
 		selected = append(selected, s)
@@ -40,7 +39,7 @@ func (r *RRTest) Run() {
 	require.NotEmpty(r.t, selected)
 	require.True(r.t, len(selected) >= 1, "Expected multiple unique items, but got only one")
 
-	var selectedAgain []*http.Server
+	var selectedAgain []*slb.Endpoint
 	for nSelection := 1; nSelection <= r.nSelections; nSelection++ {
 		s, err := selector.Select()
 		selectedAgain = append(selectedAgain, s)
@@ -51,12 +50,12 @@ func (r *RRTest) Run() {
 	for _, e := range r.endpoints {
 		require.NoError(r.t, selector.Remove(e))
 	}
-	require.Error(r.t, selector.Remove(&http.Server{}))
+	require.Error(r.t, selector.Remove(&slb.Endpoint{}))
 }
 
 func TestRandom(t *testing.T) {
 	scenarios := []*RRTest{}
-	servers := mock.GenerateServers(20)
+	servers := mockEndpoints.Generate(20)
 	scenarioRandomSanity := &RRTest{"Random Sanity", t, servers, 30}
 
 	scenarios = append(scenarios, scenarioRandomSanity)
@@ -64,3 +63,97 @@ func TestRandom(t *testing.T) {
 		t.Run(scenario.name, func(t *testing.T) { scenario.Run() })
 	}
 }
+
+const nSelections = 100000
+
+// critical values of the chi-squared distribution at a significance of 0.001, by degrees of freedom
+var chiSquaredCritical = map[int]float64{4: 18.467, 9: 27.877}
+
+// selects n times from selector, and returns the chi-squared statistic of the selections
+// against the expected distribution of the weights of servers
+func chiSquared(t *testing.T, selector slb.Selector, servers []*slb.Endpoint, weights []int, n int) float64 {
+	counts := map[*slb.Endpoint]int{}
+	for i := 0; i < n; i++ {
+		server, err := selector.Select()
+		require.NoError(t, err)
+		counts[server]++
+	}
+	total := 0
+	for _, weight := range weights {
+		total += weight
+	}
+	statistic := 0.0
+	for i, server := range servers {
+		expected := float64(n*weights[i]) / float64(total)
+		diff := float64(counts[server]) - expected
+		statistic += diff * diff / expected
+	}
+	return statistic
+}
+
+func TestRandomIsUniform(t *testing.T) {
+	servers := mockEndpoints.Generate(10)
+	weights := []int{1, 1, 1, 1, 1, 1, 1, 1, 1, 1}
+	for seed := int64(1); seed <= 5; seed++ {
+		selector := NewWithSeed(seed)
+		for _, server := range servers {
+			require.NoError(t, selector.Add(server))
+		}
+		require.Less(t, chiSquared(t, selector, servers, weights, nSelections), chiSquaredCritical[len(servers)-1], "seed %d", seed)
+	}
+}
+
+func TestWeightedRandomIsProportional(t *testing.T) {
+	servers := mockEndpoints.Generate(5)
+	weights := []int{1, 2, 3, 4, 10}
+	for seed := int64(1); seed <= 5; seed++ {
+		selector := NewWeightedWithSeed(seed)
+		for i, server := range servers {
+			require.NoError(t, selector.Add(server))
+			require.NoError(t, selector.SetWeight(server, weights[i]))
+		}
+		require.Less(t, chiSquared(t, selector, servers, weights, nSelections), chiSquaredCritical[len(servers)-1], "seed %d", seed)
+	}
+
+	// a skewed distribution is detected
+	selector := NewWeightedWithSeed(1)
+	for _, server := range servers {
+		require.NoError(t, selector.Add(server))
+	}
+	require.Greater(t, chiSquared(t, selector, servers, weights, nSelections), chiSquaredCritical[len(servers)-1])
+}
+
+func TestRandomWithSeedIsReproducible(t *testing.T) {
+	servers := mockEndpoints.Generate(5)
+	first, second := NewWithSeed(42), NewWithSeed(42)
+	for _, server := range servers {
+		require.NoError(t, first.Add(server))
+		require.NoError(t, second.Add(server))
+	}
+	for i := 0; i < 100; i++ {
+		a, err := first.Select()
+		require.NoError(t, err)
+		b, err := second.Select()
+		require.NoError(t, err)
+		require.Same(t, a, b)
+	}
+}
+
+func TestWeightedRandomErrors(t *testing.T) {
+	selector := NewWeighted()
+	server := mockEndpoints.Generate(1)[0]
+	require.Error(t, selector.SetWeight(server, 2), "server was not added")
+	_, err := selector.Weight(server)
+	require.Error(t, err, "server was not added")
+
+	require.NoError(t, selector.Add(server))
+	require.Error(t, selector.SetWeight(server, 0))
+	require.NoError(t, selector.SetWeight(server, 3))
+	weight, err := selector.Weight(&slb.Endpoint{Addr: server.Addr})
+	require.NoError(t, err)
+	require.Equal(t, 3, weight)
+
+	var _ slb.Weighter = NewWeighted()
+	_, isWeighter := interface{}(New()).(slb.Weighter)
+	require.False(t, isWeighter)
+}
@@ -1,70 +1,58 @@
 package roundRobin
 
 import (
+	"balance/internal/selectors/snapshot"
+	"balance/slb"
 	"fmt"
-	"net/http"
-	"sync"
+	"sync/atomic"
 )
 
-// RoundRobin Selects targets sequentially (in a structured order)
+// RoundRobin Selects targets sequentially (in a structured order).
+// Select is lock-free, it is safe to call concurrently with Add and Remove.
 type RoundRobin struct {
-	mu        *sync.Mutex
-	endpoints []*http.Server
-	currIdx   int
+	endpoints snapshot.List[*slb.Endpoint]
+	// number of selections, the next target is selected by it
+	next atomic.Uint64
 }
 
 func New() *RoundRobin {
-	return &RoundRobin{endpoints: make([]*http.Server, 0), currIdx: 0, mu: &sync.Mutex{}}
+	return &RoundRobin{}
 }
 
-func (r *RoundRobin) Select() (*http.Server, error) {
-
-	endpoints, err := r.EndPoints()
-	if err != nil {
-		return nil, err
-	}
+func (r *RoundRobin) Select() (*slb.Endpoint, error) {
+	endpoints := r.endpoints.Load()
 	if len(endpoints) <= 0 {
 		return nil, fmt.Errorf("selector has no endpoints to select")
 	}
-
-	// manage index within defer to avoid index change at access
-	defer func([]*http.Server) {
-
-		if r.currIdx >= len(r.endpoints)-1 {
-			r.currIdx = 0 // reset index
-		} else {
-			r.currIdx++
-		}
-	}(endpoints)
-
-	return endpoints[r.currIdx], nil
+	return endpoints[(r.next.Add(1)-1)%uint64(len(endpoints))], nil
 }
 
-func (r *RoundRobin) EndPoints() ([]*http.Server, error) {
-	return r.endpoints, nil
+func (r *RoundRobin) EndPoints() ([]*slb.Endpoint, error) {
+	return append([]*slb.Endpoint{}, r.endpoints.Load()...), nil
 }
 
-func (r *RoundRobin) Add(server *http.Server) error {
-	defer r.mu.Unlock()
-	r.mu.Lock()
-	r.endpoints = append(r.endpoints, server)
-	return nil
+func (r *RoundRobin) Add(server *slb.Endpoint) error {
+	return r.endpoints.Update(func(endpoints []*slb.Endpoint) ([]*slb.Endpoint, error) {
+		if found, _ := r.findInPool(server, endpoints); found {
+			return nil, fmt.Errorf("server already exists %+v", server.Addr)
+		}
+		return append(endpoints, server), nil
+	})
 }
 
-func (r *RoundRobin) Remove(server *http.Server) error {
-	defer r.mu.Unlock()
-	r.mu.Lock()
-	if found, idx := r.findInPool(server, r.endpoints); found {
-		r.endpoints = append(r.endpoints[:idx], r.endpoints[idx+1:]...)
-		return nil
-	}
-	return fmt.Errorf("could not find server to delete %+v", server)
+func (r *RoundRobin) Remove(server *slb.Endpoint) error {
+	return r.endpoints.Update(func(endpoints []*slb.Endpoint) ([]*slb.Endpoint, error) {
+		if found, idx := r.findInPool(server, endpoints); found {
+			return append(endpoints[:idx], endpoints[idx+1:]...), nil
+		}
+		return nil, fmt.Errorf("could not find server to delete %+v", server)
+	})
 }
 
-func (r *RoundRobin) findInPool(server *http.Server, endpoints []*http.Server) (bool, int) {
+func (r *RoundRobin) findInPool(server *slb.Endpoint, endpoints []*slb.Endpoint) (bool, int) {
 	found := false
 	for i, s := range endpoints {
-		if s == server {
+		if s.Is(server) {
 			return true, i
 		}
 	}
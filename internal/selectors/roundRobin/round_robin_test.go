@@ -1,20 +1,19 @@
 package roundRobin
 
 import (
-	"net/http"
+	"balance/internal/mock/mockEndpoints"
+	"balance/slb"
 	"testing"
 
 	"github.com/stretchr/testify/require"
-
-	"balance/internal/mock"
 )
 
 type RRTest struct {
 	name        string
 	t           *testing.T
-	endpoints   []*http.Server
+	endpoints   []*slb.Endpoint
 	nSelections int
-	toSelect    *http.Server
+	toSelect    *slb.Endpoint
 }
 
 func (r *RRTest) Run() {
@@ -41,7 +40,7 @@ func (r *RRTest) Run() {
 
 func TestRoundRobin(t *testing.T) {
 	scenarios := []*RRTest{}
-	servers := mock.GenerateServers(3)
+	servers := mockEndpoints.Generate(3)
 	scenarioWithinRange := &RRTest{"test selection within endpoint range", t, servers, 1, servers[0]}
 	scenarioOutSideRange := &RRTest{"selection outside of endpoint range", t, servers, 5, servers[1]}
 
@@ -0,0 +1,215 @@
+package selectors_test
+
+import (
+	"balance/internal/mock/mockEndpoints"
+	"balance/internal/selectors/consistentHash"
+	"balance/internal/selectors/leastConnections"
+	"balance/internal/selectors/p2c"
+	randomSelector "balance/internal/selectors/random"
+	"balance/internal/selectors/roundRobin"
+	"balance/internal/selectors/weightedRoundRobin"
+	"balance/slb"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+var selectors = []struct {
+	name string
+	new  func() slb.Selector
+}{
+	{"roundRobin", func() slb.Selector { return roundRobin.New() }},
+	{"weightedRoundRobin", func() slb.Selector { return weightedRoundRobin.New() }},
+	{"random", func() slb.Selector { return randomSelector.New() }},
+	{"weightedRandom", func() slb.Selector { return randomSelector.NewWeighted() }},
+	{"leastConnections", func() slb.Selector { return leastConnections.New() }},
+	{"weightedLeastConnections", func() slb.Selector { return leastConnections.NewWeighted() }},
+	{"p2c", func() slb.Selector { return p2c.New() }},
+	{"consistentHash", func() slb.Selector { return consistentHash.NewWithReplicas(10) }},
+}
+
+// selects from selector the way the SLB does: by request if it implements RequestSelector,
+// and tracking the request if it implements Tracker
+func selectEndpoint(selector slb.Selector, key string) (*slb.Endpoint, error) {
+	var server *slb.Endpoint
+	var err error
+	if requestSelector, ok := selector.(slb.RequestSelector); ok {
+		server, err = requestSelector.SelectRequest(nil, key)
+	} else {
+		server, err = selector.Select()
+	}
+	if err != nil {
+		return nil, err
+	}
+	if tracker, ok := selector.(slb.Tracker); ok {
+		tracker.Acquire(server)(slb.Outcome{StatusCode: http.StatusOK})
+	}
+	return server, nil
+}
+
+// adds and removes churned to selector (and sets their weights) until stop is closed
+func churn(selector slb.Selector, churned []*slb.Endpoint, stop <-chan struct{}) {
+	weighter, weighted := selector.(slb.Weighter)
+	for i := 0; ; i++ {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+		server := churned[i%len(churned)]
+		if selector.Add(server) == nil && weighted {
+			weighter.SetWeight(server, 1+i%3)
+		}
+		selector.EndPoints()
+		selector.Remove(server)
+	}
+}
+
+// hammers Select concurrently with Add, Remove and SetWeight, run with -race to detect data races
+func TestSelectorsConcurrentSelect(t *testing.T) {
+	for _, s := range selectors {
+		t.Run(s.name, func(t *testing.T) {
+			selector := s.new()
+			stable, churned := mockEndpoints.Generate(3), mockEndpoints.Generate(3)
+			known := map[*slb.Endpoint]bool{}
+			for _, server := range stable {
+				require.NoError(t, selector.Add(server))
+				known[server] = true
+			}
+			for _, server := range churned {
+				known[server] = true
+			}
+
+			stop := make(chan struct{})
+			churning := sync.WaitGroup{}
+			for i := range 2 {
+				churning.Add(1)
+				go func() {
+					defer churning.Done()
+					churn(selector, churned[i:i+2], stop)
+				}()
+			}
+			selecting := sync.WaitGroup{}
+			var unknown atomic.Int64
+			for i := range 8 {
+				selecting.Add(1)
+				go func() {
+					defer selecting.Done()
+					for j := range 1000 {
+						server, err := selectEndpoint(selector, strconv.Itoa(i*1000+j))
+						if err != nil || !known[server] {
+							unknown.Add(1)
+						}
+					}
+				}()
+			}
+			selecting.Wait()
+			close(stop)
+			churning.Wait()
+
+			require.Zero(t, unknown.Load(), "every selection returns an added endpoint")
+			endpoints, err := selector.EndPoints()
+			require.NoError(t, err)
+			require.ElementsMatch(t, stable, endpoints)
+		})
+	}
+}
+
+func TestSelectorsFindEndpointsByIdentity(t *testing.T) {
+	for _, s := range selectors {
+		t.Run(s.name, func(t *testing.T) {
+			selector := s.new()
+			servers := mockEndpoints.Generate(2)
+			servers[0].ID = "backend-0"
+			for _, server := range servers {
+				require.NoError(t, selector.Add(server))
+			}
+			// an endpoint with the identity of an added endpoint is rejected or replaces it
+			selector.Add(&slb.Endpoint{ID: "backend-0", Addr: "other"})
+			endpoints, err := selector.EndPoints()
+			require.NoError(t, err)
+			require.Len(t, endpoints, 2)
+
+			require.NoError(t, selector.Remove(&slb.Endpoint{ID: "backend-0", Addr: "other"}), "removed by its id")
+			require.NoError(t, selector.Remove(&slb.Endpoint{Addr: servers[1].Addr}), "removed by its address")
+			endpoints, err = selector.EndPoints()
+			require.NoError(t, err)
+			require.Empty(t, endpoints)
+		})
+	}
+}
+
+func TestWeightersAddEndpointsWithTheirWeight(t *testing.T) {
+	for _, s := range selectors {
+		weighter, ok := s.new().(slb.Weighter)
+		if !ok {
+			continue
+		}
+		t.Run(s.name, func(t *testing.T) {
+			servers := mockEndpoints.Generate(2)
+			servers[0].Weight = 3
+			for _, server := range servers {
+				require.NoError(t, weighter.(slb.Selector).Add(server))
+			}
+			weight, err := weighter.Weight(servers[0])
+			require.NoError(t, err)
+			require.Equal(t, 3, weight)
+			weight, err = weighter.Weight(servers[1])
+			require.NoError(t, err)
+			require.Equal(t, 1, weight, "endpoints without a weight have the default weight")
+		})
+	}
+}
+
+func BenchmarkSelectorsSelect(b *testing.B) {
+	for _, s := range selectors {
+		b.Run(s.name, func(b *testing.B) {
+			selector := s.new()
+			for _, server := range mockEndpoints.Generate(10) {
+				require.NoError(b, selector.Add(server))
+			}
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for i := 0; pb.Next(); i++ {
+					if _, err := selectEndpoint(selector, strconv.Itoa(i)); err != nil {
+						b.Error(err)
+						return
+					}
+				}
+			})
+		})
+	}
+}
+
+// selects in parallel while endpoints are added and removed
+func BenchmarkSelectorsSelectWithChurn(b *testing.B) {
+	for _, s := range selectors {
+		b.Run(s.name, func(b *testing.B) {
+			selector := s.new()
+			for _, server := range mockEndpoints.Generate(10) {
+				require.NoError(b, selector.Add(server))
+			}
+			stop, done := make(chan struct{}), make(chan struct{})
+			go func() {
+				defer close(done)
+				churn(selector, mockEndpoints.Generate(2), stop)
+			}()
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for i := 0; pb.Next(); i++ {
+					if _, err := selectEndpoint(selector, strconv.Itoa(i)); err != nil {
+						b.Error(err)
+						return
+					}
+				}
+			})
+			b.StopTimer()
+			close(stop)
+			<-done
+		})
+	}
+}
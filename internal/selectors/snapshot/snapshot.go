@@ -0,0 +1,36 @@
+package snapshot
+
+import (
+	"slices"
+	"sync"
+	"sync/atomic"
+)
+
+// List is a list of endpoints that is read without locks: readers load an immutable snapshot,
+// while writers replace it with an updated copy (writers are serialized by a mutex).
+// The zero value is an empty list.
+type List[T any] struct {
+	mu      sync.Mutex
+	current atomic.Pointer[[]T]
+}
+
+// Returns the current snapshot, it must not be modified
+func (l *List[T]) Load() []T {
+	if current := l.current.Load(); current != nil {
+		return *current
+	}
+	return nil
+}
+
+// Replaces the snapshot by the result of update, which gets a copy of the current snapshot.
+// The snapshot is kept if update returns an error.
+func (l *List[T]) Update(update func([]T) ([]T, error)) error {
+	defer l.mu.Unlock()
+	l.mu.Lock()
+	next, err := update(slices.Clone(l.Load()))
+	if err != nil {
+		return err
+	}
+	l.current.Store(&next)
+	return nil
+}
@@ -0,0 +1,44 @@
+package snapshot
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestListUpdate(t *testing.T) {
+	list := List[int]{}
+	require.Empty(t, list.Load())
+
+	require.NoError(t, list.Update(func(values []int) ([]int, error) { return append(values, 1, 2), nil }))
+	before := list.Load()
+	require.NoError(t, list.Update(func(values []int) ([]int, error) {
+		values[0] = 3
+		return values, nil
+	}))
+	require.Equal(t, []int{1, 2}, before, "loaded snapshots are not changed by updates")
+	require.Equal(t, []int{3, 2}, list.Load())
+
+	require.Error(t, list.Update(func(values []int) ([]int, error) { return nil, fmt.Errorf("failed") }))
+	require.Equal(t, []int{3, 2}, list.Load(), "the snapshot is kept if the update fails")
+}
+
+func TestListConcurrentUpdates(t *testing.T) {
+	list := List[int]{}
+	wg := sync.WaitGroup{}
+	for i := range 100 {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			list.Update(func(values []int) ([]int, error) { return append(values, i), nil })
+		}()
+		go func() {
+			defer wg.Done()
+			_ = len(list.Load())
+		}()
+	}
+	wg.Wait()
+	require.Len(t, list.Load(), 100, "no update is lost")
+}
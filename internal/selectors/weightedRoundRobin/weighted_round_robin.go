@@ -0,0 +1,127 @@
+package weightedRoundRobin
+
+import (
+	"balance/internal/selectors/snapshot"
+	"balance/slb"
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+const DefaultWeight = 1
+
+type weightedEndpoint struct {
+	server *slb.Endpoint
+	weight atomic.Int64
+	// smooth weighted round robin state, guarded by the selection lock
+	currentWeight int64
+}
+
+// WeightedRoundRobin selects targets in a smooth weighted round robin order (as nginx does):
+// an endpoint with weight 3 is selected 3 times as often as an endpoint with weight 1,
+// while the selections are interleaved instead of being sent in bursts.
+// The endpoints are a copy-on-write snapshot, only the selection itself is serialized,
+// since every selection updates the current weights of the endpoints.
+type WeightedRoundRobin struct {
+	endpoints snapshot.List[*weightedEndpoint]
+	// serializes the updates of the current weights
+	mu sync.Mutex
+}
+
+func New() *WeightedRoundRobin {
+	return &WeightedRoundRobin{}
+}
+
+func (w *WeightedRoundRobin) Select() (*slb.Endpoint, error) {
+	endpoints := w.endpoints.Load()
+	if len(endpoints) <= 0 {
+		return nil, fmt.Errorf("selector has no endpoints to select")
+	}
+
+	defer w.mu.Unlock()
+	w.mu.Lock()
+	var total int64
+	var selected *weightedEndpoint
+	for _, e := range endpoints {
+		weight := e.weight.Load()
+		e.currentWeight += weight
+		total += weight
+		if selected == nil || e.currentWeight > selected.currentWeight {
+			selected = e
+		}
+	}
+	selected.currentWeight -= total
+	return selected.server, nil
+}
+
+func (w *WeightedRoundRobin) EndPoints() ([]*slb.Endpoint, error) {
+	endpoints := w.endpoints.Load()
+	servers := make([]*slb.Endpoint, 0, len(endpoints))
+	for _, e := range endpoints {
+		servers = append(servers, e.server)
+	}
+	return servers, nil
+}
+
+// Adds server with its weight (default 1)
+func (w *WeightedRoundRobin) Add(server *slb.Endpoint) error {
+	return w.endpoints.Update(func(endpoints []*weightedEndpoint) ([]*weightedEndpoint, error) {
+		if _, found := find(endpoints, server); found {
+			return nil, fmt.Errorf("server already exists %+v", server.Addr)
+		}
+		e := &weightedEndpoint{server: server}
+		e.weight.Store(int64(weightOf(server)))
+		return append(endpoints, e), nil
+	})
+}
+
+func (w *WeightedRoundRobin) Remove(server *slb.Endpoint) error {
+	return w.endpoints.Update(func(endpoints []*weightedEndpoint) ([]*weightedEndpoint, error) {
+		for i, e := range endpoints {
+			if e.server.Is(server) {
+				return append(endpoints[:i], endpoints[i+1:]...), nil
+			}
+		}
+		return nil, fmt.Errorf("could not find server to delete %+v", server)
+	})
+}
+
+// Sets the weight of server, without resetting the rotation
+func (w *WeightedRoundRobin) SetWeight(server *slb.Endpoint, weight int) error {
+	if weight <= 0 {
+		return fmt.Errorf("weight must be positive, got %d", weight)
+	}
+	e, found := find(w.endpoints.Load(), server)
+	if !found {
+		return fmt.Errorf("could not find server to set weight %+v", server)
+	}
+	e.weight.Store(int64(weight))
+	return nil
+}
+
+// Returns the weight of server
+func (w *WeightedRoundRobin) Weight(server *slb.Endpoint) (int, error) {
+	e, found := find(w.endpoints.Load(), server)
+	if !found {
+		return 0, fmt.Errorf("could not find server %+v", server)
+	}
+	return int(e.weight.Load()), nil
+}
+
+// returns the weight of server, or the default weight if it is not set
+func weightOf(server *slb.Endpoint) int {
+	if server.Weight > 0 {
+		return server.Weight
+	}
+	return DefaultWeight
+}
+
+// finds server by its identity
+func find(endpoints []*weightedEndpoint, server *slb.Endpoint) (*weightedEndpoint, bool) {
+	for _, e := range endpoints {
+		if e.server.Is(server) {
+			return e, true
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,126 @@
+package weightedRoundRobin
+
+import (
+	"balance/internal/mock/mockEndpoints"
+	"balance/slb"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type WRRTest struct {
+	name     string
+	t        *testing.T
+	weights  []int
+	expected []int
+}
+
+func (w *WRRTest) Run() {
+	selector := New()
+	_, err := selector.Select()
+	require.Error(w.t, err, "expected no endpoints to select")
+
+	servers := mockEndpoints.Generate(len(w.weights))
+	for i, server := range servers {
+		require.NoError(w.t, selector.Add(server))
+		require.NoError(w.t, selector.SetWeight(server, w.weights[i]))
+	}
+
+	selected := []int{}
+	for range w.expected {
+		server, err := selector.Select()
+		require.NoError(w.t, err)
+		selected = append(selected, indexOf(servers, server))
+	}
+	require.Equal(w.t, w.expected, selected)
+
+	for _, server := range servers {
+		require.NoError(w.t, selector.Remove(server))
+	}
+	require.Error(w.t, selector.Remove(servers[0]), "all endoints removed, expected error")
+}
+
+func indexOf(servers []*slb.Endpoint, server *slb.Endpoint) int {
+	for i, s := range servers {
+		if s == server {
+			return i
+		}
+	}
+	return -1
+}
+
+func TestWeightedRoundRobin(t *testing.T) {
+	scenarios := []*WRRTest{
+		{"equal weights", t, []int{1, 1, 1}, []int{0, 1, 2, 0, 1, 2}},
+		{"smooth weights", t, []int{5, 1, 1}, []int{0, 0, 1, 0, 2, 0, 0, 0, 0, 1, 0, 2, 0, 0}},
+		{"two to one", t, []int{2, 1}, []int{0, 1, 0, 0, 1, 0}},
+	}
+	for _, scenario := range scenarios {
+		t.Run(scenario.name, func(t *testing.T) { scenario.Run() })
+	}
+}
+
+func TestSetWeightKeepsRotation(t *testing.T) {
+	selector := New()
+	servers := mockEndpoints.Generate(2)
+	for _, server := range servers {
+		require.NoError(t, selector.Add(server))
+	}
+	first, err := selector.Select()
+	require.NoError(t, err)
+	require.Same(t, servers[0], first)
+
+	// the current weights are kept, a reset rotation would select servers[0] twice in a row
+	require.NoError(t, selector.SetWeight(servers[0], 3))
+	selected := []*slb.Endpoint{}
+	for i := 0; i < 4; i++ {
+		server, err := selector.Select()
+		require.NoError(t, err)
+		selected = append(selected, server)
+	}
+	require.Equal(t, []*slb.Endpoint{servers[0], servers[1], servers[0], servers[0]}, selected)
+
+	weight, err := selector.Weight(servers[0])
+	require.NoError(t, err)
+	require.Equal(t, 3, weight)
+}
+
+func TestWeightedRoundRobinErrors(t *testing.T) {
+	selector := New()
+	server := mockEndpoints.Generate(1)[0]
+	require.Error(t, selector.SetWeight(server, 2), "server was not added")
+	_, err := selector.Weight(server)
+	require.Error(t, err, "server was not added")
+
+	require.NoError(t, selector.Add(server))
+	require.Error(t, selector.Add(&slb.Endpoint{Addr: server.Addr}), "server already exists")
+	require.Error(t, selector.SetWeight(server, 0))
+	require.Error(t, selector.SetWeight(server, -1))
+
+	endpoints, err := selector.EndPoints()
+	require.NoError(t, err)
+	require.Equal(t, []*slb.Endpoint{server}, endpoints)
+}
+
+func TestLargeCoprimeWeights(t *testing.T) {
+	selector := New()
+	servers := mockEndpoints.Generate(2)
+	servers[0].Weight = 4000000000
+	for _, server := range servers {
+		require.NoError(t, selector.Add(server))
+	}
+	// the weights are applied per selection, without computing the whole rotation
+	for i := 0; i < 1000; i++ {
+		server, err := selector.Select()
+		require.NoError(t, err)
+		require.Same(t, servers[0], server)
+	}
+	require.NoError(t, selector.SetWeight(servers[1], 3999999999))
+	selected := map[*slb.Endpoint]int{}
+	for i := 0; i < 1000; i++ {
+		server, err := selector.Select()
+		require.NoError(t, err)
+		selected[server]++
+	}
+	require.InDelta(t, 500, selected[servers[1]], 2)
+}
@@ -18,30 +18,50 @@ const DefaultClientCertPath = DefaultCertsDirectory + "client-cert.pem"
 const DefaultClientKeyPath = DefaultCertsDirectory + "client-key.pem"
 
 func GetTlsCredentials(caPath string, certPath string, keyPath string) (credentials.TransportCredentials, error) {
-	caCert, err := os.ReadFile(caPath)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read CA certificate: %s", err.Error())
+	// the CA verifies the client certificates of mTLS, there are no system roots for them
+	if caPath == "" {
+		return nil, fmt.Errorf("failed to read CA certificate: no CA path provided")
 	}
-	caCertPool := x509.NewCertPool()
-	if !caCertPool.AppendCertsFromPEM(caCert) {
-		return nil, fmt.Errorf("failed to append CA certificate to cert pool")
+	// the server presents its certificate to the clients
+	if certPath == "" || keyPath == "" {
+		return nil, fmt.Errorf("failed to load certificate and key: no certificate or key path provided")
 	}
-
-	// Load server certificate and key
-	serverCert, err := tls.LoadX509KeyPair(certPath, keyPath)
+	tlsConfig, err := GetTlsConfig(caPath, certPath, keyPath)
 	if err != nil {
-		return nil, fmt.Errorf("failed to load certificate and key: %s", err.Error())
-	}
-
-	// Configure TLS
-	tlsConfig := &tls.Config{
-		Certificates: []tls.Certificate{serverCert},
-		RootCAs:      caCertPool,
-		ClientCAs:    caCertPool,
-		ClientAuth:   tls.RequireAndVerifyClientCert, // RequireAndVerifyFor mTLS
+		return nil, err
 	}
+	tlsConfig.ClientCAs = tlsConfig.RootCAs
+	tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert // RequireAndVerifyFor mTLS
 
 	// Create gRPC server with TLS credentials
 	creds := credentials.NewTLS(tlsConfig)
 	return creds, nil
 }
+
+// Returns a tls configuration that verifies peers with the CA certificates at caPath
+// and presents the certificate at certPath and keyPath.
+// The system roots are used if caPath is empty, and no certificate is presented if certPath and keyPath are empty.
+func GetTlsConfig(caPath string, certPath string, keyPath string) (*tls.Config, error) {
+	tlsConfig := &tls.Config{}
+	if caPath != "" {
+		caCert, err := os.ReadFile(caPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA certificate: %s", err.Error())
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(caCert) {
+			return nil, fmt.Errorf("failed to append CA certificate to cert pool")
+		}
+	}
+	if certPath == "" && keyPath == "" {
+		return tlsConfig, nil
+	}
+
+	// Load certificate and key
+	cert, err := tls.LoadX509KeyPair(certPath, keyPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load certificate and key: %s", err.Error())
+	}
+	tlsConfig.Certificates = []tls.Certificate{cert}
+	return tlsConfig, nil
+}
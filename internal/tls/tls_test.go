@@ -0,0 +1,75 @@
+package tls
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// writes a self signed certificate and its key to dir, and returns their paths
+func writeCertificate(t *testing.T, dir string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "balance"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	certPath, keyPath := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	require.NoError(t, os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	require.NoError(t, os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0o600))
+	return certPath, keyPath
+}
+
+func TestGetTlsCredentials(t *testing.T) {
+	certPath, keyPath := writeCertificate(t, t.TempDir())
+	creds, err := GetTlsCredentials(certPath, certPath, keyPath)
+	require.NoError(t, err)
+	require.Equal(t, "tls", creds.Info().SecurityProtocol)
+
+	_, err = GetTlsCredentials("", certPath, keyPath)
+	require.Error(t, err, "the CA verifies the client certificates")
+	_, err = GetTlsCredentials(certPath, "", "")
+	require.Error(t, err, "the server presents a certificate")
+	_, err = GetTlsCredentials(certPath, certPath, "")
+	require.Error(t, err)
+	_, err = GetTlsCredentials(certPath, "", keyPath)
+	require.Error(t, err)
+}
+
+func TestGetTlsConfig(t *testing.T) {
+	certPath, keyPath := writeCertificate(t, t.TempDir())
+	config, err := GetTlsConfig(certPath, certPath, keyPath)
+	require.NoError(t, err)
+	require.NotNil(t, config.RootCAs)
+	require.Len(t, config.Certificates, 1)
+
+	config, err = GetTlsConfig("", "", "")
+	require.NoError(t, err)
+	require.Nil(t, config.RootCAs, "the system roots are used")
+	require.Empty(t, config.Certificates)
+
+	_, err = GetTlsConfig(filepath.Join(t.TempDir(), "missing.pem"), "", "")
+	require.Error(t, err)
+	_, err = GetTlsConfig("", certPath, "")
+	require.Error(t, err)
+}
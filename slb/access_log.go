@@ -0,0 +1,461 @@
+package slb
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// AccessLogFormat is the format of the access log entries
+type AccessLogFormat string
+
+const (
+	// One json object per request
+	AccessLogJSON AccessLogFormat = "json"
+	// NCSA Common Log Format
+	AccessLogCommon AccessLogFormat = "common"
+	// NCSA Combined Log Format (common with referer and user agent)
+	AccessLogCombined AccessLogFormat = "combined"
+	// key=value pairs per request
+	AccessLogLogfmt AccessLogFormat = "logfmt"
+
+	AccessLogStdout           = "stdout"
+	AccessLogStderr           = "stderr"
+	DefaultRequestIDHeader    = "X-Request-Id"
+	accessLogRedacted         = "REDACTED"
+	accessLogTimeFormat       = "2006-01-02T15:04:05.000Z07:00"
+	accessLogCommonTimeFormat = "02/Jan/2006:15:04:05 -0700"
+)
+
+// Fields of the access log entries, in the order they are written
+const (
+	AccessLogFieldTime            = "time"
+	AccessLogFieldClient          = "client"
+	AccessLogFieldMethod          = "method"
+	AccessLogFieldPath            = "path"
+	AccessLogFieldQuery           = "query"
+	AccessLogFieldProtocol        = "protocol"
+	AccessLogFieldHost            = "host"
+	AccessLogFieldPool            = "pool"
+	AccessLogFieldBackend         = "backend"
+	AccessLogFieldStatus          = "status"
+	AccessLogFieldBytes           = "bytes"
+	AccessLogFieldUpstreamLatency = "upstreamLatency"
+	AccessLogFieldDuration        = "duration"
+	AccessLogFieldAttempts        = "attempts"
+	AccessLogFieldRequestID       = "requestId"
+	AccessLogFieldReferer         = "referer"
+	AccessLogFieldUserAgent       = "userAgent"
+)
+
+var accessLogFields = []string{
+	AccessLogFieldTime, AccessLogFieldClient, AccessLogFieldMethod, AccessLogFieldPath, AccessLogFieldQuery,
+	AccessLogFieldProtocol, AccessLogFieldHost, AccessLogFieldPool, AccessLogFieldBackend, AccessLogFieldStatus,
+	AccessLogFieldBytes, AccessLogFieldUpstreamLatency, AccessLogFieldDuration, AccessLogFieldAttempts,
+	AccessLogFieldRequestID, AccessLogFieldReferer, AccessLogFieldUserAgent,
+}
+
+var (
+	ErrInvalidAccessLog = func(reason string) error { return fmt.Errorf("invalid access log configuration: %s", reason) }
+)
+
+// AccessLogConfig configures the access log, that records every proxied request.
+// The access log is disabled if no Output is provided.
+type AccessLogConfig struct {
+	// "stdout", "stderr" or the path of a log file
+	Output string `json:"output,omitempty"`
+	// Format of the entries (default "json").
+	// The common and combined formats only contain their standard fields.
+	Format AccessLogFormat `json:"format,omitempty"`
+	// Size in bytes after which the log file is rotated, it is not rotated if 0
+	MaxFileBytes int64 `json:"maxFileBytes,omitempty"`
+	// Number of rotated files that are kept (<output>.1 is the newest), all are kept if 0
+	MaxBackups int `json:"maxBackups,omitempty"`
+	// Fraction of the requests that is logged (e.g 0.1), all requests are logged if 0.
+	// Requests that failed with a 5xx status are always logged.
+	SampleRate float64 `json:"sampleRate,omitempty"`
+	// Fields whose values are replaced with "REDACTED" (e.g "query", "client")
+	Redact []string `json:"redact,omitempty"`
+	// Header of the request id, that is set on requests without one (default "X-Request-Id")
+	RequestIDHeader string `json:"requestIdHeader,omitempty"`
+}
+
+// Returns true if an output is configured
+func (a AccessLogConfig) Enabled() bool {
+	return a.Output != ""
+}
+
+// Validates the access log configuration
+func (a AccessLogConfig) Validate() error {
+	if !a.Enabled() {
+		return nil
+	}
+	switch a.Format {
+	case "", AccessLogJSON, AccessLogCommon, AccessLogCombined, AccessLogLogfmt:
+	default:
+		return ErrInvalidAccessLog("unknown format " + string(a.Format))
+	}
+	if a.MaxFileBytes < 0 || a.MaxBackups < 0 {
+		return ErrInvalidAccessLog("max file bytes and max backups must not be negative")
+	}
+	if a.SampleRate < 0 || a.SampleRate > 1 {
+		return ErrInvalidAccessLog("sample rate must be between 0 and 1")
+	}
+	for _, field := range a.Redact {
+		if !slices.Contains(accessLogFields, field) {
+			return ErrInvalidAccessLog("unknown field " + field)
+		}
+	}
+	return nil
+}
+
+// accessLogEntry collects the details of a request while it is proxied
+type accessLogEntry struct {
+	pool     string
+	backend  string
+	upstream time.Duration
+	attempts int
+}
+
+type accessLogEntryKey struct{}
+
+// returns the access log entry of a request, nil if the request is not logged
+func accessLogEntryOf(r *http.Request) *accessLogEntry {
+	entry, _ := r.Context().Value(accessLogEntryKey{}).(*accessLogEntry)
+	return entry
+}
+
+// records the pool the request is routed to
+func (e *accessLogEntry) routed(pool string) {
+	if e != nil {
+		e.pool = pool
+	}
+}
+
+// records an attempt to proxy the request to backend
+func (e *accessLogEntry) attempt(backend string, latency time.Duration) {
+	if e == nil {
+		return
+	}
+	e.backend = backend
+	e.upstream += latency
+	e.attempts++
+}
+
+// accessLogger writes an entry per request to its output, a nil accessLogger logs nothing
+type accessLogger struct {
+	cfg    AccessLogConfig
+	mu     sync.Mutex
+	out    io.Writer
+	redact map[string]bool
+	sample func() bool
+	now    func() time.Time
+}
+
+func newAccessLogger(cfg AccessLogConfig) (*accessLogger, error) {
+	if !cfg.Enabled() {
+		return nil, nil
+	}
+	if cfg.Format == "" {
+		cfg.Format = AccessLogJSON
+	}
+	if cfg.RequestIDHeader == "" {
+		cfg.RequestIDHeader = DefaultRequestIDHeader
+	}
+	a := &accessLogger{cfg: cfg, redact: make(map[string]bool), now: time.Now}
+	for _, field := range cfg.Redact {
+		a.redact[field] = true
+	}
+	a.sample = func() bool { return cfg.SampleRate == 0 || rand.Float64() < cfg.SampleRate }
+	switch cfg.Output {
+	case AccessLogStdout:
+		a.out = os.Stdout
+	case AccessLogStderr:
+		a.out = os.Stderr
+	default:
+		file, err := newRotatingFile(cfg.Output, cfg.MaxFileBytes, cfg.MaxBackups)
+		if err != nil {
+			return nil, ErrInvalidAccessLog(err.Error())
+		}
+		a.out = file
+	}
+	return a, nil
+}
+
+// Serves the request with next and logs it once it is served
+func (a *accessLogger) Handle(rw http.ResponseWriter, r *http.Request, next func(http.ResponseWriter, *http.Request)) {
+	if a == nil {
+		next(rw, r)
+		return
+	}
+	start := a.now()
+	entry := &accessLogEntry{}
+	r = r.WithContext(context.WithValue(r.Context(), accessLogEntryKey{}, entry))
+	requestID := r.Header.Get(a.cfg.RequestIDHeader)
+	if requestID == "" {
+		requestID = uuid.NewString()
+		r.Header = r.Header.Clone()
+		r.Header.Set(a.cfg.RequestIDHeader, requestID)
+	}
+	rw.Header().Set(a.cfg.RequestIDHeader, requestID)
+	recorder := newResponseRecorder(rw)
+
+	next(recorder, r)
+
+	if recorder.Status() < http.StatusInternalServerError && !a.sample() {
+		return
+	}
+	client, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		client = r.RemoteAddr
+	}
+	fields := []accessLogField{
+		{AccessLogFieldTime, start},
+		{AccessLogFieldClient, client},
+		{AccessLogFieldMethod, r.Method},
+		{AccessLogFieldPath, r.URL.Path},
+		{AccessLogFieldQuery, r.URL.RawQuery},
+		{AccessLogFieldProtocol, r.Proto},
+		{AccessLogFieldHost, r.Host},
+		{AccessLogFieldPool, entry.pool},
+		{AccessLogFieldBackend, entry.backend},
+		{AccessLogFieldStatus, recorder.Status()},
+		{AccessLogFieldBytes, recorder.bytes},
+		{AccessLogFieldUpstreamLatency, entry.upstream},
+		{AccessLogFieldDuration, a.now().Sub(start)},
+		{AccessLogFieldAttempts, entry.attempts},
+		{AccessLogFieldRequestID, requestID},
+		{AccessLogFieldReferer, r.Referer()},
+		{AccessLogFieldUserAgent, r.UserAgent()},
+	}
+	for i := range fields {
+		if a.redact[fields[i].name] {
+			fields[i].value = accessLogRedacted
+		}
+	}
+	a.write(fields)
+}
+
+type accessLogField struct {
+	name  string
+	value any
+}
+
+func (a *accessLogger) write(fields []accessLogField) {
+	var line string
+	switch a.cfg.Format {
+	case AccessLogCommon:
+		line = commonLogLine(fields, false)
+	case AccessLogCombined:
+		line = commonLogLine(fields, true)
+	case AccessLogLogfmt:
+		line = logfmtLine(fields)
+	default:
+		line = jsonLine(fields)
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, err := io.WriteString(a.out, line+"\n"); err != nil {
+		slog.Error(fmt.Sprintf("failed to write the access log: %s", err))
+	}
+}
+
+// Closes the output of the access log, if it is a file
+func (a *accessLogger) Close() error {
+	if a == nil {
+		return nil
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if file, ok := a.out.(*rotatingFile); ok {
+		return file.Close()
+	}
+	return nil
+}
+
+// formats a field value, durations in seconds
+func formatAccessLogValue(value any) string {
+	switch v := value.(type) {
+	case time.Time:
+		return v.Format(accessLogTimeFormat)
+	case time.Duration:
+		return strconv.FormatFloat(v.Seconds(), 'f', -1, 64)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+func jsonLine(fields []accessLogField) string {
+	b := &strings.Builder{}
+	b.WriteByte('{')
+	for i, field := range fields {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		name, _ := json.Marshal(field.name)
+		b.Write(name)
+		b.WriteByte(':')
+		var value []byte
+		switch v := field.value.(type) {
+		case time.Time:
+			value, _ = json.Marshal(formatAccessLogValue(v))
+		case time.Duration:
+			value = []byte(formatAccessLogValue(v))
+		default:
+			value, _ = json.Marshal(v)
+		}
+		b.Write(value)
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+func logfmtLine(fields []accessLogField) string {
+	pairs := make([]string, 0, len(fields))
+	for _, field := range fields {
+		value := formatAccessLogValue(field.value)
+		if value == "" || strings.ContainsAny(value, " =\"\\") || strings.ContainsFunc(value, isControl) {
+			value = strconv.Quote(value)
+		}
+		pairs = append(pairs, field.name+"="+value)
+	}
+	return strings.Join(pairs, " ")
+}
+
+func isControl(r rune) bool {
+	return r < 0x20 || r == 0x7f
+}
+
+// returns the entry in the common log format: host ident authuser [date] "request" status bytes,
+// with "referer" "user-agent" appended in the combined format
+func commonLogLine(fields []accessLogField, combined bool) string {
+	values := make(map[string]string, len(fields))
+	for _, field := range fields {
+		value := field.value
+		if t, ok := value.(time.Time); ok {
+			value = t.Format(accessLogCommonTimeFormat)
+		}
+		values[field.name] = formatAccessLogValue(value)
+	}
+	dash := func(value string) string {
+		if value == "" {
+			return "-"
+		}
+		return value
+	}
+	request := values[AccessLogFieldPath]
+	if values[AccessLogFieldQuery] != "" {
+		request += "?" + values[AccessLogFieldQuery]
+	}
+	request = values[AccessLogFieldMethod] + " " + request + " " + values[AccessLogFieldProtocol]
+	bytes := values[AccessLogFieldBytes]
+	if bytes == "0" {
+		bytes = "-"
+	}
+	line := fmt.Sprintf("%s - - [%s] %s %s %s",
+		dash(values[AccessLogFieldClient]), values[AccessLogFieldTime], strconv.Quote(request), values[AccessLogFieldStatus], bytes)
+	if combined {
+		line += " " + strconv.Quote(dash(values[AccessLogFieldReferer])) + " " + strconv.Quote(dash(values[AccessLogFieldUserAgent]))
+	}
+	return line
+}
+
+// rotatingFile is a log file, that is renamed to <path>.1 once it exceeds maxBytes
+type rotatingFile struct {
+	path       string
+	maxBytes   int64
+	maxBackups int
+	mu         sync.Mutex
+	file       *os.File
+	size       int64
+}
+
+func newRotatingFile(path string, maxBytes int64, maxBackups int) (*rotatingFile, error) {
+	f := &rotatingFile{path: path, maxBytes: maxBytes, maxBackups: maxBackups}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *rotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file, f.size = file, info.Size()
+	return nil
+}
+
+func (f *rotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file == nil {
+		return 0, os.ErrClosed
+	}
+	if f.maxBytes > 0 && f.size > 0 && f.size+int64(len(p)) > f.maxBytes {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// renames the backups to the next number (dropping the oldest), and the file to <path>.1
+func (f *rotatingFile) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+	f.file = nil
+	last := f.maxBackups
+	if last == 0 {
+		for last = 1; ; last++ {
+			if _, err := os.Stat(f.backup(last)); err != nil {
+				break
+			}
+		}
+	}
+	os.Remove(f.backup(last))
+	for i := last - 1; i > 0; i-- {
+		os.Rename(f.backup(i), f.backup(i+1))
+	}
+	if err := os.Rename(f.path, f.backup(1)); err != nil {
+		return err
+	}
+	return f.open()
+}
+
+func (f *rotatingFile) backup(i int) string {
+	return f.path + "." + strconv.Itoa(i)
+}
+
+func (f *rotatingFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
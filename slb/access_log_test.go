@@ -0,0 +1,177 @@
+package slb
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+var accessLogTime = time.Date(2024, time.May, 1, 12, 30, 0, 0, time.UTC)
+
+// returns an access logger that writes to the returned buffer at accessLogTime
+func newTestAccessLogger(t *testing.T, cfg AccessLogConfig) (*accessLogger, *bytes.Buffer) {
+	cfg.Output = AccessLogStdout
+	a, err := newAccessLogger(cfg)
+	require.NoError(t, err)
+	out := &bytes.Buffer{}
+	a.out = out
+	a.now = func() time.Time { return accessLogTime }
+	return a, out
+}
+
+func newAccessLogRequest() *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "http://example.com/items?id=1", strings.NewReader("hello"))
+	r.RemoteAddr = "192.0.2.1:51234"
+	r.Header.Set(DefaultRequestIDHeader, "request-1")
+	r.Header.Set("Referer", "http://example.com/")
+	r.Header.Set("User-Agent", "test agent")
+	return r
+}
+
+func TestAccessLogConfigValidate(t *testing.T) {
+	require.NoError(t, AccessLogConfig{}.Validate())
+	require.NoError(t, AccessLogConfig{Output: AccessLogStdout, Format: AccessLogLogfmt, SampleRate: 0.5, Redact: []string{"query"}}.Validate())
+	require.Error(t, AccessLogConfig{Output: AccessLogStdout, Format: "xml"}.Validate())
+	require.Error(t, AccessLogConfig{Output: AccessLogStdout, SampleRate: 1.5}.Validate())
+	require.Error(t, AccessLogConfig{Output: AccessLogStdout, MaxFileBytes: -1}.Validate())
+	require.Error(t, AccessLogConfig{Output: AccessLogStdout, Redact: []string{"password"}}.Validate())
+}
+
+func TestAccessLogFormats(t *testing.T) {
+	type formatTest struct {
+		format   AccessLogFormat
+		expected string
+	}
+	scenarios := []formatTest{
+		{
+			format:   AccessLogCommon,
+			expected: `192.0.2.1 - - [01/May/2024:12:30:00 +0000] "GET /items?id=1 HTTP/1.1" 200 5` + "\n",
+		},
+		{
+			format: AccessLogCombined,
+			expected: `192.0.2.1 - - [01/May/2024:12:30:00 +0000] "GET /items?id=1 HTTP/1.1" 200 5 ` +
+				`"http://example.com/" "test agent"` + "\n",
+		},
+		{
+			format: AccessLogLogfmt,
+			expected: `time=2024-05-01T12:30:00.000Z client=192.0.2.1 method=GET path=/items query="id=1" protocol=HTTP/1.1 ` +
+				`host=example.com pool=default backend=%s status=200 bytes=5 upstreamLatency=REDACTED duration=0 attempts=1 ` +
+				`requestId=request-1 referer=http://example.com/ userAgent="test agent"` + "\n",
+		},
+	}
+	for _, scenario := range scenarios {
+		t.Run(string(scenario.format), func(t *testing.T) {
+			s, tracker := newRetrySlb(t, RetryPolicyConfig{}, echoBackend(t))
+			var out *bytes.Buffer
+			s.accessLog, out = newTestAccessLogger(t, AccessLogConfig{
+				Format: scenario.format,
+				Redact: []string{AccessLogFieldUpstreamLatency},
+			})
+			s.ServeHTTP(httptest.NewRecorder(), newAccessLogRequest())
+			expected := strings.Replace(scenario.expected, "%s", tracker.endpoints[0].Addr, 1)
+			require.Equal(t, expected, out.String())
+		})
+	}
+}
+
+func TestAccessLogJSON(t *testing.T) {
+	s, tracker := newRetrySlb(t, RetryPolicyConfig{MaxRetries: 1}, refusingBackend(), echoBackend(t))
+	var out *bytes.Buffer
+	s.accessLog, out = newTestAccessLogger(t, AccessLogConfig{})
+	s.ServeHTTP(httptest.NewRecorder(), newAccessLogRequest())
+
+	entry := map[string]any{}
+	require.NoError(t, json.Unmarshal(out.Bytes(), &entry))
+	require.Equal(t, "2024-05-01T12:30:00.000Z", entry[AccessLogFieldTime])
+	require.Equal(t, "192.0.2.1", entry[AccessLogFieldClient])
+	require.Equal(t, "/items", entry[AccessLogFieldPath])
+	require.Equal(t, DefaultPoolName, entry[AccessLogFieldPool])
+	require.Equal(t, tracker.endpoints[1].Addr, entry[AccessLogFieldBackend], "the backend of the last attempt is logged")
+	require.Equal(t, 200.0, entry[AccessLogFieldStatus])
+	require.Equal(t, 2.0, entry[AccessLogFieldAttempts])
+	require.Greater(t, entry[AccessLogFieldUpstreamLatency], 0.0)
+	require.Equal(t, "request-1", entry[AccessLogFieldRequestID])
+}
+
+func TestAccessLogRequestID(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.Write([]byte(r.Header.Get("X-Trace")))
+	}))
+	t.Cleanup(backend.Close)
+	s, _ := newRetrySlb(t, RetryPolicyConfig{}, backend)
+	var out *bytes.Buffer
+	s.accessLog, out = newTestAccessLogger(t, AccessLogConfig{RequestIDHeader: "X-Trace"})
+
+	rw := httptest.NewRecorder()
+	s.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "/", nil))
+	requestID := rw.Header().Get("X-Trace")
+	require.NotEmpty(t, requestID, "a request id is generated")
+	require.Equal(t, requestID, rw.Body.String(), "the request id is sent to the backend")
+	require.Contains(t, out.String(), `"requestId":"`+requestID+`"`)
+}
+
+func TestAccessLogSamplingAndRedaction(t *testing.T) {
+	s, _ := newRetrySlb(t, RetryPolicyConfig{}, echoBackend(t))
+	var out *bytes.Buffer
+	s.accessLog, out = newTestAccessLogger(t, AccessLogConfig{
+		Format:     AccessLogLogfmt,
+		SampleRate: 0.1,
+		Redact:     []string{AccessLogFieldClient, AccessLogFieldQuery},
+	})
+	s.accessLog.sample = func() bool { return false }
+	s.ServeHTTP(httptest.NewRecorder(), newAccessLogRequest())
+	require.Empty(t, out.String(), "requests that are not sampled are not logged")
+
+	s.selector.(*trackerMock).endpoints = nil
+	s.ServeHTTP(httptest.NewRecorder(), newAccessLogRequest())
+	require.Contains(t, out.String(), "status=503", "failed requests are always logged")
+	require.Contains(t, out.String(), "client=REDACTED")
+	require.Contains(t, out.String(), "query=REDACTED")
+	require.Contains(t, out.String(), `backend="" `)
+}
+
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	f, err := newRotatingFile(path, 10, 2)
+	require.NoError(t, err)
+	for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		_, err := f.Write([]byte(line))
+		require.NoError(t, err)
+	}
+	require.NoError(t, f.Close())
+
+	for file, expected := range map[string]string{path: "fourth\n", path + ".1": "third\n", path + ".2": "second\n"} {
+		content, err := os.ReadFile(file)
+		require.NoError(t, err)
+		require.Equal(t, expected, string(content))
+	}
+	require.NoFileExists(t, path+".3", "only max backups are kept")
+
+	_, err = f.Write([]byte("closed\n"))
+	require.ErrorIs(t, err, os.ErrClosed)
+}
+
+func TestNewWithAccessLogFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	cfg := Config{
+		Endpoints:     []*Endpoint{{Addr: "localhost"}},
+		ListenAddress: "localhost",
+		AccessLog:     AccessLogConfig{Output: path},
+	}
+	s, err := New(cfg, &SelectorMock{})
+	require.NoError(t, err)
+	require.FileExists(t, path)
+	require.NoError(t, s.Stop())
+
+	cfg.AccessLog.Output = filepath.Join(t.TempDir(), "missing", "access.log")
+	_, err = New(cfg, &SelectorMock{})
+	require.Error(t, err)
+}
@@ -0,0 +1,120 @@
+package slb
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidSessionAffinity = func(reason string) error {
+		return fmt.Errorf("invalid session affinity configuration: %s", reason)
+	}
+)
+
+// SessionAffinityConfig configures cookie based session affinity:
+// the first response of a session sets a signed cookie naming the selected endpoint,
+// and following requests with the cookie are sent to the same endpoint, as long as it is available.
+// Session affinity is disabled if no CookieName is provided.
+type SessionAffinityConfig struct {
+	// Name of the affinity cookie
+	CookieName string `json:"cookieName,omitempty"`
+	// Time after which the cookie expires, session cookies are used if it is 0
+	TTL time.Duration `json:"ttl,omitempty"`
+	// Key the cookie is signed with, so that clients cannot choose an endpoint
+	SigningKey string `json:"-"`
+}
+
+// Returns true if a cookie name is configured
+func (a SessionAffinityConfig) Enabled() bool {
+	return a.CookieName != ""
+}
+
+// Validates the session affinity configuration
+func (a SessionAffinityConfig) Validate() error {
+	if !a.Enabled() {
+		return nil
+	}
+	if a.SigningKey == "" {
+		return ErrInvalidSessionAffinity("signing key is required")
+	}
+	if a.TTL < 0 {
+		return ErrInvalidSessionAffinity("ttl must not be negative")
+	}
+	if err := (&http.Cookie{Name: a.CookieName}).Valid(); err != nil {
+		return ErrInvalidSessionAffinity(err.Error())
+	}
+	return nil
+}
+
+// sessionAffinity signs and verifies affinity cookies.
+// The cookie value is <endpoint id>.<expiry unix time>.<base64 hmac-sha256 signature>,
+// the endpoint id is a keyed hash of the endpoint identity, so that internal addresses are not exposed to clients.
+type sessionAffinity struct {
+	cfg SessionAffinityConfig
+	now func() time.Time
+}
+
+func newSessionAffinity(cfg SessionAffinityConfig) *sessionAffinity {
+	return &sessionAffinity{cfg: cfg, now: time.Now}
+}
+
+// Returns the opaque id of the endpoint with identity in affinity cookies
+func (a *sessionAffinity) ID(identity string) string {
+	mac := hmac.New(sha256.New, []byte(a.cfg.SigningKey))
+	mac.Write([]byte("endpoint:" + identity))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// Returns the affinity cookie of the response to r for the endpoint with identity,
+// it is only sent over tls if r was received over tls.
+func (a *sessionAffinity) Cookie(r *http.Request, identity string) *http.Cookie {
+	cookie := &http.Cookie{
+		Name:     a.cfg.CookieName,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	}
+	var expiry int64
+	if a.cfg.TTL > 0 {
+		expiry = a.now().Add(a.cfg.TTL).Unix()
+		cookie.MaxAge = int(a.cfg.TTL.Seconds())
+	}
+	payload := a.ID(identity) + "." + strconv.FormatInt(expiry, 10)
+	cookie.Value = payload + "." + a.sign(payload)
+	return cookie
+}
+
+// Returns the endpoint id of the affinity cookie of r,
+// if the cookie exists, is signed with the signing key and did not expire.
+func (a *sessionAffinity) Endpoint(r *http.Request) (string, bool) {
+	cookie, err := r.Cookie(a.cfg.CookieName)
+	if err != nil {
+		return "", false
+	}
+	parts := strings.Split(cookie.Value, ".")
+	if len(parts) != 3 {
+		return "", false
+	}
+	payload := parts[0] + "." + parts[1]
+	if !hmac.Equal([]byte(parts[2]), []byte(a.sign(payload))) {
+		return "", false
+	}
+	expiry, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || (expiry != 0 && a.now().Unix() > expiry) {
+		return "", false
+	}
+	return parts[0], true
+}
+
+func (a *sessionAffinity) sign(payload string) string {
+	mac := hmac.New(sha256.New, []byte(a.cfg.SigningKey))
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
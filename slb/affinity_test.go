@@ -0,0 +1,166 @@
+package slb
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSessionAffinityConfigValidate(t *testing.T) {
+	require.NoError(t, SessionAffinityConfig{}.Validate())
+	require.NoError(t, SessionAffinityConfig{CookieName: "balance", SigningKey: "key"}.Validate())
+	require.Error(t, SessionAffinityConfig{CookieName: "balance"}.Validate(), "signing key is required")
+	require.Error(t, SessionAffinityConfig{CookieName: "balance", SigningKey: "key", TTL: -time.Second}.Validate())
+	require.Error(t, SessionAffinityConfig{CookieName: "bad name", SigningKey: "key"}.Validate())
+}
+
+// returns a request carrying cookie
+func requestWithCookie(cookie *http.Cookie) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	if cookie != nil {
+		r.AddCookie(cookie)
+	}
+	return r
+}
+
+func TestSessionAffinityCookie(t *testing.T) {
+	affinity := newSessionAffinity(SessionAffinityConfig{CookieName: "balance", SigningKey: "key", TTL: time.Minute})
+	now := time.Now()
+	affinity.now = func() time.Time { return now }
+	addr := "http://10.0.0.1:80"
+
+	cookie := affinity.Cookie(requestWithCookie(nil), addr)
+	require.Equal(t, "balance", cookie.Name)
+	require.Equal(t, 60, cookie.MaxAge)
+	require.NotContains(t, cookie.Value, "10.0.0.1", "the address is not exposed")
+	endpoint, ok := affinity.Endpoint(requestWithCookie(cookie))
+	require.True(t, ok)
+	require.Equal(t, affinity.ID(addr), endpoint)
+	require.NotEqual(t, affinity.ID(addr), affinity.ID("http://10.0.0.2:80"))
+
+	_, ok = affinity.Endpoint(requestWithCookie(nil))
+	require.False(t, ok, "no cookie")
+
+	// another endpoint with the signature of the original cookie
+	tampered := *cookie
+	other := strings.Split(affinity.Cookie(requestWithCookie(nil), "http://10.0.0.2:80").Value, ".")
+	tampered.Value = other[0] + "." + other[1] + "." + strings.Split(cookie.Value, ".")[2]
+	_, ok = affinity.Endpoint(requestWithCookie(&tampered))
+	require.False(t, ok, "tampered cookie")
+
+	otherKey := newSessionAffinity(SessionAffinityConfig{CookieName: "balance", SigningKey: "other"})
+	_, ok = otherKey.Endpoint(requestWithCookie(cookie))
+	require.False(t, ok, "cookie signed with another key")
+
+	now = now.Add(time.Minute + time.Second)
+	_, ok = affinity.Endpoint(requestWithCookie(cookie))
+	require.False(t, ok, "expired cookie")
+
+	// without ttl the cookie is a session cookie, that does not expire
+	session := newSessionAffinity(SessionAffinityConfig{CookieName: "balance", SigningKey: "key"})
+	cookie = session.Cookie(requestWithCookie(nil), addr)
+	require.Zero(t, cookie.MaxAge)
+	session.now = func() time.Time { return now.Add(time.Hour * 24 * 365) }
+	_, ok = session.Endpoint(requestWithCookie(cookie))
+	require.True(t, ok)
+}
+
+func TestSelectSessionEndpoint(t *testing.T) {
+	selector := &sequenceSelector{endpoints: []*Endpoint{{Addr: "http://10.0.0.1:80"}, {Addr: "http://10.0.0.2:80"}}}
+	s := &Slb{selector: selector, cfg: Config{SessionAffinity: SessionAffinityConfig{CookieName: "balance", SigningKey: "key"}}}
+	s.health = newHealthChecker(HealthCheckConfig{}, "", selector.EndPoints)
+	s.outliers = newOutlierDetector(OutlierDetectionConfig{ConsecutiveFailures: 1}, onePool(selector.EndPoints))
+	s.affinity = newSessionAffinity(s.cfg.SessionAffinity)
+	s.discovery = newDiscovery("", discoveryHandler{})
+
+	// the first request sets the cookie
+	rw := httptest.NewRecorder()
+	first, err := s.selectSessionEndpoint(rw, requestWithCookie(nil), s.selector)
+	require.NoError(t, err)
+	cookies := rw.Result().Cookies()
+	require.Len(t, cookies, 1)
+
+	// following requests stick to the same endpoint without setting the cookie again
+	for i := 0; i < 3; i++ {
+		rw = httptest.NewRecorder()
+		server, err := s.selectSessionEndpoint(rw, requestWithCookie(cookies[0]), s.selector)
+		require.NoError(t, err)
+		require.Same(t, first, server)
+		require.Empty(t, rw.Result().Cookies())
+	}
+
+	// an unavailable endpoint falls back to the selector, and moves the session
+	s.outliers.Failure(first.Addr)
+	rw = httptest.NewRecorder()
+	server, err := s.selectSessionEndpoint(rw, requestWithCookie(cookies[0]), s.selector)
+	require.NoError(t, err)
+	require.NotSame(t, first, server)
+	moved := rw.Result().Cookies()
+	require.Len(t, moved, 1)
+	id, ok := s.affinity.Endpoint(requestWithCookie(moved[0]))
+	require.True(t, ok)
+	require.Equal(t, s.affinity.ID(server.Addr), id)
+
+	// a removed endpoint falls back to the selector
+	selector.endpoints = selector.endpoints[1:]
+	s.outliers.Forget(first.Addr)
+	server, err = s.selectSessionEndpoint(httptest.NewRecorder(), requestWithCookie(cookies[0]), s.selector)
+	require.NoError(t, err)
+	require.Same(t, selector.endpoints[0], server)
+
+	// the signing key is not exposed
+	require.Empty(t, s.Configuration().SessionAffinity.SigningKey)
+}
+
+func TestSelectSessionEndpointRefreshesCookie(t *testing.T) {
+	selector := &sequenceSelector{endpoints: []*Endpoint{{Addr: "http://10.0.0.1:80"}, {Addr: "http://10.0.0.2:80"}}}
+	s := &Slb{selector: selector, cfg: Config{SessionAffinity: SessionAffinityConfig{CookieName: "balance", SigningKey: "key", TTL: time.Minute}}}
+	s.health = newHealthChecker(HealthCheckConfig{}, "", selector.EndPoints)
+	s.outliers = newOutlierDetector(OutlierDetectionConfig{}, onePool(selector.EndPoints))
+	s.affinity = newSessionAffinity(s.cfg.SessionAffinity)
+	s.discovery = newDiscovery("", discoveryHandler{})
+	now := time.Now()
+	s.affinity.now = func() time.Time { return now }
+
+	rw := httptest.NewRecorder()
+	first, err := s.selectSessionEndpoint(rw, requestWithCookie(nil), s.selector)
+	require.NoError(t, err)
+	cookie := rw.Result().Cookies()[0]
+
+	// a sticky request re-issues the cookie with a later expiry
+	now = now.Add(time.Second * 30)
+	rw = httptest.NewRecorder()
+	server, err := s.selectSessionEndpoint(rw, requestWithCookie(cookie), s.selector)
+	require.NoError(t, err)
+	require.Same(t, first, server)
+	refreshed := rw.Result().Cookies()
+	require.Len(t, refreshed, 1)
+	require.NotEqual(t, cookie.Value, refreshed[0].Value)
+
+	now = now.Add(time.Second * 45)
+	_, ok := s.affinity.Endpoint(requestWithCookie(cookie))
+	require.False(t, ok, "the original cookie expired")
+	_, ok = s.affinity.Endpoint(requestWithCookie(refreshed[0]))
+	require.True(t, ok)
+}
+
+func TestAffinityCookieIsSecureOverTLS(t *testing.T) {
+	selector := &sequenceSelector{endpoints: []*Endpoint{{Addr: "http://10.0.0.1:80"}}}
+	s := &Slb{selector: selector, cfg: Config{SessionAffinity: SessionAffinityConfig{CookieName: "balance", SigningKey: "key"}}}
+	s.health = newHealthChecker(HealthCheckConfig{}, "", selector.EndPoints)
+	s.outliers = newOutlierDetector(OutlierDetectionConfig{}, onePool(selector.EndPoints))
+	s.affinity = newSessionAffinity(s.cfg.SessionAffinity)
+
+	for target, secure := range map[string]bool{"https://example.com/": true, "http://example.com/": false} {
+		rw := httptest.NewRecorder()
+		_, err := s.selectSessionEndpoint(rw, httptest.NewRequest(http.MethodGet, target, nil), s.selector)
+		require.NoError(t, err)
+		cookies := rw.Result().Cookies()
+		require.Len(t, cookies, 1)
+		require.Equal(t, secure, cookies[0].Secure, target)
+	}
+}
@@ -3,8 +3,10 @@ package slb
 import (
 	"fmt"
 	"net"
-	"net/http"
 	"net/url"
+	"strconv"
+	"strings"
+	"time"
 )
 
 const (
@@ -18,17 +20,61 @@ var (
 	// Config errros
 	ErrConfigNoEnpoints       = func() error { return fmt.Errorf("no endpoints provided") }
 	ErrFailedToParseServerUrl = func(err error) error { return fmt.Errorf("failed to parse server url: %s", err) }
+	ErrInvalidWeight          = func(addr string, weight int) error { return fmt.Errorf("invalid weight %d for %s", weight, addr) }
+	ErrInvalidUpstreamTimeout = func() error { return fmt.Errorf("upstream timeout must not be negative") }
+	ErrInvalidEndpointScheme  = func(addr string) error { return fmt.Errorf("endpoint %s must use the http or https scheme", addr) }
+	ErrInvalidEndpoint        = func(addr string, err error) error { return fmt.Errorf("invalid endpoint %q: %s", addr, err) }
 )
 
 type Config struct {
-	// Load balancer backend endpoints to use
-	Endpoints []*http.Server `json:"endpoints"`
+	// Load balancer backend endpoints to use, addresses without a port use the ListenPort.
+	// Configuration sets their weights, health state, labels and statistics.
+	Endpoints []*Endpoint `json:"endpoints"`
 	// Network port that the frontend server listens on
 	ListenPort string `json:"listenPort,omitempty"`
 	// Frontend address (without port)
 	ListenAddress string `json:"listenAddress,omitempty"`
 	// The address postfix for which the slb forwards requests
 	HandlePostfix string `json:"handlePostfix,omitempty"`
+	// Active health checks of the backend endpoints
+	HealthCheck HealthCheckConfig `json:"healthCheck,omitempty"`
+	// Passive outlier detection based on the proxied responses
+	OutlierDetection OutlierDetectionConfig `json:"outlierDetection,omitempty"`
+	// The request key used by selectors that implement RequestSelector (e.g consistent hashing)
+	HashKey HashKeyConfig `json:"hashKey,omitempty"`
+	// Cookie based session affinity
+	SessionAffinity SessionAffinityConfig `json:"sessionAffinity,omitempty"`
+	// Retries of failed requests on other endpoints
+	RetryPolicy RetryPolicyConfig `json:"retryPolicy,omitempty"`
+	// Time after which a proxied request is canceled, there is no timeout if it is 0
+	UpstreamTimeout time.Duration `json:"upstreamTimeout,omitempty"`
+	// Responses to clients whose requests could not be proxied, by the kind of error
+	ErrorResponses map[ErrorKind]ErrorResponseConfig `json:"errorResponses,omitempty"`
+	// Admin server of the prometheus metrics
+	Metrics MetricsConfig `json:"metrics,omitempty"`
+	// Access log of the proxied requests
+	AccessLog AccessLogConfig `json:"accessLog,omitempty"`
+	// OpenTelemetry tracing of the proxied requests
+	Tracing TracingConfig `json:"tracing,omitempty"`
+	// TLS termination on the frontend listener
+	TLS TLSConfig `json:"tls,omitempty"`
+	// TLS of the connections to https endpoints
+	UpstreamTLS UpstreamTLSConfig `json:"upstreamTls,omitempty"`
+	// TLS of the connections to https endpoints by identity (the address of endpoints without id), instead of the UpstreamTLS
+	EndpointTLS map[string]UpstreamTLSConfig `json:"endpointTls,omitempty"`
+	// Slow start of the endpoints that are added to the running SLB
+	SlowStart SlowStartConfig `json:"slowStart,omitempty"`
+	// Discovery of endpoints, that are added to the Endpoints
+	Discovery DiscoveryConfig `json:"discovery,omitempty"`
+	// Additional pools of endpoints, that routes send requests to
+	Pools []PoolConfig `json:"pools,omitempty"`
+	// Routes to pools, the first matching route is used.
+	// Requests that match no route are sent to the Endpoints (the default pool).
+	Routes []RouteConfig `json:"routes,omitempty"`
+	// Discovery state of the pools by name (set by Slb.Configuration)
+	DiscoveryStatus map[string]DiscoveryStatus `json:"discoveryStatus,omitempty"`
+	// Progress of the drained endpoints by identity (set by Slb.Configuration)
+	Draining map[string]DrainStatus `json:"draining,omitempty"`
 }
 
 // Returns the full address with port.
@@ -53,17 +99,99 @@ func (c *Config) Postfix() string {
 
 // Validates the configuration
 func (c *Config) Validate() error {
-	if !c.hasEndpoints() {
+	if !c.hasEndpoints() && !c.Discovery.Enabled() {
 		return ErrConfigNoEnpoints()
 	}
 	for _, server := range c.Endpoints {
-		if _, err := resolveAddress(server.Addr, c.ListenPort); err != nil {
+		if err := validateEndpoint(server, c.ListenPort); err != nil {
 			return err
 		}
 	}
 	if _, err := resolveAddress(c.ListenAddress, c.ListenPort); err != nil {
 		return err
 	}
+	if err := c.validateRouting(); err != nil {
+		return err
+	}
+	if err := c.HashKey.Validate(); err != nil {
+		return err
+	}
+	if err := c.SessionAffinity.Validate(); err != nil {
+		return err
+	}
+	if err := c.RetryPolicy.Validate(); err != nil {
+		return err
+	}
+	if err := c.Metrics.Validate(); err != nil {
+		return err
+	}
+	if err := c.AccessLog.Validate(); err != nil {
+		return err
+	}
+	if err := c.Tracing.Validate(); err != nil {
+		return err
+	}
+	if err := c.TLS.Validate(); err != nil {
+		return err
+	}
+	if err := c.Discovery.Validate(); err != nil {
+		return err
+	}
+	if err := c.UpstreamTLS.Validate(); err != nil {
+		return err
+	}
+	for _, endpointTLS := range c.EndpointTLS {
+		if err := endpointTLS.Validate(); err != nil {
+			return err
+		}
+	}
+	if c.UpstreamTimeout < 0 {
+		return ErrInvalidUpstreamTimeout()
+	}
+	for kind, response := range c.ErrorResponses {
+		if err := response.Validate(kind); err != nil {
+			return err
+		}
+	}
+	if err := c.HealthCheck.Validate(); err != nil {
+		return err
+	}
+	if err := c.SlowStart.Validate(); err != nil {
+		return err
+	}
+	return c.OutlierDetection.Validate()
+}
+
+// validates that pool and route names are unique, and that routes use existing pools
+func (c *Config) validateRouting() error {
+	pools := map[string]bool{DefaultPoolName: true}
+	for _, pool := range c.Pools {
+		if err := pool.Validate(); err != nil {
+			return err
+		}
+		if pools[pool.Name] {
+			return ErrInvalidPool(pool.Name, "name is not unique")
+		}
+		pools[pool.Name] = true
+		for _, server := range pool.Endpoints {
+			if err := validateEndpoint(server, c.ListenPort); err != nil {
+				return ErrInvalidPool(pool.Name, err.Error())
+			}
+		}
+	}
+	routes := map[string]bool{}
+	for _, route := range c.Routes {
+		if err := route.Validate(); err != nil {
+			return err
+		}
+		if routes[route.Name] {
+			return ErrInvalidRoute(route.Name, "name is not unique")
+		}
+		routes[route.Name] = true
+		if !pools[route.Pool] {
+			return ErrPoolNotFound(route.Pool)
+		}
+	}
 	return nil
 }
 
@@ -71,14 +199,20 @@ func (c *Config) hasEndpoints() bool {
 	return 0 < len(c.Endpoints)
 }
 
+// resolveAddress resolves a host, or a host:port, to an http url.
+// The listenPort is used if addr has no port.
 func resolveAddress(addr string, listenPort string) (*url.URL, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		host, port = addr, resolvePort(listenPort)
+	}
 	// Resolve the TCP address
-	tcpAddr, err := net.ResolveTCPAddr("tcp", addr+":"+resolvePort(listenPort))
+	tcpAddr, err := net.ResolveTCPAddr("tcp", net.JoinHostPort(host, port))
 	if err != nil {
 		return nil, ErrFailedToParseServerUrl(fmt.Errorf("failed to resolve address: %s", err))
 	}
 
-	parsedURL, err := url.Parse(DefaultScheme + tcpAddr.IP.String() + ":" + resolvePort(listenPort))
+	parsedURL, err := url.Parse(DefaultScheme + net.JoinHostPort(tcpAddr.IP.String(), strconv.Itoa(tcpAddr.Port)))
 	if err != nil {
 		return nil, ErrFailedToParseServerUrl(err)
 	}
@@ -86,6 +220,31 @@ func resolveAddress(addr string, listenPort string) (*url.URL, error) {
 	return parsedURL, nil
 }
 
+// endpointURL returns the url of an endpoint address, which is either
+// a http(s) url with an optional port and base path (e.g "https://backend:8443/api", as set by New),
+// or a host with an optional port that is resolved (e.g "10.0.0.5:9000"), the listenPort is used if it has no port
+func endpointURL(addr string, listenPort string) (*url.URL, error) {
+	if strings.Contains(addr, "://") {
+		parsed, err := url.Parse(addr)
+		if err != nil {
+			return nil, ErrFailedToParseServerUrl(err)
+		}
+		if parsed.Scheme != "http" && parsed.Scheme != "https" {
+			return nil, ErrInvalidEndpointScheme(addr)
+		}
+		if parsed.Hostname() == "" {
+			return nil, ErrFailedToParseServerUrl(fmt.Errorf("missing host"))
+		}
+		if port := parsed.Port(); port != "" {
+			if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+				return nil, ErrFailedToParseServerUrl(fmt.Errorf("invalid port %s", port))
+			}
+		}
+		return parsed, nil
+	}
+	return resolveAddress(addr, listenPort)
+}
+
 func resolvePort(listenPort string) string {
 	if listenPort == "" {
 		listenPort = DefaultListenPort
@@ -0,0 +1,81 @@
+package slb
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEndpointURL(t *testing.T) {
+	type endpointURLTest struct {
+		addr     string
+		expected string
+		invalid  bool
+	}
+	scenarios := []endpointURLTest{
+		{addr: "127.0.0.1", expected: "http://127.0.0.1:8080"},
+		{addr: "127.0.0.1:9000", expected: "http://127.0.0.1:9000"},
+		{addr: "[::1]:9000", expected: "http://[::1]:9000"},
+		{addr: "http://backend:9000", expected: "http://backend:9000"},
+		{addr: "https://backend", expected: "https://backend"},
+		{addr: "https://backend:8443/api", expected: "https://backend:8443/api"},
+		{addr: "127.0.0.1:-1", invalid: true},
+		{addr: "127.0.0.1:70000", invalid: true},
+		{addr: "ftp://backend:21", invalid: true},
+		{addr: "https://:8443", invalid: true},
+		{addr: "https://backend:port", invalid: true},
+	}
+	for _, scenario := range scenarios {
+		t.Run(scenario.addr, func(t *testing.T) {
+			url, err := endpointURL(scenario.addr, "8080")
+			if scenario.invalid {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, scenario.expected, url.String())
+		})
+	}
+}
+
+func TestConfigValidateReportsInvalidEndpoint(t *testing.T) {
+	cfg := Config{Endpoints: []*Endpoint{{Addr: "127.0.0.1:9000"}, {Addr: "127.0.0.1:70000"}}}
+	err := cfg.Validate()
+	require.Error(t, err)
+	require.Contains(t, err.Error(), `"127.0.0.1:70000"`)
+
+	cfg = Config{
+		Endpoints: []*Endpoint{{Addr: "127.0.0.1:9000"}},
+		Pools:     []PoolConfig{{Name: "api", Selector: &SelectorMock{}, Endpoints: []*Endpoint{{Addr: "ftp://backend"}}}},
+	}
+	err = cfg.Validate()
+	require.Error(t, err)
+	require.Contains(t, err.Error(), `"api"`)
+	require.Contains(t, err.Error(), `"ftp://backend"`)
+}
+
+func TestProxyUsesEndpointPortAndBasePath(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.Write([]byte(r.URL.Path))
+	}))
+	t.Cleanup(backend.Close)
+	for addr, expected := range map[string]string{
+		backend.Listener.Addr().String(): "/items",
+		backend.URL + "/api":             "/api/items",
+	} {
+		s, tracker := newRetrySlb(t, RetryPolicyConfig{})
+		s.cfg.ListenPort = "1"
+		server := &Endpoint{Addr: addr}
+		require.NoError(t, s.setServerProxy(server))
+		require.True(t, strings.HasPrefix(server.Addr, "http://127.0.0.1:"))
+		tracker.endpoints = append(tracker.endpoints, server)
+
+		rw := httptest.NewRecorder()
+		s.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "/items", nil))
+		require.Equal(t, http.StatusOK, rw.Code, addr)
+		require.Equal(t, expected, rw.Body.String(), addr)
+	}
+}
@@ -0,0 +1,397 @@
+package slb
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+)
+
+const DefaultDiscoveryTimeout = time.Second * 5
+
+var (
+	ErrInvalidDiscovery = func(reason string) error { return fmt.Errorf("invalid discovery configuration: %s", reason) }
+	ErrDiscoveryFailed  = func(pool string, err error) error {
+		return fmt.Errorf("failed to discover the endpoints of pool %q: %s", pool, err)
+	}
+)
+
+// DiscoveryConfig configures the discovery of endpoints of a pool, that are added to its configured endpoints.
+// Discovery is disabled if no source is configured, at most one source can be configured.
+type DiscoveryConfig struct {
+	// Discovery of the endpoints from DNS records
+	DNS DNSDiscoveryConfig `json:"dns,omitempty"`
+	// Discovery of the endpoints from a JSON or YAML file
+	File FileDiscoveryConfig `json:"file,omitempty"`
+	// Discovery of the endpoints from the labeled containers of a docker daemon
+	Docker DockerDiscoveryConfig `json:"docker,omitempty"`
+}
+
+// Returns true if a discovery source is configured
+func (d DiscoveryConfig) Enabled() bool {
+	return d.DNS.Enabled() || d.File.Enabled() || d.Docker.Enabled()
+}
+
+// Validates the discovery configuration
+func (d DiscoveryConfig) Validate() error {
+	sources := 0
+	for _, enabled := range []bool{d.DNS.Enabled(), d.File.Enabled(), d.Docker.Enabled()} {
+		if enabled {
+			sources++
+		}
+	}
+	if sources > 1 {
+		return ErrInvalidDiscovery("only one source can be configured")
+	}
+	if err := d.DNS.Validate(); err != nil {
+		return err
+	}
+	if err := d.File.Validate(); err != nil {
+		return err
+	}
+	return d.Docker.Validate()
+}
+
+// DiscoveryStatus is the state of the discovery of a pool
+type DiscoveryStatus struct {
+	// Error of the last discovery, empty if it succeeded
+	LastError string `json:"lastError,omitempty"`
+	// Time of the last successful discovery
+	LastRefresh time.Time `json:"lastRefresh,omitempty"`
+}
+
+// discoveredEndpoint is an endpoint of a discovery source
+type discoveredEndpoint struct {
+	Address string `json:"address" yaml:"address"`
+	// Weight for selectors that implement Weighter, it is not set if 0
+	Weight   int               `json:"weight,omitempty" yaml:"weight,omitempty"`
+	Metadata map[string]string `json:"metadata,omitempty" yaml:"metadata,omitempty"`
+}
+
+// discoverer discovers the endpoints of a source.
+// Discoverers that implement io.Closer (e.g with the connections of a docker client) are closed once they are stopped.
+type discoverer interface {
+	// returns the discovered endpoints, and the time after which they are discovered again
+	discover(ctx context.Context) ([]discoveredEndpoint, time.Duration, error)
+}
+
+// watcher is implemented by discoverers whose source notifies about changes,
+// the endpoints are discovered again on every notification instead of waiting for the next discovery
+type watcher interface {
+	// returns the notifications of changes until ctx is done
+	watch(ctx context.Context) <-chan struct{}
+}
+
+func newDiscoverer(pool string, cfg DiscoveryConfig, listenPort string) discoverer {
+	switch {
+	case cfg.File.Enabled():
+		return newFileDiscoverer(cfg.File, listenPort)
+	case cfg.Docker.Enabled():
+		return newDockerDiscoverer(pool, cfg.Docker, listenPort)
+	}
+	return newDNSDiscoverer(cfg.DNS, listenPort)
+}
+
+// poolDiscovery updates the selector of a pool with the discovered endpoints.
+// Only the discovered endpoints are removed, and discovered endpoints that are configured already are skipped,
+// so that the configured endpoints are neither replaced nor removed by the discovery.
+type poolDiscovery struct {
+	cfg      DiscoveryConfig
+	source   discoverer
+	selector Selector
+	handler  discoveryHandler
+	// time until the next discovery
+	next time.Duration
+
+	// discovered endpoints by discovered address, they are only changed by refresh (guarded by mu)
+	mu        sync.RWMutex
+	endpoints map[string]*discoveredServer
+	status    DiscoveryStatus
+	stop      chan struct{}
+	done      chan struct{}
+}
+
+// discoveredServer is a discovered endpoint that was added to the selector
+type discoveredServer struct {
+	discoveredEndpoint
+	server *Endpoint
+}
+
+// discoveryHandler adds, removes and weights the discovered endpoints of a selector.
+// Endpoints that are discovered after the first discovery are slow started (if slowStart is set).
+type discoveryHandler struct {
+	add, remove func(Selector, *Endpoint) error
+	// resolves the address of a discovered endpoint, to compare it with the endpoints of the selector
+	resolve   func(*Endpoint) error
+	setWeight func(Selector, *Endpoint, int) error
+	slowStart func(*Endpoint)
+}
+
+// discovers the endpoints and updates the selector with the difference to the previous discovery.
+// The endpoints are kept if the discovery fails.
+func (p *poolDiscovery) refresh() error {
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultDiscoveryTimeout)
+	defer cancel()
+	discovered, next, err := p.source.discover(ctx)
+	p.next = next
+	if err != nil {
+		p.setStatus(DiscoveryStatus{LastError: err.Error(), LastRefresh: p.Status().LastRefresh})
+		return err
+	}
+	current := make(map[string]bool, len(discovered))
+	for _, endpoint := range discovered {
+		current[endpoint.Address] = true
+		known, ok := p.endpoints[endpoint.Address]
+		if !ok {
+			known = &discoveredServer{server: &Endpoint{Addr: endpoint.Address}}
+			if p.configured(known.server) {
+				continue
+			}
+			if err := p.handler.add(p.selector, known.server); err != nil {
+				slog.Error(fmt.Sprintf("could not add discovered endpoint %s: %s", endpoint.Address, err))
+				continue
+			}
+			if p.handler.slowStart != nil && !p.Status().LastRefresh.IsZero() {
+				p.handler.slowStart(known.server)
+			}
+			p.mu.Lock()
+			p.endpoints[endpoint.Address] = known
+			p.mu.Unlock()
+		}
+		// an endpoint whose weight is no longer set gets the default weight
+		if weight := max(endpoint.Weight, 1); endpoint.Weight != known.Weight && weight != max(known.Weight, 1) {
+			if err := p.handler.setWeight(p.selector, known.server, weight); err != nil {
+				slog.Error(fmt.Sprintf("could not set the weight of discovered endpoint %s: %s", endpoint.Address, err))
+			}
+		}
+		p.mu.Lock()
+		known.discoveredEndpoint = endpoint
+		p.mu.Unlock()
+	}
+	for addr, known := range p.endpoints {
+		if current[addr] {
+			continue
+		}
+		if err := p.handler.remove(p.selector, known.server); err != nil {
+			slog.Error(fmt.Sprintf("could not remove endpoint %s: %s", addr, err))
+		}
+		p.mu.Lock()
+		delete(p.endpoints, addr)
+		p.mu.Unlock()
+	}
+	p.setStatus(DiscoveryStatus{LastRefresh: time.Now()})
+	return nil
+}
+
+// returns true if the selector has an endpoint with the identity of server, that was not discovered (e.g it was configured)
+func (p *poolDiscovery) configured(server *Endpoint) bool {
+	if p.handler.resolve != nil && p.handler.resolve(server) != nil {
+		return false
+	}
+	endpoints, err := p.selector.EndPoints()
+	if err != nil {
+		return false
+	}
+	return slices.ContainsFunc(endpoints, server.Is)
+}
+
+// closes the source, if it implements io.Closer
+func (p *poolDiscovery) close() {
+	if closer, ok := p.source.(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			slog.Error(fmt.Sprintf("could not close the discovery: %s", err))
+		}
+	}
+}
+
+func (p *poolDiscovery) setStatus(status DiscoveryStatus) {
+	defer p.mu.Unlock()
+	p.mu.Lock()
+	p.status = status
+}
+
+// Returns the state of the discovery
+func (p *poolDiscovery) Status() DiscoveryStatus {
+	defer p.mu.RUnlock()
+	p.mu.RLock()
+	return p.status
+}
+
+// returns the metadata of the discovered endpoints by their identity in the selector
+func (p *poolDiscovery) metadata() map[string]map[string]string {
+	defer p.mu.RUnlock()
+	p.mu.RLock()
+	metadata := make(map[string]map[string]string)
+	for _, known := range p.endpoints {
+		if len(known.Metadata) > 0 {
+			metadata[known.server.Identity()] = maps.Clone(known.Metadata)
+		}
+	}
+	return metadata
+}
+
+func (p *poolDiscovery) run(pool string, stop <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+	var changes <-chan struct{}
+	if w, ok := p.source.(watcher); ok {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		changes = w.watch(ctx)
+	}
+	for {
+		timer := time.NewTimer(p.next)
+		select {
+		case <-stop:
+			timer.Stop()
+			return
+		case <-timer.C:
+		case <-changes:
+			timer.Stop()
+		}
+		lastError := p.Status().LastError
+		// the same error is only logged once, e.g while an invalid file is not fixed
+		if err := p.refresh(); err != nil && err.Error() != lastError {
+			slog.Error(ErrDiscoveryFailed(pool, err).Error())
+		}
+	}
+}
+
+// discovery keeps the discovered endpoints of the pools up to date while it is started
+type discovery struct {
+	listenPort string
+	handler    discoveryHandler
+
+	mu      sync.Mutex
+	pools   map[string]*poolDiscovery
+	started bool
+}
+
+// returns a discovery that updates the selectors of the pools with handler
+func newDiscovery(listenPort string, handler discoveryHandler) *discovery {
+	return &discovery{
+		listenPort: listenPort,
+		handler:    handler,
+		pools:      make(map[string]*poolDiscovery),
+	}
+}
+
+// Sets the discovery of pool, and discovers its endpoints once.
+// The previous discovery of the pool is stopped, and nothing is discovered if cfg is disabled.
+func (d *discovery) Set(pool string, cfg DiscoveryConfig, selector Selector) error {
+	d.Delete(pool)
+	if !cfg.Enabled() {
+		return nil
+	}
+	p := &poolDiscovery{
+		cfg:       cfg,
+		source:    newDiscoverer(pool, cfg, d.listenPort),
+		selector:  selector,
+		handler:   d.handler,
+		endpoints: make(map[string]*discoveredServer),
+	}
+	if err := p.refresh(); err != nil {
+		p.close()
+		return ErrDiscoveryFailed(pool, err)
+	}
+	defer d.mu.Unlock()
+	d.mu.Lock()
+	d.pools[pool] = p
+	if d.started {
+		p.stop, p.done = make(chan struct{}), make(chan struct{})
+		go p.run(pool, p.stop, p.done)
+	}
+	return nil
+}
+
+// Stops the discovery of pool, its discovered endpoints are kept
+func (d *discovery) Delete(pool string) {
+	d.mu.Lock()
+	p, ok := d.pools[pool]
+	var stop chan struct{}
+	var done chan struct{}
+	if ok {
+		stop, done = p.stop, p.done
+		delete(d.pools, pool)
+	}
+	d.mu.Unlock()
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+	if ok {
+		p.close()
+	}
+}
+
+// Returns the discovery configuration of pool
+func (d *discovery) Config(pool string) DiscoveryConfig {
+	defer d.mu.Unlock()
+	d.mu.Lock()
+	if p, ok := d.pools[pool]; ok {
+		return p.cfg
+	}
+	return DiscoveryConfig{}
+}
+
+// Returns the state of the discoveries by pool
+func (d *discovery) Status() map[string]DiscoveryStatus {
+	defer d.mu.Unlock()
+	d.mu.Lock()
+	status := make(map[string]DiscoveryStatus, len(d.pools))
+	for pool, p := range d.pools {
+		status[pool] = p.Status()
+	}
+	return status
+}
+
+// Returns the metadata of the discovered endpoints of all pools by identity
+func (d *discovery) Metadata() map[string]map[string]string {
+	defer d.mu.Unlock()
+	d.mu.Lock()
+	metadata := make(map[string]map[string]string)
+	for _, p := range d.pools {
+		maps.Copy(metadata, p.metadata())
+	}
+	return metadata
+}
+
+// Starts discovering the endpoints of the pools in the background
+func (d *discovery) Start() {
+	defer d.mu.Unlock()
+	d.mu.Lock()
+	if d.started {
+		return
+	}
+	d.started = true
+	for pool, p := range d.pools {
+		p.stop, p.done = make(chan struct{}), make(chan struct{})
+		go p.run(pool, p.stop, p.done)
+	}
+}
+
+// Stops discovering and waits for running discoveries to finish, the connections of their sources are closed
+func (d *discovery) Stop() {
+	d.mu.Lock()
+	d.started = false
+	running := make([]chan struct{}, 0, len(d.pools))
+	pools := make([]*poolDiscovery, 0, len(d.pools))
+	for _, p := range d.pools {
+		if p.stop != nil {
+			close(p.stop)
+			running = append(running, p.done)
+			p.stop, p.done = nil, nil
+		}
+		pools = append(pools, p)
+	}
+	d.mu.Unlock()
+	for _, done := range running {
+		<-done
+	}
+	for _, p := range pools {
+		p.close()
+	}
+}
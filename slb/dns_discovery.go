@@ -0,0 +1,266 @@
+package slb
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// DNSRecordType is the type of the DNS records the endpoints are discovered from
+type DNSRecordType string
+
+const (
+	// A and AAAA records, the endpoints use the configured port
+	DNSRecordA DNSRecordType = "A"
+	// SRV records, the endpoints use the targets and ports of the records
+	DNSRecordSRV DNSRecordType = "SRV"
+
+	DefaultDNSRefreshInterval = time.Second * 30
+	// Minimum time between two resolutions, even if the records have a shorter ttl
+	MinDNSRefreshInterval = time.Second
+)
+
+var (
+	ErrInvalidDNSDiscovery = func(reason string) error { return fmt.Errorf("invalid dns discovery configuration: %s", reason) }
+	ErrDNSLookupFailed     = func(name string, err error) error { return fmt.Errorf("failed to resolve %s: %s", name, err) }
+)
+
+// DNSDiscoveryConfig discovers the endpoints of a pool from the DNS records of a name.
+// The records are resolved again when their ttl expires, endpoints are added and removed as the records change.
+// DNS discovery is disabled if no Name is provided.
+type DNSDiscoveryConfig struct {
+	// Name that is resolved (e.g "backend.internal", or "_http._tcp.backend.internal" for SRV records)
+	Name string `json:"name,omitempty"`
+	// Type of the records (default "A")
+	RecordType DNSRecordType `json:"recordType,omitempty"`
+	// Port of the endpoints of A records (default the ListenPort)
+	Port string `json:"port,omitempty"`
+	// Scheme of the endpoints: "http" (default) or "https"
+	Scheme string `json:"scheme,omitempty"`
+	// Address of the nameserver that is queried (e.g "10.0.0.2:53").
+	// The system resolver is used if it is empty, it does not report the ttls of the records.
+	Nameserver string `json:"nameserver,omitempty"`
+	// Time between two resolutions if the records have no ttl, and after failed resolutions (default 30s)
+	RefreshInterval time.Duration `json:"refreshInterval,omitempty"`
+}
+
+// Returns true if a name is configured
+func (d DNSDiscoveryConfig) Enabled() bool {
+	return d.Name != ""
+}
+
+// Validates the dns discovery configuration
+func (d DNSDiscoveryConfig) Validate() error {
+	if !d.Enabled() {
+		return nil
+	}
+	if d.RecordType != "" && d.RecordType != DNSRecordA && d.RecordType != DNSRecordSRV {
+		return ErrInvalidDNSDiscovery("unknown record type " + string(d.RecordType))
+	}
+	if d.Scheme != "" && d.Scheme != "http" && d.Scheme != "https" {
+		return ErrInvalidDNSDiscovery("scheme must be http or https")
+	}
+	if _, err := strconv.ParseUint(d.Port, 10, 16); d.Port != "" && err != nil {
+		return ErrInvalidDNSDiscovery("invalid port " + d.Port)
+	}
+	if _, _, err := net.SplitHostPort(d.Nameserver); d.Nameserver != "" && err != nil {
+		return ErrInvalidDNSDiscovery("nameserver must be a host:port address")
+	}
+	if d.RefreshInterval < 0 {
+		return ErrInvalidDNSDiscovery("refresh interval must not be negative")
+	}
+	return nil
+}
+
+// returns a copy of the configuration with default values for unset fields
+func (d DNSDiscoveryConfig) withDefaults() DNSDiscoveryConfig {
+	if d.RecordType == "" {
+		d.RecordType = DNSRecordA
+	}
+	if d.Scheme == "" {
+		d.Scheme = "http"
+	}
+	if d.RefreshInterval == 0 {
+		d.RefreshInterval = DefaultDNSRefreshInterval
+	}
+	return d
+}
+
+// dnsRecord is a resolved host, with the port of SRV records and the ttl if the resolver reports it
+type dnsRecord struct {
+	host string
+	port uint16
+	ttl  time.Duration
+}
+
+// dnsResolver resolves the records of names
+type dnsResolver interface {
+	lookupHost(ctx context.Context, name string) ([]dnsRecord, error)
+	lookupSRV(ctx context.Context, name string) ([]dnsRecord, error)
+}
+
+// dnsDiscoverer discovers the endpoints from the records of a name
+type dnsDiscoverer struct {
+	cfg      DNSDiscoveryConfig
+	port     string
+	resolver dnsResolver
+}
+
+func newDNSDiscoverer(cfg DNSDiscoveryConfig, listenPort string) *dnsDiscoverer {
+	cfg = cfg.withDefaults()
+	port := cfg.Port
+	if port == "" {
+		port = resolvePort(listenPort)
+	}
+	var resolver dnsResolver = systemResolver{net.DefaultResolver}
+	if cfg.Nameserver != "" {
+		resolver = nameserverResolver(cfg.Nameserver)
+	}
+	return &dnsDiscoverer{cfg: cfg, port: port, resolver: resolver}
+}
+
+// Resolves the records, they are resolved again when the shortest ttl expires.
+// No records are considered a failure, so that a broken DNS zone does not remove all endpoints.
+func (d *dnsDiscoverer) discover(ctx context.Context) ([]discoveredEndpoint, time.Duration, error) {
+	lookup := d.resolver.lookupHost
+	if d.cfg.RecordType == DNSRecordSRV {
+		lookup = d.resolver.lookupSRV
+	}
+	records, err := lookup(ctx, d.cfg.Name)
+	if err == nil && len(records) == 0 {
+		err = fmt.Errorf("no records found")
+	}
+	if err != nil {
+		return nil, d.cfg.RefreshInterval, ErrDNSLookupFailed(d.cfg.Name, err)
+	}
+	endpoints := make([]discoveredEndpoint, 0, len(records))
+	var ttl time.Duration
+	for _, record := range records {
+		port := d.port
+		if record.port != 0 {
+			port = strconv.Itoa(int(record.port))
+		}
+		endpoints = append(endpoints, discoveredEndpoint{Address: d.cfg.Scheme + "://" + net.JoinHostPort(record.host, port)})
+		if record.ttl > 0 && (ttl == 0 || record.ttl < ttl) {
+			ttl = record.ttl
+		}
+	}
+	if ttl == 0 {
+		return endpoints, d.cfg.RefreshInterval, nil
+	}
+	return endpoints, max(ttl, MinDNSRefreshInterval), nil
+}
+
+// systemResolver resolves names with the resolver of the system, which does not report ttls
+type systemResolver struct {
+	*net.Resolver
+}
+
+func (r systemResolver) lookupHost(ctx context.Context, name string) ([]dnsRecord, error) {
+	addrs, err := r.LookupIPAddr(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	records := make([]dnsRecord, 0, len(addrs))
+	for _, addr := range addrs {
+		records = append(records, dnsRecord{host: addr.IP.String()})
+	}
+	return records, nil
+}
+
+func (r systemResolver) lookupSRV(ctx context.Context, name string) ([]dnsRecord, error) {
+	_, srvs, err := r.LookupSRV(ctx, "", "", name)
+	if err != nil {
+		return nil, err
+	}
+	records := make([]dnsRecord, 0, len(srvs))
+	for _, srv := range srvs {
+		records = append(records, dnsRecord{host: strings.TrimSuffix(srv.Target, "."), port: srv.Port})
+	}
+	return records, nil
+}
+
+// nameserverResolver resolves names by querying the nameserver at its address over udp, with the ttls of the records
+type nameserverResolver string
+
+func (r nameserverResolver) lookupHost(ctx context.Context, name string) ([]dnsRecord, error) {
+	records, err := r.query(ctx, name, dnsmessage.TypeA)
+	if err != nil {
+		return nil, err
+	}
+	aaaa, err := r.query(ctx, name, dnsmessage.TypeAAAA)
+	if err != nil {
+		return nil, err
+	}
+	return append(records, aaaa...), nil
+}
+
+func (r nameserverResolver) lookupSRV(ctx context.Context, name string) ([]dnsRecord, error) {
+	return r.query(ctx, name, dnsmessage.TypeSRV)
+}
+
+// queries the records of type of name, and returns the answers of that type
+func (r nameserverResolver) query(ctx context.Context, name string, recordType dnsmessage.Type) ([]dnsRecord, error) {
+	question, err := dnsmessage.NewName(strings.TrimSuffix(name, ".") + ".")
+	if err != nil {
+		return nil, err
+	}
+	request := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: uint16(rand.Uint32()), RecursionDesired: true},
+		Questions: []dnsmessage.Question{{Name: question, Type: recordType, Class: dnsmessage.ClassINET}},
+	}
+	packed, err := request.Pack()
+	if err != nil {
+		return nil, err
+	}
+	dialer := net.Dialer{}
+	conn, err := dialer.DialContext(ctx, "udp", string(r))
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	if _, err := conn.Write(packed); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 4096)
+	var response dnsmessage.Message
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		// responses to other queries are ignored
+		if response.Unpack(buf[:n]) == nil && response.ID == request.ID && response.Response {
+			break
+		}
+	}
+	switch response.RCode {
+	case dnsmessage.RCodeSuccess:
+	case dnsmessage.RCodeNameError:
+		return nil, fmt.Errorf("no such host")
+	default:
+		return nil, fmt.Errorf("nameserver responded with %s", response.RCode)
+	}
+	records := []dnsRecord{}
+	for _, answer := range response.Answers {
+		ttl := time.Duration(answer.Header.TTL) * time.Second
+		switch body := answer.Body.(type) {
+		case *dnsmessage.AResource:
+			records = append(records, dnsRecord{host: net.IP(body.A[:]).String(), ttl: ttl})
+		case *dnsmessage.AAAAResource:
+			records = append(records, dnsRecord{host: net.IP(body.AAAA[:]).String(), ttl: ttl})
+		case *dnsmessage.SRVResource:
+			records = append(records, dnsRecord{host: strings.TrimSuffix(body.Target.String(), "."), port: body.Port, ttl: ttl})
+		}
+	}
+	return records, nil
+}
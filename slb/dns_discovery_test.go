@@ -0,0 +1,307 @@
+package slb
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// fakeNameserver answers dns queries over udp with the records that are set by name
+type fakeNameserver struct {
+	conn    net.PacketConn
+	mu      sync.Mutex
+	records map[string][]dnsmessage.Resource
+}
+
+func newFakeNameserver(t *testing.T) *fakeNameserver {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	n := &fakeNameserver{conn: conn, records: make(map[string][]dnsmessage.Resource)}
+	go n.serve()
+	return n
+}
+
+func (n *fakeNameserver) addr() string {
+	return n.conn.LocalAddr().String()
+}
+
+// sets the records of name, a name without records does not exist
+func (n *fakeNameserver) set(name string, records ...dnsmessage.Resource) {
+	defer n.mu.Unlock()
+	n.mu.Lock()
+	n.records[name+"."] = records
+}
+
+func (n *fakeNameserver) serve() {
+	buf := make([]byte, 512)
+	for {
+		size, addr, err := n.conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		var request dnsmessage.Message
+		if request.Unpack(buf[:size]) != nil || len(request.Questions) != 1 {
+			continue
+		}
+		question := request.Questions[0]
+		response := dnsmessage.Message{
+			Header:    dnsmessage.Header{ID: request.ID, Response: true, RCode: dnsmessage.RCodeNameError},
+			Questions: request.Questions,
+		}
+		n.mu.Lock()
+		records, ok := n.records[question.Name.String()]
+		n.mu.Unlock()
+		if ok {
+			response.RCode = dnsmessage.RCodeSuccess
+			for _, record := range records {
+				if record.Header.Type == question.Type {
+					record.Header.Name, record.Header.Class = question.Name, dnsmessage.ClassINET
+					response.Answers = append(response.Answers, record)
+				}
+			}
+		}
+		packed, err := response.Pack()
+		if err != nil {
+			continue
+		}
+		n.conn.WriteTo(packed, addr)
+	}
+}
+
+func aRecord(ip string, ttl uint32) dnsmessage.Resource {
+	body := &dnsmessage.AResource{}
+	copy(body.A[:], net.ParseIP(ip).To4())
+	return dnsmessage.Resource{Header: dnsmessage.ResourceHeader{Type: dnsmessage.TypeA, TTL: ttl}, Body: body}
+}
+
+func aaaaRecord(ip string, ttl uint32) dnsmessage.Resource {
+	body := &dnsmessage.AAAAResource{}
+	copy(body.AAAA[:], net.ParseIP(ip))
+	return dnsmessage.Resource{Header: dnsmessage.ResourceHeader{Type: dnsmessage.TypeAAAA, TTL: ttl}, Body: body}
+}
+
+func srvRecord(target string, port uint16, ttl uint32) dnsmessage.Resource {
+	return dnsmessage.Resource{
+		Header: dnsmessage.ResourceHeader{Type: dnsmessage.TypeSRV, TTL: ttl},
+		Body:   &dnsmessage.SRVResource{Target: dnsmessage.MustNewName(target + "."), Port: port},
+	}
+}
+
+// setSelector keeps the added endpoints, and selects them in turn
+type setSelector struct {
+	Selector
+	mu        sync.Mutex
+	endpoints []*Endpoint
+	next      int
+}
+
+func (s *setSelector) Add(server *Endpoint) error {
+	defer s.mu.Unlock()
+	s.mu.Lock()
+	s.endpoints = append(s.endpoints, server)
+	return nil
+}
+
+func (s *setSelector) Remove(server *Endpoint) error {
+	defer s.mu.Unlock()
+	s.mu.Lock()
+	s.endpoints = slices.DeleteFunc(s.endpoints, func(endpoint *Endpoint) bool { return endpoint == server })
+	return nil
+}
+
+func (s *setSelector) Select() (*Endpoint, error) {
+	defer s.mu.Unlock()
+	s.mu.Lock()
+	if len(s.endpoints) == 0 {
+		return nil, fmt.Errorf("selector has no endpoints to select")
+	}
+	server := s.endpoints[s.next%len(s.endpoints)]
+	s.next++
+	return server, nil
+}
+
+func (s *setSelector) EndPoints() ([]*Endpoint, error) {
+	defer s.mu.Unlock()
+	s.mu.Lock()
+	return slices.Clone(s.endpoints), nil
+}
+
+// returns the sorted addresses of the endpoints of selector
+func addresses(t *testing.T, selector Selector) []string {
+	endpoints, err := selector.EndPoints()
+	require.NoError(t, err)
+	addrs := []string{}
+	for _, server := range endpoints {
+		addrs = append(addrs, server.Addr)
+	}
+	slices.Sort(addrs)
+	return addrs
+}
+
+func TestDNSDiscoveryConfigValidate(t *testing.T) {
+	require.NoError(t, DNSDiscoveryConfig{}.Validate())
+	require.NoError(t, DNSDiscoveryConfig{Name: "backend.internal", Port: "9000", Scheme: "https", Nameserver: "10.0.0.2:53"}.Validate())
+	require.NoError(t, DNSDiscoveryConfig{Name: "_http._tcp.backend.internal", RecordType: DNSRecordSRV}.Validate())
+	require.Error(t, DNSDiscoveryConfig{Name: "backend.internal", RecordType: "MX"}.Validate())
+	require.Error(t, DNSDiscoveryConfig{Name: "backend.internal", Scheme: "ftp"}.Validate())
+	require.Error(t, DNSDiscoveryConfig{Name: "backend.internal", Port: "http"}.Validate())
+	require.Error(t, DNSDiscoveryConfig{Name: "backend.internal", Nameserver: "10.0.0.2"}.Validate())
+	require.Error(t, DNSDiscoveryConfig{Name: "backend.internal", RefreshInterval: -time.Second}.Validate())
+	require.Error(t, PoolConfig{Name: "api", Selector: &setSelector{}, Discovery: DiscoveryConfig{DNS: DNSDiscoveryConfig{Name: "backend.internal", RecordType: "MX"}}}.Validate())
+}
+
+func TestDNSDiscoverer(t *testing.T) {
+	nameserver := newFakeNameserver(t)
+	nameserver.set("backend.internal", aRecord("10.0.0.1", 60), aRecord("10.0.0.2", 30), aaaaRecord("fd00::1", 90))
+	nameserver.set("_http._tcp.backend.internal", srvRecord("backend-1.internal", 9001, 0), srvRecord("backend-2.internal", 9002, 0))
+	nameserver.set("short.internal", aRecord("10.0.0.3", 0), aRecord("10.0.0.4", 0))
+	type dnsDiscovererTest struct {
+		name          string
+		cfg           DNSDiscoveryConfig
+		expectedAddrs []string
+		expectedNext  time.Duration
+		err           bool
+	}
+	scenarios := []dnsDiscovererTest{
+		{
+			name:          "A and AAAA records, with the shortest ttl",
+			cfg:           DNSDiscoveryConfig{Name: "backend.internal", Port: "9000"},
+			expectedAddrs: []string{"http://10.0.0.1:9000", "http://10.0.0.2:9000", "http://[fd00::1]:9000"},
+			expectedNext:  time.Second * 30,
+		},
+		{
+			name:          "SRV records without ttl",
+			cfg:           DNSDiscoveryConfig{Name: "_http._tcp.backend.internal", RecordType: DNSRecordSRV, Scheme: "https", RefreshInterval: time.Minute},
+			expectedAddrs: []string{"https://backend-1.internal:9001", "https://backend-2.internal:9002"},
+			expectedNext:  time.Minute,
+		},
+		{
+			name:          "the listen port without port",
+			cfg:           DNSDiscoveryConfig{Name: "short.internal"},
+			expectedAddrs: []string{"http://10.0.0.3:8080", "http://10.0.0.4:8080"},
+			expectedNext:  DefaultDNSRefreshInterval,
+		},
+		{
+			name:         "unknown name",
+			cfg:          DNSDiscoveryConfig{Name: "missing.internal"},
+			expectedNext: DefaultDNSRefreshInterval,
+			err:          true,
+		},
+		{
+			name:         "no records of the type",
+			cfg:          DNSDiscoveryConfig{Name: "short.internal", RecordType: DNSRecordSRV},
+			expectedNext: DefaultDNSRefreshInterval,
+			err:          true,
+		},
+	}
+	for _, scenario := range scenarios {
+		t.Run(scenario.name, func(t *testing.T) {
+			scenario.cfg.Nameserver = nameserver.addr()
+			endpoints, next, err := newDNSDiscoverer(scenario.cfg, "8080").discover(context.Background())
+			require.Equal(t, scenario.expectedNext, next)
+			if scenario.err {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			addrs := []string{}
+			for _, endpoint := range endpoints {
+				addrs = append(addrs, endpoint.Address)
+			}
+			slices.Sort(addrs)
+			require.Equal(t, scenario.expectedAddrs, addrs)
+		})
+	}
+
+	nameserver.set("backend.internal", aRecord("10.0.0.1", 0), aRecord("10.0.0.2", 1))
+	_, next, err := newDNSDiscoverer(DNSDiscoveryConfig{Name: "backend.internal", Nameserver: nameserver.addr()}, "").discover(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, MinDNSRefreshInterval, next)
+}
+
+func TestDNSDiscoveryUpdatesPoolEndpoints(t *testing.T) {
+	nameserver := newFakeNameserver(t)
+	nameserver.set("backend.internal", aRecord("10.0.0.1", 60), aRecord("10.0.0.2", 60))
+	nameserver.set("api.internal", aRecord("10.0.1.1", 60))
+	selector := &setSelector{}
+	s, err := New(Config{
+		Endpoints:     []*Endpoint{{Addr: "127.0.0.1:9999"}},
+		ListenAddress: "localhost",
+		Discovery:     DiscoveryConfig{DNS: DNSDiscoveryConfig{Name: "backend.internal", Port: "9000", Nameserver: nameserver.addr()}},
+		Pools: []PoolConfig{{
+			Name:      "api",
+			Selector:  &setSelector{},
+			Discovery: DiscoveryConfig{DNS: DNSDiscoveryConfig{Name: "api.internal", Port: "9000", Nameserver: nameserver.addr()}},
+		}},
+	}, selector)
+	require.NoError(t, err)
+	require.Equal(t, []string{"http://10.0.0.1:9000", "http://10.0.0.2:9000", "http://127.0.0.1:9999"}, addresses(t, selector))
+	require.Equal(t, "api.internal", s.Configuration().Pools[0].Discovery.DNS.Name)
+
+	nameserver.set("backend.internal", aRecord("10.0.0.2", 60), aRecord("10.0.0.3", 60))
+	require.NoError(t, s.discovery.pools[DefaultPoolName].refresh())
+	require.Equal(t, []string{"http://10.0.0.2:9000", "http://10.0.0.3:9000", "http://127.0.0.1:9999"}, addresses(t, selector),
+		"the configured endpoints are kept")
+
+	nameserver.set("backend.internal")
+	require.Error(t, s.discovery.pools[DefaultPoolName].refresh())
+	require.Equal(t, []string{"http://10.0.0.2:9000", "http://10.0.0.3:9000", "http://127.0.0.1:9999"}, addresses(t, selector),
+		"the endpoints are kept if the resolution fails")
+
+	require.NoError(t, s.DeletePool("api"))
+	require.NotContains(t, s.discovery.pools, "api")
+
+	_, err = New(Config{
+		ListenAddress: "localhost",
+		Discovery:     DiscoveryConfig{DNS: DNSDiscoveryConfig{Name: "missing.internal", Nameserver: nameserver.addr()}},
+	}, &setSelector{})
+	require.ErrorContains(t, err, "missing.internal")
+}
+
+// discovererFunc discovers the endpoints that are returned by the func
+type discovererFunc func() ([]discoveredEndpoint, time.Duration, error)
+
+func (f discovererFunc) discover(context.Context) ([]discoveredEndpoint, time.Duration, error) {
+	return f()
+}
+
+func TestDiscoveryRefreshesInTheBackground(t *testing.T) {
+	var mu sync.Mutex
+	endpoints := []discoveredEndpoint{{Address: "http://10.0.0.1:80"}}
+	selector := &setSelector{}
+	d := newDiscovery("", discoveryHandler{
+		add:    func(selector Selector, server *Endpoint) error { return selector.Add(server) },
+		remove: func(selector Selector, server *Endpoint) error { return selector.Remove(server) },
+	})
+	d.pools[DefaultPoolName] = &poolDiscovery{
+		source: discovererFunc(func() ([]discoveredEndpoint, time.Duration, error) {
+			defer mu.Unlock()
+			mu.Lock()
+			return slices.Clone(endpoints), time.Millisecond, nil
+		}),
+		selector:  selector,
+		handler:   d.handler,
+		endpoints: make(map[string]*discoveredServer),
+	}
+	d.Start()
+	defer d.Stop()
+	require.Eventually(t, func() bool {
+		return strings.Join(addresses(t, selector), ",") == "http://10.0.0.1:80"
+	}, time.Second, time.Millisecond)
+
+	mu.Lock()
+	endpoints = []discoveredEndpoint{{Address: "http://10.0.0.2:80"}, {Address: "http://10.0.0.3:80"}}
+	mu.Unlock()
+	require.Eventually(t, func() bool {
+		return strings.Join(addresses(t, selector), ",") == "http://10.0.0.2:80,http://10.0.0.3:80"
+	}, time.Second, time.Millisecond)
+}
@@ -0,0 +1,244 @@
+package slb
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/client"
+)
+
+const (
+	// Labels of the containers: the pool they belong to, and optionally their port, scheme and weight.
+	// The labels with the DockerLabelMetadataPrefix are set as metadata of the endpoints, without the prefix.
+	DockerLabelPool           = "balance.pool"
+	DockerLabelPort           = "balance.port"
+	DockerLabelScheme         = "balance.scheme"
+	DockerLabelWeight         = "balance.weight"
+	DockerLabelMetadataPrefix = "balance.metadata."
+
+	DefaultDockerRefreshInterval = time.Second * 30
+	// Time after which the events are watched again if the stream failed
+	DockerEventsRetryInterval = time.Second
+)
+
+var (
+	ErrInvalidDockerDiscovery = func(reason string) error { return fmt.Errorf("invalid docker discovery configuration: %s", reason) }
+	ErrDockerListFailed       = func(host string, err error) error {
+		return fmt.Errorf("failed to list the containers of %s: %s", host, err)
+	}
+)
+
+// DockerDiscoveryConfig discovers the endpoints of a pool from the running containers of a docker daemon,
+// that have the balance.pool label set to the name of the pool, e.g
+//
+//	docker run --label balance.pool=web --label balance.port=8080 backend
+//
+// The containers are added when they start and removed when they stop, as reported by the events of the daemon.
+// Docker discovery is disabled if no Host is provided.
+type DockerDiscoveryConfig struct {
+	// Address of the docker daemon (e.g "unix:///var/run/docker.sock" or "tcp://10.0.0.2:2375")
+	Host string `json:"host,omitempty"`
+	// Name of the network whose address of the containers is used, the address of any network is used if empty
+	Network string `json:"network,omitempty"`
+	// Interval in which the containers are listed again, in case events were missed (default 30s)
+	RefreshInterval time.Duration `json:"refreshInterval,omitempty"`
+}
+
+// Returns true if a host is configured
+func (d DockerDiscoveryConfig) Enabled() bool {
+	return d.Host != ""
+}
+
+// Validates the docker discovery configuration
+func (d DockerDiscoveryConfig) Validate() error {
+	if !d.Enabled() {
+		return nil
+	}
+	if _, err := client.ParseHostURL(d.Host); err != nil {
+		return ErrInvalidDockerDiscovery(err.Error())
+	}
+	if d.RefreshInterval < 0 {
+		return ErrInvalidDockerDiscovery("refresh interval must not be negative")
+	}
+	return nil
+}
+
+// dockerClient is the part of the docker api that discovers the containers
+type dockerClient interface {
+	ContainerList(ctx context.Context, options types.ContainerListOptions) ([]types.Container, error)
+	Events(ctx context.Context, options types.EventsOptions) (<-chan events.Message, <-chan error)
+	Close() error
+}
+
+// dockerDiscoverer discovers the endpoints from the labeled containers of a pool
+type dockerDiscoverer struct {
+	cfg        DockerDiscoveryConfig
+	pool       string
+	listenPort string
+	client     dockerClient
+	// error of creating the client, it is returned by every discovery
+	err error
+}
+
+func newDockerDiscoverer(pool string, cfg DockerDiscoveryConfig, listenPort string) *dockerDiscoverer {
+	if cfg.RefreshInterval == 0 {
+		cfg.RefreshInterval = DefaultDockerRefreshInterval
+	}
+	d := &dockerDiscoverer{cfg: cfg, pool: pool, listenPort: listenPort}
+	d.client, d.err = client.NewClientWithOpts(client.WithHost(cfg.Host), client.WithAPIVersionNegotiation())
+	return d
+}
+
+// Closes the connections of the client to the docker daemon
+func (d *dockerDiscoverer) Close() error {
+	if d.err != nil {
+		return nil
+	}
+	return d.client.Close()
+}
+
+// returns the filters of the containers of the pool
+func (d *dockerDiscoverer) filters() filters.Args {
+	return filters.NewArgs(filters.Arg("label", DockerLabelPool+"="+d.pool))
+}
+
+// Lists the running containers of the pool. Containers with invalid labels, or without an address, are skipped.
+// No containers are not considered a failure, all containers of a pool may be stopped.
+func (d *dockerDiscoverer) discover(ctx context.Context) ([]discoveredEndpoint, time.Duration, error) {
+	if d.err != nil {
+		return nil, d.cfg.RefreshInterval, ErrDockerListFailed(d.cfg.Host, d.err)
+	}
+	args := d.filters()
+	args.Add("status", "running")
+	containers, err := d.client.ContainerList(ctx, types.ContainerListOptions{Filters: args})
+	if err != nil {
+		return nil, d.cfg.RefreshInterval, ErrDockerListFailed(d.cfg.Host, err)
+	}
+	endpoints := make([]discoveredEndpoint, 0, len(containers))
+	for _, container := range containers {
+		endpoint, err := d.endpoint(container)
+		if err != nil {
+			slog.Warn(fmt.Sprintf("skipped container %s of pool %s: %s", containerName(container), d.pool, err))
+			continue
+		}
+		endpoints = append(endpoints, endpoint)
+	}
+	return endpoints, d.cfg.RefreshInterval, nil
+}
+
+// returns the endpoint of container from its address and labels
+func (d *dockerDiscoverer) endpoint(container types.Container) (discoveredEndpoint, error) {
+	ip := d.address(container)
+	if ip == "" {
+		return discoveredEndpoint{}, fmt.Errorf("no address")
+	}
+	port := container.Labels[DockerLabelPort]
+	if port == "" {
+		port = resolvePort(d.listenPort)
+	}
+	scheme := container.Labels[DockerLabelScheme]
+	if scheme == "" {
+		scheme = "http"
+	}
+	address := scheme + "://" + net.JoinHostPort(ip, port)
+	if _, err := endpointURL(address, d.listenPort); err != nil {
+		return discoveredEndpoint{}, err
+	}
+	endpoint := discoveredEndpoint{
+		Address:  address,
+		Metadata: map[string]string{"container": containerName(container), "image": container.Image},
+	}
+	if weight, ok := container.Labels[DockerLabelWeight]; ok {
+		if endpoint.Weight, _ = strconv.Atoi(weight); endpoint.Weight < 1 {
+			return discoveredEndpoint{}, fmt.Errorf("invalid weight %q", weight)
+		}
+	}
+	for label, value := range container.Labels {
+		if key, ok := strings.CutPrefix(label, DockerLabelMetadataPrefix); ok && key != "" {
+			endpoint.Metadata[key] = value
+		}
+	}
+	return endpoint, nil
+}
+
+// returns the ip of container in the configured network, or in any network
+func (d *dockerDiscoverer) address(container types.Container) string {
+	if container.NetworkSettings == nil {
+		return ""
+	}
+	if d.cfg.Network != "" {
+		if settings, ok := container.NetworkSettings.Networks[d.cfg.Network]; ok && settings != nil {
+			return settings.IPAddress
+		}
+		return ""
+	}
+	for _, settings := range container.NetworkSettings.Networks {
+		if settings != nil && settings.IPAddress != "" {
+			return settings.IPAddress
+		}
+	}
+	return ""
+}
+
+// Watches the events of the containers of the pool, and notifies when one starts or stops.
+// The events are watched again if the stream fails, which also notifies as events may have been missed.
+func (d *dockerDiscoverer) watch(ctx context.Context) <-chan struct{} {
+	changes := make(chan struct{}, 1)
+	if d.err != nil {
+		return changes
+	}
+	notify := func() {
+		select {
+		case changes <- struct{}{}:
+		default:
+		}
+	}
+	args := d.filters()
+	args.Add("type", string(events.ContainerEventType))
+	args.Add("event", "start")
+	args.Add("event", "die")
+	go func() {
+		for {
+			messages, errs := d.client.Events(ctx, types.EventsOptions{Filters: args})
+			func() {
+				for {
+					select {
+					case <-messages:
+						notify()
+					case err := <-errs:
+						if ctx.Err() == nil {
+							slog.Error(fmt.Sprintf("failed to watch the containers of pool %s: %s", d.pool, err))
+						}
+						return
+					}
+				}
+			}()
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(DockerEventsRetryInterval):
+				notify()
+			}
+		}
+	}()
+	return changes
+}
+
+// returns the name of container without the leading slash, or its short id if it has no name
+func containerName(container types.Container) string {
+	if len(container.Names) > 0 {
+		return strings.TrimPrefix(container.Names[0], "/")
+	}
+	if len(container.ID) > 12 {
+		return container.ID[:12]
+	}
+	return container.ID
+}
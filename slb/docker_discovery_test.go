@@ -0,0 +1,237 @@
+package slb
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/network"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeDocker serves the parts of the docker api that are used by the discovery:
+// the version negotiation, the list of containers, and the stream of events
+type fakeDocker struct {
+	*httptest.Server
+	mu         sync.Mutex
+	containers []types.Container
+	events     chan events.Message
+	// number of event streams that were opened
+	streams int
+	// number of open connections to the api
+	conns int
+}
+
+func newFakeDocker(t *testing.T) *fakeDocker {
+	d := &fakeDocker{events: make(chan events.Message)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/_ping", func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("Api-Version", "1.43")
+		rw.Write([]byte("OK"))
+	})
+	mux.HandleFunc("/v1.43/containers/json", d.list)
+	mux.HandleFunc("/v1.43/events", d.stream)
+	d.Server = httptest.NewUnstartedServer(mux)
+	d.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		defer d.mu.Unlock()
+		d.mu.Lock()
+		switch state {
+		case http.StateNew:
+			d.conns++
+		case http.StateHijacked, http.StateClosed:
+			d.conns--
+		}
+	}
+	d.Start()
+	t.Cleanup(d.Close)
+	return d
+}
+
+// returns the docker host of the fake api
+func (d *fakeDocker) host() string {
+	return "tcp://" + d.Listener.Addr().String()
+}
+
+func (d *fakeDocker) set(containers ...types.Container) {
+	defer d.mu.Unlock()
+	d.mu.Lock()
+	d.containers = containers
+}
+
+// lists the containers that match the label and status filters
+func (d *fakeDocker) list(rw http.ResponseWriter, r *http.Request) {
+	args, err := filters.FromJSON(r.URL.Query().Get("filters"))
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	containers := []types.Container{}
+	for _, container := range d.containers {
+		if !args.MatchKVList("label", container.Labels) || (args.Contains("status") && !args.ExactMatch("status", container.State)) {
+			continue
+		}
+		containers = append(containers, container)
+	}
+	json.NewEncoder(rw).Encode(containers)
+}
+
+// streams the sent events until the request is done
+func (d *fakeDocker) stream(rw http.ResponseWriter, r *http.Request) {
+	d.mu.Lock()
+	d.streams++
+	d.mu.Unlock()
+	rw.WriteHeader(http.StatusOK)
+	rw.(http.Flusher).Flush()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event := <-d.events:
+			json.NewEncoder(rw).Encode(event)
+			rw.(http.Flusher).Flush()
+		}
+	}
+}
+
+// returns a container in state with the ip in the network, and labels
+func newContainer(name string, state string, networkName string, ip string, labels map[string]string) types.Container {
+	return types.Container{
+		ID:     name + "-id",
+		Names:  []string{"/" + name},
+		Image:  "backend",
+		State:  state,
+		Labels: labels,
+		NetworkSettings: &types.SummaryNetworkSettings{Networks: map[string]*network.EndpointSettings{
+			networkName: {IPAddress: ip},
+		}},
+	}
+}
+
+func TestDockerDiscoveryConfigValidate(t *testing.T) {
+	require.NoError(t, DockerDiscoveryConfig{}.Validate())
+	require.NoError(t, DockerDiscoveryConfig{Host: "unix:///var/run/docker.sock"}.Validate())
+	require.NoError(t, DockerDiscoveryConfig{Host: "tcp://10.0.0.2:2375", Network: "backend", RefreshInterval: time.Minute}.Validate())
+	require.Error(t, DockerDiscoveryConfig{Host: "docker.sock"}.Validate())
+	require.Error(t, DockerDiscoveryConfig{Host: "unix:///var/run/docker.sock", RefreshInterval: -time.Second}.Validate())
+	require.Error(t, DiscoveryConfig{
+		File:   FileDiscoveryConfig{Path: "endpoints.yaml"},
+		Docker: DockerDiscoveryConfig{Host: "unix:///var/run/docker.sock"},
+	}.Validate())
+}
+
+func TestDockerDiscoverer(t *testing.T) {
+	docker := newFakeDocker(t)
+	docker.set(
+		newContainer("web-1", "running", "bridge", "172.17.0.2", map[string]string{
+			DockerLabelPool: "web", DockerLabelPort: "8080", DockerLabelWeight: "3", DockerLabelMetadataPrefix + "zone": "a",
+		}),
+		newContainer("web-2", "running", "bridge", "172.17.0.3", map[string]string{DockerLabelPool: "web", DockerLabelScheme: "https"}),
+		newContainer("web-3", "exited", "bridge", "172.17.0.4", map[string]string{DockerLabelPool: "web"}),
+		newContainer("web-4", "running", "bridge", "", map[string]string{DockerLabelPool: "web"}),
+		newContainer("web-5", "running", "bridge", "172.17.0.6", map[string]string{DockerLabelPool: "web", DockerLabelWeight: "heavy"}),
+		newContainer("api-1", "running", "bridge", "172.17.0.7", map[string]string{DockerLabelPool: "api"}),
+	)
+
+	discoverer := newDockerDiscoverer("web", DockerDiscoveryConfig{Host: docker.host()}, "9000")
+	endpoints, next, err := discoverer.discover(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, DefaultDockerRefreshInterval, next)
+	require.Equal(t, []discoveredEndpoint{
+		{Address: "http://172.17.0.2:8080", Weight: 3, Metadata: map[string]string{"container": "web-1", "image": "backend", "zone": "a"}},
+		{Address: "https://172.17.0.3:9000", Metadata: map[string]string{"container": "web-2", "image": "backend"}},
+	}, endpoints, "stopped containers, and containers with invalid labels or without an address are skipped")
+
+	discoverer = newDockerDiscoverer("web", DockerDiscoveryConfig{Host: docker.host(), Network: "backend"}, "9000")
+	endpoints, _, err = discoverer.discover(context.Background())
+	require.NoError(t, err)
+	require.Empty(t, endpoints, "only the addresses of the network are used")
+
+	docker.Close()
+	_, _, err = discoverer.discover(context.Background())
+	require.Error(t, err)
+}
+
+func TestDockerDiscoveryWatchesEvents(t *testing.T) {
+	docker := newFakeDocker(t)
+	web1 := newContainer("web-1", "running", "bridge", "172.17.0.2", map[string]string{DockerLabelPool: "web", DockerLabelPort: "8080"})
+	web2 := newContainer("web-2", "running", "bridge", "172.17.0.3", map[string]string{DockerLabelPool: "web", DockerLabelPort: "8080"})
+	docker.set(web1)
+	selector := &setSelector{}
+	d := newDiscovery("", discoveryHandler{
+		add:    func(selector Selector, server *Endpoint) error { return selector.Add(server) },
+		remove: func(selector Selector, server *Endpoint) error { return selector.Remove(server) },
+	})
+	require.NoError(t, d.Set("web", DiscoveryConfig{Docker: DockerDiscoveryConfig{Host: docker.host(), RefreshInterval: time.Hour}}, selector))
+	require.Equal(t, []string{"http://172.17.0.2:8080"}, addresses(t, selector))
+	d.Start()
+	defer d.Stop()
+	require.Eventually(t, func() bool {
+		docker.mu.Lock()
+		defer docker.mu.Unlock()
+		return docker.streams == 1
+	}, time.Second, time.Millisecond)
+
+	docker.set(web1, web2)
+	docker.events <- events.Message{Type: events.ContainerEventType, Action: "start", Actor: events.Actor{ID: web2.ID}}
+	require.Eventually(t, func() bool {
+		return slices.Equal([]string{"http://172.17.0.2:8080", "http://172.17.0.3:8080"}, addresses(t, selector))
+	}, time.Second, time.Millisecond, "started containers are added")
+
+	web1.State = "exited"
+	docker.set(web1, web2)
+	docker.events <- events.Message{Type: events.ContainerEventType, Action: "die", Actor: events.Actor{ID: web1.ID}}
+	require.Eventually(t, func() bool {
+		return strings.Join(addresses(t, selector), ",") == "http://172.17.0.3:8080"
+	}, time.Second, time.Millisecond, "stopped containers are removed")
+}
+
+func TestDockerDiscoveryClosesConnections(t *testing.T) {
+	docker := newFakeDocker(t)
+	docker.set(newContainer("web-1", "running", "bridge", "172.17.0.2", map[string]string{DockerLabelPool: "web", DockerLabelPort: "8080"}))
+	d := newDiscovery("", discoveryHandler{
+		add:    func(selector Selector, server *Endpoint) error { return selector.Add(server) },
+		remove: func(selector Selector, server *Endpoint) error { return selector.Remove(server) },
+	})
+	cfg := DiscoveryConfig{Docker: DockerDiscoveryConfig{Host: docker.host(), RefreshInterval: time.Hour}}
+	conns := func() int {
+		docker.mu.Lock()
+		defer docker.mu.Unlock()
+		return docker.conns
+	}
+
+	streams := func() int {
+		docker.mu.Lock()
+		defer docker.mu.Unlock()
+		return docker.streams
+	}
+	// lists the containers while the events are streamed, which keeps an idle connection to the api
+	discover := func() {
+		opened := streams()
+		require.NoError(t, d.Set("web", cfg, &setSelector{}))
+		d.Start()
+		require.Eventually(t, func() bool { return streams() == opened+1 }, time.Second, time.Millisecond)
+		_, _, err := d.pools["web"].source.discover(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, 2, conns())
+	}
+
+	discover()
+	d.Delete("web")
+	require.Eventually(t, func() bool { return conns() == 0 }, time.Second, time.Millisecond, "deleted discoveries close their connections")
+
+	discover()
+	d.Stop()
+	require.Eventually(t, func() bool { return conns() == 0 }, time.Second, time.Millisecond, "stopped discoveries close their connections")
+}
@@ -0,0 +1,219 @@
+package slb
+
+import (
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+const DefaultDrainTimeout = time.Second * 30
+
+var (
+	ErrEndpointNotFound    = func(id string) error { return fmt.Errorf("endpoint %s not found", id) }
+	ErrInvalidDrainTimeout = func() error { return fmt.Errorf("drain timeout must not be negative") }
+)
+
+// DrainStatus is the progress of draining an endpoint
+type DrainStatus struct {
+	// Requests to the endpoint that are in flight
+	InFlight int `json:"inFlight"`
+	// Time the drain started
+	Started time.Time `json:"started"`
+	// Time the endpoint is removed, even if requests are still in flight
+	Deadline time.Time `json:"deadline"`
+}
+
+// drainer counts the requests in flight by endpoint identity, and tracks the endpoints that are drained.
+// A nil drainer counts and drains nothing.
+type drainer struct {
+	mu       sync.Mutex
+	inFlight map[string]int
+	// drained endpoints by identity, their channel is closed when no requests are in flight
+	draining map[string]*drain
+	// closed when the drains are stopped
+	stop chan struct{}
+}
+
+type drain struct {
+	status DrainStatus
+	idle   chan struct{}
+}
+
+// signals that no requests are in flight (guarded by the mutex of the drainer)
+func (d *drain) setIdle() {
+	select {
+	case <-d.idle:
+	default:
+		close(d.idle)
+	}
+}
+
+func newDrainer() *drainer {
+	return &drainer{
+		inFlight: make(map[string]int),
+		draining: make(map[string]*drain),
+		stop:     make(chan struct{}),
+	}
+}
+
+// Counts a request to the endpoint id as in flight, until the returned func is called
+func (d *drainer) Track(id string) func() {
+	if d == nil {
+		return func() {}
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.inFlight[id]++
+	return func() {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		d.inFlight[id]--
+		if d.inFlight[id] > 0 {
+			return
+		}
+		delete(d.inFlight, id)
+		if drain, ok := d.draining[id]; ok {
+			drain.setIdle()
+		}
+	}
+}
+
+// Returns true if the endpoint id is drained, it is not selected for new requests
+func (d *drainer) IsDraining(id string) bool {
+	if d == nil {
+		return false
+	}
+	defer d.mu.Unlock()
+	d.mu.Lock()
+	_, ok := d.draining[id]
+	return ok
+}
+
+// Starts draining the endpoint id until timeout, and calls remove when its requests finished or the timeout expired.
+// An endpoint that is drained already keeps its deadline.
+func (d *drainer) Drain(id string, timeout time.Duration, remove func()) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, ok := d.draining[id]; ok {
+		return
+	}
+	now := time.Now()
+	drain := &drain{status: DrainStatus{Started: now, Deadline: now.Add(timeout)}, idle: make(chan struct{})}
+	if d.inFlight[id] == 0 {
+		drain.setIdle()
+	}
+	d.draining[id] = drain
+	go func() {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		select {
+		case <-d.stop:
+			return
+		case <-drain.idle:
+		case <-timer.C:
+			slog.Warn(fmt.Sprintf("drain of endpoint %s timed out with requests in flight", id))
+		}
+		remove()
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		delete(d.draining, id)
+	}()
+}
+
+// Returns the number of requests in flight to the endpoint id
+func (d *drainer) InFlight(id string) int {
+	if d == nil {
+		return 0
+	}
+	defer d.mu.Unlock()
+	d.mu.Lock()
+	return d.inFlight[id]
+}
+
+// Returns the progress of the drained endpoints by identity
+func (d *drainer) Status() map[string]DrainStatus {
+	if d == nil {
+		return nil
+	}
+	defer d.mu.Unlock()
+	d.mu.Lock()
+	status := make(map[string]DrainStatus, len(d.draining))
+	for id, drain := range d.draining {
+		drain.status.InFlight = d.inFlight[id]
+		status[id] = drain.status
+	}
+	return status
+}
+
+// Stops the drains, the drained endpoints are not removed
+func (d *drainer) Stop() {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	select {
+	case <-d.stop:
+	default:
+		close(d.stop)
+	}
+}
+
+// Drains the endpoint server from all pools: it is no longer selected for new requests,
+// and it is removed when its requests in flight finished, or after timeout (DefaultDrainTimeout if 0).
+// The progress of the drain is reported by Configuration.
+func (s *Slb) Drain(server *Endpoint, timeout time.Duration) error {
+	if timeout < 0 {
+		return ErrInvalidDrainTimeout()
+	}
+	if timeout == 0 {
+		timeout = DefaultDrainTimeout
+	}
+	if err := s.resolveServerAddress(server); err != nil {
+		return err
+	}
+	id := server.Identity()
+	if len(s.poolEndpoints(id)) == 0 {
+		return ErrEndpointNotFound(id)
+	}
+	slog.Info(fmt.Sprintf("draining endpoint %s", id))
+	s.drains.Drain(id, timeout, func() {
+		for _, endpoint := range s.poolEndpoints(id) {
+			if err := s.remove(endpoint.selector, endpoint.server); err != nil {
+				slog.Error(fmt.Sprintf("could not remove drained endpoint %s: %s", id, err))
+			}
+		}
+		slog.Info(fmt.Sprintf("removed drained endpoint %s", id))
+	})
+	return nil
+}
+
+// poolEndpoint is an endpoint in the selector of a pool
+type poolEndpoint struct {
+	selector Selector
+	server   *Endpoint
+}
+
+// returns the endpoints with the identity id of the default pool and the pools
+func (s *Slb) poolEndpoints(id string) []poolEndpoint {
+	selectors := []Selector{s.selector}
+	s.mu.RLock()
+	for _, selector := range s.pools {
+		selectors = append(selectors, selector)
+	}
+	s.mu.RUnlock()
+	found := []poolEndpoint{}
+	for _, selector := range selectors {
+		endpoints, err := selector.EndPoints()
+		if err != nil {
+			continue
+		}
+		for _, endpoint := range endpoints {
+			if endpoint.Identity() == id {
+				found = append(found, poolEndpoint{selector: selector, server: endpoint})
+			}
+		}
+	}
+	return found
+}
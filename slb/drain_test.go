@@ -0,0 +1,101 @@
+package slb
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// returns a new SLB of the backends, that are selected in turn
+func newDrainSlb(t *testing.T, backends ...*httptest.Server) (*Slb, *setSelector) {
+	endpoints := []*Endpoint{}
+	for _, backend := range backends {
+		endpoints = append(endpoints, &Endpoint{Addr: backend.URL})
+	}
+	selector := &setSelector{}
+	s, err := New(Config{Endpoints: endpoints, ListenAddress: "localhost"}, selector)
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Stop() })
+	return s, selector
+}
+
+// serves a request with s, and returns its response body
+func serveBody(s *Slb) string {
+	rw := httptest.NewRecorder()
+	s.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "/", nil))
+	return rw.Body.String()
+}
+
+func TestDrainWaitsForRequestsInFlight(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	drained := blockingBackend(t, "drained", started, release)
+	s, selector := newDrainSlb(t, drained, bodyBackend(t, "other"))
+
+	inFlight := make(chan string)
+	go func() { inFlight <- serveBody(s) }()
+	<-started
+	require.NoError(t, s.Drain(&Endpoint{Addr: drained.URL}, time.Minute))
+
+	status := s.Configuration().Draining[drained.URL]
+	require.Equal(t, 1, status.InFlight)
+	require.Equal(t, time.Minute, status.Deadline.Sub(status.Started))
+	for range 3 {
+		require.Equal(t, "other", serveBody(s), "a drained endpoint is not selected")
+	}
+	require.Len(t, addresses(t, selector), 2, "the endpoint is kept while requests are in flight")
+
+	close(release)
+	require.Equal(t, "drained", <-inFlight, "requests in flight are finished")
+	require.Eventually(t, func() bool {
+		return len(addresses(t, selector)) == 1 && len(s.Configuration().Draining) == 0
+	}, time.Second, time.Millisecond, "the endpoint is removed when its requests finished")
+}
+
+func TestDrainRemovesEndpointAfterTimeout(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	drained := blockingBackend(t, "drained", started, release)
+	// the request in flight is released before the backend is closed
+	t.Cleanup(func() { close(release) })
+	s, selector := newDrainSlb(t, drained, bodyBackend(t, "other"))
+	routed := &setSelector{}
+	require.NoError(t, s.SetPool(PoolConfig{Name: "api", Endpoints: []*Endpoint{{Addr: drained.URL}}, Selector: routed}))
+
+	go serveBody(s)
+	<-started
+	require.NoError(t, s.Drain(&Endpoint{Addr: drained.URL}, time.Millisecond*50))
+	require.Eventually(t, func() bool {
+		return len(addresses(t, selector)) == 1 && len(addresses(t, routed)) == 0
+	}, time.Second, time.Millisecond, "the endpoint is removed from all pools when the timeout expires")
+}
+
+func TestDrainErrors(t *testing.T) {
+	s, _ := newDrainSlb(t, bodyBackend(t, "ok"))
+	require.ErrorContains(t, s.Drain(&Endpoint{Addr: "http://127.0.0.1:1"}, 0), "not found")
+	require.Error(t, s.Drain(&Endpoint{Addr: "http://127.0.0.1:1"}, -time.Second))
+}
+
+func TestDrainAndStatsAreKeptByIdentity(t *testing.T) {
+	backend := bodyBackend(t, "shared")
+	blue, green := &Endpoint{ID: "blue", Addr: backend.URL}, &Endpoint{ID: "green", Addr: backend.URL}
+	selector := &setSelector{}
+	s, err := New(Config{Endpoints: []*Endpoint{blue, green}, ListenAddress: "localhost"}, selector)
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Stop() })
+
+	require.Equal(t, "shared", serveBody(s))
+	stats := map[string]uint64{}
+	for _, endpoint := range s.Configuration().Endpoints {
+		stats[endpoint.ID] = endpoint.Stats.Requests
+	}
+	require.Equal(t, map[string]uint64{"blue": 1, "green": 0}, stats, "endpoints at the same address have their own stats")
+
+	require.NoError(t, s.Drain(&Endpoint{ID: "blue", Addr: backend.URL}, time.Minute))
+	require.Eventually(t, func() bool {
+		endpoints, _ := selector.EndPoints()
+		return len(endpoints) == 1 && endpoints[0] == green
+	}, time.Second, time.Millisecond, "only the drained endpoint is removed")
+	require.Empty(t, s.Configuration().Draining)
+}
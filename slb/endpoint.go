@@ -0,0 +1,125 @@
+package slb
+
+import (
+	"maps"
+	"net/http"
+	"sync"
+)
+
+// Endpoint is a backend endpoint of the load balancer.
+// Endpoints are identified by their ID, so that a selector finds an endpoint by a new Endpoint with the same ID
+// (e.g to remove it). The ID is the resolved address, if it is not set.
+type Endpoint struct {
+	// Stable identity of the endpoint (default: the resolved Addr)
+	ID string `json:"id,omitempty"`
+	// Address of the endpoint, either a host with an optional port (e.g "10.0.0.5:9000")
+	// or an url with a scheme and an optional base path (e.g "https://backend:8443/api").
+	// It is resolved to an url when the endpoint is added.
+	Addr string `json:"addr"`
+	// Relative weight, for selectors that implement Weighter (default 1).
+	// Other selectors only accept the default weight.
+	Weight int `json:"weight,omitempty"`
+	// Zone of the endpoint (e.g the availability zone)
+	Zone string `json:"zone,omitempty"`
+	// Labels of the endpoint, discovered endpoints are labeled with their metadata
+	Labels map[string]string `json:"labels,omitempty"`
+	// Health state of the endpoint (set by Slb.Configuration)
+	Health *HealthStatus `json:"health,omitempty"`
+	// Statistics of the requests to the endpoint (set by Slb.Configuration)
+	Stats *EndpointStats `json:"stats,omitempty"`
+	// Handler of the requests to the endpoint, the SLB sets a reverse proxy to Addr when it is added
+	Handler http.Handler `json:"-"`
+}
+
+// Returns the identity of the endpoint: its ID, or its address if no ID is set
+func (e *Endpoint) Identity() string {
+	if e.ID != "" {
+		return e.ID
+	}
+	return e.Addr
+}
+
+// Returns true if e and other are the same endpoint, by their identity
+func (e *Endpoint) Is(other *Endpoint) bool {
+	return e == other || e.Identity() == other.Identity()
+}
+
+// validates the address and the weight of server
+func validateEndpoint(server *Endpoint, listenPort string) error {
+	if _, err := endpointURL(server.Addr, listenPort); err != nil {
+		return ErrInvalidEndpoint(server.Addr, err)
+	}
+	if server.Weight < 0 {
+		return ErrInvalidWeight(server.Addr, server.Weight)
+	}
+	return nil
+}
+
+// returns a copy of the endpoint, with its own labels
+func (e *Endpoint) clone() *Endpoint {
+	clone := *e
+	clone.Labels = maps.Clone(e.Labels)
+	return &clone
+}
+
+// EndpointStats are the statistics of the requests proxied to an endpoint
+type EndpointStats struct {
+	// Proxied requests (every attempt of a retried request is counted)
+	Requests uint64 `json:"requests"`
+	// Requests that failed with a 5xx response, or could not reach the endpoint
+	Failures uint64 `json:"failures"`
+	// Requests that are in flight
+	InFlight int `json:"inFlight"`
+}
+
+// endpointStats counts the requests by endpoint identity.
+// A nil endpointStats counts nothing.
+type endpointStats struct {
+	mu    sync.Mutex
+	stats map[string]*EndpointStats
+}
+
+func newEndpointStats() *endpointStats {
+	return &endpointStats{stats: make(map[string]*EndpointStats)}
+}
+
+// Records the outcome of a request to the endpoint id
+func (e *endpointStats) Record(id string, outcome Outcome) {
+	if e == nil {
+		return
+	}
+	defer e.mu.Unlock()
+	e.mu.Lock()
+	stats, ok := e.stats[id]
+	if !ok {
+		stats = &EndpointStats{}
+		e.stats[id] = stats
+	}
+	stats.Requests++
+	if outcome.StatusCode >= http.StatusInternalServerError {
+		stats.Failures++
+	}
+}
+
+// Returns the statistics of the endpoint id
+func (e *endpointStats) Get(id string) EndpointStats {
+	if e == nil {
+		return EndpointStats{}
+	}
+	defer e.mu.Unlock()
+	e.mu.Lock()
+	if stats, ok := e.stats[id]; ok {
+		return *stats
+	}
+	return EndpointStats{}
+}
+
+// Forgets the statistics of the endpoint id (e.g when it is removed)
+func (e *endpointStats) Forget(id string) {
+	if e == nil {
+		return
+	}
+	defer e.mu.Unlock()
+	e.mu.Lock()
+	delete(e.stats, id)
+}
@@ -0,0 +1,191 @@
+package slb
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	htmlTemplate "html/template"
+	"io"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	textTemplate "text/template"
+	"time"
+)
+
+// ErrorKind is the reason a request could not be proxied
+type ErrorKind string
+
+const (
+	// The selected pool has no endpoints
+	ErrorNoEndpoints ErrorKind = "noEndpoints"
+	// No endpoint of the selected pool is healthy and not ejected
+	ErrorAllUnhealthy ErrorKind = "allUnhealthy"
+	// The endpoint did not respond within the upstream timeout
+	ErrorUpstreamTimeout ErrorKind = "upstreamTimeout"
+	// The connection to the endpoint failed (e.g it was refused)
+	ErrorConnectionRefused ErrorKind = "connectionRefused"
+	// Any other error while proxying the request
+	ErrorUpstream ErrorKind = "upstream"
+
+	DefaultErrorContentType = "text/plain; charset=utf-8"
+)
+
+var (
+	ErrInvalidErrorResponse = func(kind ErrorKind, reason string) error {
+		return fmt.Errorf("invalid error response for %q: %s", kind, reason)
+	}
+)
+
+// default status codes of the error responses
+var errorStatusCodes = map[ErrorKind]int{
+	ErrorNoEndpoints:       http.StatusServiceUnavailable,
+	ErrorAllUnhealthy:      http.StatusServiceUnavailable,
+	ErrorUpstreamTimeout:   http.StatusGatewayTimeout,
+	ErrorConnectionRefused: http.StatusBadGateway,
+	ErrorUpstream:          http.StatusBadGateway,
+}
+
+// ErrorResponseConfig configures the response to a client, when its request could not be proxied.
+// Unset fields fall back to the default response of the ErrorKind: its status code with the status text as body.
+type ErrorResponseConfig struct {
+	StatusCode int `json:"statusCode,omitempty"`
+	// Content type of the body, the body is an html template if it is "text/html"
+	ContentType string `json:"contentType,omitempty"`
+	// Go template of the body, that is executed with ErrorResponseData (e.g `{"error": {{json .Error}}}`)
+	Body string `json:"body,omitempty"`
+	// Value of the Retry-After header (in seconds), it is not set if 0
+	RetryAfter time.Duration `json:"retryAfter,omitempty"`
+}
+
+// ErrorResponseData is the data the body template of an error response is executed with
+type ErrorResponseData struct {
+	Kind       ErrorKind
+	StatusCode int
+	// Status text of the status code
+	Status string
+	// The error that occurred
+	Error string
+}
+
+// Validates the error response of kind
+func (e ErrorResponseConfig) Validate(kind ErrorKind) error {
+	if _, ok := errorStatusCodes[kind]; !ok {
+		return ErrInvalidErrorResponse(kind, "unknown error kind")
+	}
+	if e.StatusCode != 0 && (e.StatusCode < 400 || e.StatusCode > 599) {
+		return ErrInvalidErrorResponse(kind, "status code must be between 400 and 599")
+	}
+	if e.RetryAfter < 0 {
+		return ErrInvalidErrorResponse(kind, "retry after must not be negative")
+	}
+	if _, err := e.template(); err != nil {
+		return ErrInvalidErrorResponse(kind, err.Error())
+	}
+	return nil
+}
+
+// returns a copy of the configuration with the defaults of kind for unset fields
+func (e ErrorResponseConfig) withDefaults(kind ErrorKind) ErrorResponseConfig {
+	if e.StatusCode == 0 {
+		e.StatusCode = errorStatusCodes[kind]
+	}
+	if e.ContentType == "" {
+		e.ContentType = DefaultErrorContentType
+	}
+	return e
+}
+
+type bodyTemplate interface {
+	Execute(io.Writer, any) error
+}
+
+var templateFuncs = map[string]any{
+	// json encodes a value, e.g to quote strings in json bodies
+	"json": func(v any) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}
+
+// parses the body template, it is nil if no body is configured
+func (e ErrorResponseConfig) template() (bodyTemplate, error) {
+	if e.Body == "" {
+		return nil, nil
+	}
+	if strings.HasPrefix(e.ContentType, "text/html") {
+		return htmlTemplate.New("body").Funcs(templateFuncs).Parse(e.Body)
+	}
+	return textTemplate.New("body").Funcs(templateFuncs).Parse(e.Body)
+}
+
+type errorResponse struct {
+	cfg  ErrorResponseConfig
+	body bodyTemplate
+}
+
+// errorResponder writes the configured error responses
+type errorResponder struct {
+	responses map[ErrorKind]errorResponse
+}
+
+func newErrorResponder(cfg map[ErrorKind]ErrorResponseConfig) *errorResponder {
+	e := &errorResponder{responses: make(map[ErrorKind]errorResponse, len(errorStatusCodes))}
+	for kind := range errorStatusCodes {
+		response := errorResponse{cfg: cfg[kind].withDefaults(kind)}
+		body, err := response.cfg.template()
+		if err != nil {
+			slog.Error(ErrInvalidErrorResponse(kind, err.Error()).Error())
+		}
+		response.body = body
+		e.responses[kind] = response
+	}
+	return e
+}
+
+// Writes the error response of kind for err to rw
+func (e *errorResponder) Write(rw http.ResponseWriter, kind ErrorKind, err error) {
+	response, ok := e.responses[kind]
+	if !ok {
+		response = e.responses[ErrorUpstream]
+	}
+	status := response.cfg.StatusCode
+	body := []byte(http.StatusText(status) + "\n")
+	if response.body != nil {
+		data := ErrorResponseData{Kind: kind, StatusCode: status, Status: http.StatusText(status)}
+		if err != nil {
+			data.Error = err.Error()
+		}
+		buf := &bytes.Buffer{}
+		if err := response.body.Execute(buf, data); err != nil {
+			slog.Error(fmt.Sprintf("failed to execute the error response template of %q: %s", kind, err))
+		} else {
+			body = buf.Bytes()
+		}
+	}
+
+	rw.Header().Set("Content-Type", response.cfg.ContentType)
+	rw.Header().Set("X-Content-Type-Options", "nosniff")
+	if response.cfg.RetryAfter > 0 {
+		rw.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(response.cfg.RetryAfter.Seconds()))))
+	}
+	rw.WriteHeader(status)
+	rw.Write(body)
+}
+
+// returns the kind of an error of the reverse proxy
+func proxyErrorKind(err error) ErrorKind {
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return ErrorUpstreamTimeout
+	}
+	if connectError(err) {
+		return ErrorConnectionRefused
+	}
+	return ErrorUpstream
+}
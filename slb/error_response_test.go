@@ -0,0 +1,136 @@
+package slb
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestErrorResponseConfigValidate(t *testing.T) {
+	require.NoError(t, ErrorResponseConfig{}.Validate(ErrorNoEndpoints))
+	require.NoError(t, ErrorResponseConfig{StatusCode: 500, Body: "{{.Status}}", RetryAfter: time.Second}.Validate(ErrorUpstream))
+	require.Error(t, ErrorResponseConfig{}.Validate("unknown"))
+	require.Error(t, ErrorResponseConfig{StatusCode: 200}.Validate(ErrorNoEndpoints))
+	require.Error(t, ErrorResponseConfig{RetryAfter: -time.Second}.Validate(ErrorNoEndpoints))
+	require.Error(t, ErrorResponseConfig{Body: "{{.Status"}.Validate(ErrorNoEndpoints))
+
+	cfg := Config{
+		Endpoints:      []*Endpoint{{Addr: "localhost"}},
+		ErrorResponses: map[ErrorKind]ErrorResponseConfig{ErrorAllUnhealthy: {StatusCode: 200}},
+	}
+	require.Error(t, cfg.Validate())
+	cfg = Config{Endpoints: []*Endpoint{{Addr: "localhost"}}, UpstreamTimeout: -time.Second}
+	require.ErrorContains(t, cfg.Validate(), ErrInvalidUpstreamTimeout().Error())
+}
+
+func TestServeHTTPErrorResponses(t *testing.T) {
+	slow := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(time.Second):
+		}
+	}))
+	t.Cleanup(slow.Close)
+	hangingUp := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if conn, _, err := http.NewResponseController(rw).Hijack(); err == nil {
+			conn.Close()
+		}
+	}))
+	t.Cleanup(hangingUp.Close)
+	ok := echoBackend(t)
+
+	responses := map[ErrorKind]ErrorResponseConfig{
+		ErrorNoEndpoints: {
+			StatusCode:  http.StatusServiceUnavailable,
+			ContentType: "application/json",
+			Body:        `{"kind": {{json .Kind}}, "error": {{json .Error}}}`,
+			RetryAfter:  time.Millisecond * 1500,
+		},
+		ErrorAllUnhealthy: {
+			ContentType: "text/html; charset=utf-8",
+			Body:        "<h1>{{.StatusCode}} {{.Error}}</h1>",
+		},
+	}
+	type errorTest struct {
+		name        string
+		backend     *httptest.Server
+		unhealthy   bool
+		status      int
+		contentType string
+		body        string
+		retryAfter  string
+	}
+	scenarios := []errorTest{
+		{
+			name:        "No endpoints",
+			status:      http.StatusServiceUnavailable,
+			contentType: "application/json",
+			body:        `{"kind": "noEndpoints", "error": "selector has no endpoints to select"}`,
+			retryAfter:  "2",
+		},
+		{
+			name:        "All unhealthy",
+			backend:     ok,
+			unhealthy:   true,
+			status:      http.StatusServiceUnavailable,
+			contentType: "text/html; charset=utf-8",
+			body:        "<h1>503 " + ErrNoHealthyEndpoints().Error() + "</h1>",
+		},
+		{
+			name:        "Upstream timeout",
+			backend:     slow,
+			status:      http.StatusGatewayTimeout,
+			contentType: DefaultErrorContentType,
+			body:        http.StatusText(http.StatusGatewayTimeout) + "\n",
+		},
+		{
+			name:        "Connection refused",
+			backend:     refusingBackend(),
+			status:      http.StatusBadGateway,
+			contentType: DefaultErrorContentType,
+			body:        http.StatusText(http.StatusBadGateway) + "\n",
+		},
+		{
+			name:        "Upstream error",
+			backend:     hangingUp,
+			status:      http.StatusBadGateway,
+			contentType: DefaultErrorContentType,
+			body:        http.StatusText(http.StatusBadGateway) + "\n",
+		},
+	}
+	for _, scenario := range scenarios {
+		t.Run(scenario.name, func(t *testing.T) {
+			backends := []*httptest.Server{}
+			if scenario.backend != nil {
+				backends = append(backends, scenario.backend)
+			}
+			s, _ := newRetrySlb(t, RetryPolicyConfig{}, backends...)
+			s.cfg.UpstreamTimeout = time.Millisecond * 50
+			s.errors = newErrorResponder(responses)
+			if scenario.unhealthy {
+				s.health = newHealthChecker(HealthCheckConfig{Path: "/health", UnhealthyThreshold: 1}, "", s.selector.EndPoints)
+				s.health.record(s.selector.(*trackerMock).endpoints[0].Addr, fmt.Errorf("probe failed"))
+			}
+
+			rw := httptest.NewRecorder()
+			s.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "/", nil))
+			require.Equal(t, scenario.status, rw.Code)
+			require.Equal(t, scenario.contentType, rw.Header().Get("Content-Type"))
+			require.Equal(t, scenario.body, rw.Body.String())
+			require.Equal(t, scenario.retryAfter, rw.Header().Get("Retry-After"))
+		})
+	}
+}
+
+func TestErrorResponseHtmlIsEscaped(t *testing.T) {
+	errors := newErrorResponder(map[ErrorKind]ErrorResponseConfig{
+		ErrorUpstream: {ContentType: "text/html", Body: "<p>{{.Error}}</p>"},
+	})
+	rw := httptest.NewRecorder()
+	errors.Write(rw, ErrorUpstream, fmt.Errorf("<script>"))
+	require.Equal(t, "<p>&lt;script&gt;</p>", rw.Body.String())
+}
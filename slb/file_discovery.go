@@ -0,0 +1,130 @@
+package slb
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const DefaultFileDiscoveryReloadInterval = time.Second * 5
+
+var (
+	ErrInvalidFileDiscovery = func(reason string) error { return fmt.Errorf("invalid file discovery configuration: %s", reason) }
+	ErrInvalidDiscoveryFile = func(path string, reason string) error {
+		return fmt.Errorf("invalid discovery file %s: %s", path, reason)
+	}
+)
+
+// FileDiscoveryConfig discovers the endpoints of a pool from a JSON or YAML file, e.g
+//
+//	endpoints:
+//	  - address: 10.0.0.5:9000
+//	    weight: 2
+//	    metadata:
+//	      zone: a
+//	  - address: https://backend:8443
+//
+// The file is reloaded when it changes. Invalid files, and files without endpoints, are rejected
+// and the endpoints of the last valid file are kept.
+// File discovery is disabled if no Path is provided.
+type FileDiscoveryConfig struct {
+	// Path of the file, it is parsed as JSON if it has the .json extension and as YAML otherwise
+	Path string `json:"path,omitempty"`
+	// Interval in which the file is checked for changes (default 5s)
+	ReloadInterval time.Duration `json:"reloadInterval,omitempty"`
+}
+
+// Returns true if a path is configured
+func (f FileDiscoveryConfig) Enabled() bool {
+	return f.Path != ""
+}
+
+// Validates the file discovery configuration, the file is loaded by New
+func (f FileDiscoveryConfig) Validate() error {
+	if !f.Enabled() {
+		return nil
+	}
+	if f.ReloadInterval < 0 {
+		return ErrInvalidFileDiscovery("reload interval must not be negative")
+	}
+	return nil
+}
+
+// endpointsFile is the content of a discovery file
+type endpointsFile struct {
+	Endpoints []discoveredEndpoint `json:"endpoints" yaml:"endpoints"`
+}
+
+// fileDiscoverer discovers the endpoints of a file, which is only loaded again if it changed
+type fileDiscoverer struct {
+	cfg        FileDiscoveryConfig
+	listenPort string
+	// modification time and size of the file, and the result when it was last loaded
+	modTime   time.Time
+	size      int64
+	endpoints []discoveredEndpoint
+	err       error
+}
+
+func newFileDiscoverer(cfg FileDiscoveryConfig, listenPort string) *fileDiscoverer {
+	if cfg.ReloadInterval == 0 {
+		cfg.ReloadInterval = DefaultFileDiscoveryReloadInterval
+	}
+	return &fileDiscoverer{cfg: cfg, listenPort: listenPort}
+}
+
+func (f *fileDiscoverer) discover(context.Context) ([]discoveredEndpoint, time.Duration, error) {
+	info, err := os.Stat(f.cfg.Path)
+	if err != nil {
+		return nil, f.cfg.ReloadInterval, ErrInvalidDiscoveryFile(f.cfg.Path, err.Error())
+	}
+	if !info.ModTime().Equal(f.modTime) || info.Size() != f.size || (f.endpoints == nil && f.err == nil) {
+		f.modTime, f.size = info.ModTime(), info.Size()
+		f.endpoints, f.err = f.load()
+	}
+	return f.endpoints, f.cfg.ReloadInterval, f.err
+}
+
+// loads and validates the endpoints of the file
+func (f *fileDiscoverer) load() ([]discoveredEndpoint, error) {
+	content, err := os.ReadFile(f.cfg.Path)
+	if err != nil {
+		return nil, ErrInvalidDiscoveryFile(f.cfg.Path, err.Error())
+	}
+	var file endpointsFile
+	if filepath.Ext(f.cfg.Path) == ".json" {
+		decoder := json.NewDecoder(bytes.NewReader(content))
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(&file)
+	} else {
+		decoder := yaml.NewDecoder(bytes.NewReader(content))
+		decoder.KnownFields(true)
+		err = decoder.Decode(&file)
+	}
+	if err != nil {
+		return nil, ErrInvalidDiscoveryFile(f.cfg.Path, err.Error())
+	}
+	if len(file.Endpoints) == 0 {
+		return nil, ErrInvalidDiscoveryFile(f.cfg.Path, "no endpoints")
+	}
+	addresses := make(map[string]bool, len(file.Endpoints))
+	for _, endpoint := range file.Endpoints {
+		if _, err := endpointURL(endpoint.Address, f.listenPort); err != nil {
+			return nil, ErrInvalidDiscoveryFile(f.cfg.Path, ErrInvalidEndpoint(endpoint.Address, err).Error())
+		}
+		if endpoint.Weight < 0 {
+			return nil, ErrInvalidDiscoveryFile(f.cfg.Path, ErrInvalidWeight(endpoint.Address, endpoint.Weight).Error())
+		}
+		if addresses[endpoint.Address] {
+			return nil, ErrInvalidDiscoveryFile(f.cfg.Path, fmt.Sprintf("endpoint %q is not unique", endpoint.Address))
+		}
+		addresses[endpoint.Address] = true
+	}
+	return file.Endpoints, nil
+}
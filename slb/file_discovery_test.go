@@ -0,0 +1,244 @@
+package slb
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// weightedSetSelector keeps the added endpoints and their weights
+type weightedSetSelector struct {
+	setSelector
+	weightsMu sync.Mutex
+	weights   map[string]int
+}
+
+func (s *weightedSetSelector) SetWeight(server *Endpoint, weight int) error {
+	defer s.weightsMu.Unlock()
+	s.weightsMu.Lock()
+	if s.weights == nil {
+		s.weights = make(map[string]int)
+	}
+	s.weights[server.Addr] = weight
+	return nil
+}
+
+func (s *weightedSetSelector) Weight(server *Endpoint) (int, error) {
+	defer s.weightsMu.Unlock()
+	s.weightsMu.Lock()
+	if weight, ok := s.weights[server.Addr]; ok {
+		return weight, nil
+	}
+	return 1, nil
+}
+
+// writes content to path, with a modification time that differs from the previous write
+func writeDiscoveryFile(t *testing.T, path string, content string) {
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	modTime := time.Now().Add(time.Duration(len(content)) * time.Second)
+	require.NoError(t, os.Chtimes(path, modTime, modTime))
+}
+
+func TestFileDiscoveryConfigValidate(t *testing.T) {
+	require.NoError(t, FileDiscoveryConfig{}.Validate())
+	require.NoError(t, FileDiscoveryConfig{Path: "endpoints.yaml", ReloadInterval: time.Second}.Validate())
+	require.Error(t, FileDiscoveryConfig{Path: "endpoints.yaml", ReloadInterval: -time.Second}.Validate())
+	require.Error(t, DiscoveryConfig{
+		DNS:  DNSDiscoveryConfig{Name: "backend.internal"},
+		File: FileDiscoveryConfig{Path: "endpoints.yaml"},
+	}.Validate(), "only one source can be configured")
+}
+
+func TestFileDiscoverer(t *testing.T) {
+	type fileDiscovererTest struct {
+		name     string
+		file     string
+		content  string
+		expected []discoveredEndpoint
+		err      string
+	}
+	scenarios := []fileDiscovererTest{
+		{
+			name: "yaml",
+			file: "endpoints.yaml",
+			content: `
+endpoints:
+  - address: 127.0.0.1:9000
+    weight: 2
+    metadata:
+      zone: a
+  - address: https://backend:8443/api
+`,
+			expected: []discoveredEndpoint{
+				{Address: "127.0.0.1:9000", Weight: 2, Metadata: map[string]string{"zone": "a"}},
+				{Address: "https://backend:8443/api"},
+			},
+		},
+		{
+			name:     "json",
+			file:     "endpoints.json",
+			content:  `{"endpoints": [{"address": "127.0.0.1:9000", "weight": 3}]}`,
+			expected: []discoveredEndpoint{{Address: "127.0.0.1:9000", Weight: 3}},
+		},
+		{
+			name:    "invalid yaml",
+			file:    "endpoints.yml",
+			content: "endpoints: [",
+			err:     "invalid discovery file",
+		},
+		{
+			name:    "unknown field",
+			file:    "endpoints.json",
+			content: `{"endpoints": [{"addr": "127.0.0.1:9000"}]}`,
+			err:     "unknown field",
+		},
+		{
+			name:    "invalid address",
+			file:    "endpoints.yaml",
+			content: "endpoints:\n  - address: ftp://backend\n",
+			err:     `"ftp://backend"`,
+		},
+		{
+			name:    "negative weight",
+			file:    "endpoints.yaml",
+			content: "endpoints:\n  - address: 127.0.0.1:9000\n    weight: -1\n",
+			err:     "invalid weight",
+		},
+		{
+			name:    "duplicate address",
+			file:    "endpoints.yaml",
+			content: "endpoints:\n  - address: 127.0.0.1:9000\n  - address: 127.0.0.1:9000\n",
+			err:     "not unique",
+		},
+		{
+			name:    "no endpoints",
+			file:    "endpoints.yaml",
+			content: "endpoints: []\n",
+			err:     "no endpoints",
+		},
+	}
+	for _, scenario := range scenarios {
+		t.Run(scenario.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), scenario.file)
+			writeDiscoveryFile(t, path, scenario.content)
+			endpoints, next, err := newFileDiscoverer(FileDiscoveryConfig{Path: path}, "8080").discover(context.Background())
+			require.Equal(t, DefaultFileDiscoveryReloadInterval, next)
+			if scenario.err != "" {
+				require.ErrorContains(t, err, scenario.err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, scenario.expected, endpoints)
+		})
+	}
+
+	_, _, err := newFileDiscoverer(FileDiscoveryConfig{Path: filepath.Join(t.TempDir(), "missing.yaml")}, "").discover(context.Background())
+	require.Error(t, err)
+}
+
+// returns the weights of endpoints by address
+func endpointWeights(endpoints []*Endpoint) map[string]int {
+	weights := map[string]int{}
+	for _, endpoint := range endpoints {
+		weights[endpoint.Addr] = endpoint.Weight
+	}
+	return weights
+}
+
+// returns the labels of the labeled endpoints by address
+func endpointLabels(endpoints []*Endpoint) map[string]map[string]string {
+	labels := map[string]map[string]string{}
+	for _, endpoint := range endpoints {
+		if len(endpoint.Labels) > 0 {
+			labels[endpoint.Addr] = endpoint.Labels
+		}
+	}
+	return labels
+}
+
+func TestFileDiscoveryReloadsChangedFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "endpoints.yaml")
+	writeDiscoveryFile(t, path, `
+endpoints:
+  - address: 127.0.0.1:9001
+    weight: 2
+    metadata:
+      zone: a
+  - address: 127.0.0.1:9002
+`)
+	selector := &weightedSetSelector{}
+	s, err := New(Config{
+		ListenAddress: "localhost",
+		Discovery:     DiscoveryConfig{File: FileDiscoveryConfig{Path: path}},
+	}, selector)
+	require.NoError(t, err)
+	require.Equal(t, []string{"http://127.0.0.1:9001", "http://127.0.0.1:9002"}, addresses(t, selector))
+	cfg := s.Configuration()
+	require.Equal(t, map[string]int{"http://127.0.0.1:9001": 2, "http://127.0.0.1:9002": 1}, endpointWeights(cfg.Endpoints))
+	require.Equal(t, map[string]map[string]string{"http://127.0.0.1:9001": {"zone": "a"}}, endpointLabels(cfg.Endpoints))
+	require.Empty(t, cfg.DiscoveryStatus[DefaultPoolName].LastError)
+	require.False(t, cfg.DiscoveryStatus[DefaultPoolName].LastRefresh.IsZero())
+
+	writeDiscoveryFile(t, path, `
+endpoints:
+  - address: 127.0.0.1:9001
+    weight: 5
+  - address: 127.0.0.1:9003
+`)
+	require.NoError(t, s.discovery.pools[DefaultPoolName].refresh())
+	require.Equal(t, []string{"http://127.0.0.1:9001", "http://127.0.0.1:9003"}, addresses(t, selector))
+	cfg = s.Configuration()
+	require.Equal(t, 5, endpointWeights(cfg.Endpoints)["http://127.0.0.1:9001"], "the weights of the file are applied")
+	require.Empty(t, endpointLabels(cfg.Endpoints))
+
+	writeDiscoveryFile(t, path, "endpoints:\n  - address: 127.0.0.1:9001\n  - address: 127.0.0.1:9003\n")
+	require.NoError(t, s.discovery.pools[DefaultPoolName].refresh())
+	require.Equal(t, 1, endpointWeights(s.Configuration().Endpoints)["http://127.0.0.1:9001"], "removed weights are reset")
+
+	writeDiscoveryFile(t, path, "endpoints:\n  - address: 127.0.0.1:70000\n")
+	require.Error(t, s.discovery.pools[DefaultPoolName].refresh())
+	require.Equal(t, []string{"http://127.0.0.1:9001", "http://127.0.0.1:9003"}, addresses(t, selector),
+		"the last valid endpoints are kept")
+	require.Contains(t, s.Configuration().DiscoveryStatus[DefaultPoolName].LastError, "127.0.0.1:70000")
+
+	_, err = New(Config{
+		ListenAddress: "localhost",
+		Discovery:     DiscoveryConfig{File: FileDiscoveryConfig{Path: path}},
+	}, &weightedSetSelector{})
+	require.Error(t, err, "invalid files are rejected by New")
+}
+
+func TestFileDiscoverySkipsConfiguredEndpoints(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "endpoints.yaml")
+	writeDiscoveryFile(t, path, `
+endpoints:
+  - address: 127.0.0.1:9001
+    weight: 2
+  - address: 127.0.0.1:9002
+`)
+	selector := &weightedSetSelector{}
+	configured := &Endpoint{Addr: "127.0.0.1:9001"}
+	s, err := New(Config{
+		ListenAddress: "localhost",
+		Endpoints:     []*Endpoint{configured},
+		Discovery:     DiscoveryConfig{File: FileDiscoveryConfig{Path: path}},
+	}, selector)
+	require.NoError(t, err)
+	require.Equal(t, []string{"http://127.0.0.1:9001", "http://127.0.0.1:9002"}, addresses(t, selector))
+	endpoints, err := selector.EndPoints()
+	require.NoError(t, err)
+	require.Same(t, configured, endpoints[0], "the configured endpoint is not replaced")
+	require.Equal(t, 1, endpointWeights(s.Configuration().Endpoints)["http://127.0.0.1:9001"], "the discovered weight is not applied")
+
+	writeDiscoveryFile(t, path, "endpoints:\n  - address: 127.0.0.1:9002\n")
+	require.NoError(t, s.discovery.pools[DefaultPoolName].refresh())
+	require.Equal(t, []string{"http://127.0.0.1:9001", "http://127.0.0.1:9002"}, addresses(t, selector), "the configured endpoint is not removed")
+	endpoints, err = selector.EndPoints()
+	require.NoError(t, err)
+	require.Same(t, configured, endpoints[0])
+}
@@ -0,0 +1,160 @@
+package slb
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+)
+
+// frontend is the listener of the proxied requests, it serves them with its current Slb.
+// A reconfigured SLB replaces the current Slb, so that the listener is kept open.
+type frontend struct {
+	server    *http.Server
+	current   atomic.Pointer[Slb]
+	listening atomic.Bool
+	// closed once the server accepts connections
+	serving     chan struct{}
+	servingOnce sync.Once
+}
+
+func newFrontend(s *Slb) *frontend {
+	f := &frontend{serving: make(chan struct{})}
+	f.current.Store(s)
+	f.server = &http.Server{Addr: s.cfg.Address(), Handler: f}
+	return f
+}
+
+// Serves the request with the current Slb, which finishes it even if it is replaced meanwhile
+func (f *frontend) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	for {
+		s := f.current.Load()
+		s.requests.begin()
+		if f.current.Load() != s {
+			// s was replaced before the request started, and may be drained already
+			s.requests.end()
+			continue
+		}
+		defer s.requests.end()
+		s.serveMux.ServeHTTP(rw, r)
+		return
+	}
+}
+
+// requestsInFlight counts the requests in flight of an Slb, so that a replaced Slb can wait for them to finish
+type requestsInFlight struct {
+	mu    sync.Mutex
+	count int
+	// closed once no requests are in flight, it is created by drained
+	idle chan struct{}
+}
+
+func (r *requestsInFlight) begin() {
+	defer r.mu.Unlock()
+	r.mu.Lock()
+	r.count++
+}
+
+func (r *requestsInFlight) end() {
+	defer r.mu.Unlock()
+	r.mu.Lock()
+	r.count--
+	if r.count == 0 && r.idle != nil {
+		close(r.idle)
+		r.idle = nil
+	}
+}
+
+// Returns a channel that is closed once no requests are in flight
+func (r *requestsInFlight) drained() <-chan struct{} {
+	defer r.mu.Unlock()
+	r.mu.Lock()
+	if r.count == 0 {
+		idle := make(chan struct{})
+		close(idle)
+		return idle
+	}
+	if r.idle == nil {
+		r.idle = make(chan struct{})
+	}
+	return r.idle
+}
+
+// returns the tls configuration of the current Slb for a client hello,
+// so that its settings apply to new connections without listening again
+func (f *frontend) configForClient(*tls.ClientHelloInfo) (*tls.Config, error) {
+	s := f.current.Load()
+	if s.certificates == nil {
+		return nil, fmt.Errorf("tls is disabled")
+	}
+	config := s.certificates.serverConfig()
+	config.NextProtos = []string{"h2", "http/1.1"}
+	return config, nil
+}
+
+// returns true if the listener can serve cfg, i.e its address is unchanged.
+// The tls settings and certificates can change, they are taken from the current Slb.
+func (f *frontend) accepts(cfg Config) bool {
+	return f.server.Addr == cfg.Address()
+}
+
+// binds the listen address
+func (f *frontend) listen() (net.Listener, error) {
+	ln, err := net.Listen("tcp", f.server.Addr)
+	if err != nil {
+		return nil, err
+	}
+	f.listening.Store(true)
+	return ln, nil
+}
+
+// serves the requests of ln until the server is shut down
+func (f *frontend) serve(ln net.Listener) error {
+	defer f.listening.Store(false)
+	return f.server.Serve(&frontendListener{
+		Listener: ln,
+		frontend: f,
+		config:   &tls.Config{GetConfigForClient: f.configForClient},
+	})
+}
+
+// listens and serves the requests in the background. It returns once the server accepts connections,
+// or the error if it could not listen or serve. Errors after that are logged.
+func (f *frontend) serveInBackground() error {
+	ln, err := f.listen()
+	if err != nil {
+		return err
+	}
+	served := make(chan error, 1)
+	go func() { served <- f.serve(ln) }()
+	select {
+	case err := <-served:
+		return err
+	case <-f.serving:
+	}
+	go func() {
+		if err := <-served; err != nil {
+			slog.Error(err.Error())
+		}
+	}()
+	return nil
+}
+
+// frontendListener terminates tls on the accepted connections, if the current Slb has tls enabled
+type frontendListener struct {
+	net.Listener
+	frontend *frontend
+	config   *tls.Config
+}
+
+func (l *frontendListener) Accept() (net.Conn, error) {
+	l.frontend.servingOnce.Do(func() { close(l.frontend.serving) })
+	conn, err := l.Listener.Accept()
+	if err != nil || l.frontend.current.Load().certificates == nil {
+		return conn, err
+	}
+	return tls.Server(conn, l.config), nil
+}
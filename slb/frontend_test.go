@@ -0,0 +1,311 @@
+package slb
+
+import (
+	"balance/internal/mock"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+)
+
+// returns a backend that responds with body, after release is closed
+func blockingBackend(t *testing.T, body string, started chan<- struct{}, release <-chan struct{}) *httptest.Server {
+	backend := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-release
+		rw.Write([]byte(body))
+	}))
+	t.Cleanup(backend.Close)
+	return backend
+}
+
+// returns a backend that responds with body
+func bodyBackend(t *testing.T, body string) *httptest.Server {
+	backend := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.Write([]byte(body))
+	}))
+	t.Cleanup(backend.Close)
+	return backend
+}
+
+// returns a new SLB on port that proxies to backend
+func newFrontendSlb(t *testing.T, port string, backend *httptest.Server) *Slb {
+	s, err := New(Config{
+		Endpoints:     []*Endpoint{{Addr: backend.URL}},
+		ListenAddress: "localhost",
+		ListenPort:    port,
+	}, &setSelector{})
+	require.NoError(t, err)
+	return s
+}
+
+// runs s, and waits until it serves requests
+func runSlb(t *testing.T, s *Slb) {
+	go s.Run()
+	require.Eventually(t, s.Running, time.Second, time.Millisecond)
+}
+
+// returns the body of a get request to port
+func get(port string) (string, error) {
+	resp, err := http.Get("http://localhost:" + port + "/")
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	return string(body), err
+}
+
+func TestReplaceOnTheSameListener(t *testing.T) {
+	port := mock.RandomPort()
+	started, release := make(chan struct{}), make(chan struct{})
+	s := newFrontendSlb(t, port, blockingBackend(t, "old", started, release))
+	runSlb(t, s)
+	next := newFrontendSlb(t, port, bodyBackend(t, "new"))
+	t.Cleanup(func() { next.Stop() })
+
+	inFlight := make(chan string)
+	go func() {
+		body, _ := get(port)
+		inFlight <- body
+	}()
+	<-started
+	replaced := make(chan error)
+	go func() { replaced <- s.Replace(next) }()
+
+	require.Eventually(t, func() bool {
+		body, err := get(port)
+		return err == nil && body == "new"
+	}, time.Second, time.Millisecond, "new requests are served by the next SLB")
+	require.True(t, next.Running())
+	require.False(t, s.Running())
+	select {
+	case <-replaced:
+		require.Fail(t, "the replaced SLB waits for its requests in flight")
+	default:
+	}
+
+	close(release)
+	require.Equal(t, "old", <-inFlight, "requests in flight are finished by the replaced SLB")
+	require.NoError(t, <-replaced)
+	body, err := get(port)
+	require.NoError(t, err)
+	require.Equal(t, "new", body, "the listener is not stopped by the replaced SLB")
+}
+
+func TestReplaceOnAnotherListener(t *testing.T) {
+	port, nextPort := mock.RandomPort(), mock.RandomPort()
+	started, release := make(chan struct{}), make(chan struct{})
+	s := newFrontendSlb(t, port, blockingBackend(t, "old", started, release))
+	runSlb(t, s)
+
+	occupied, err := net.Listen("tcp", "localhost:"+nextPort)
+	require.NoError(t, err)
+	require.Error(t, s.Replace(newFrontendSlb(t, nextPort, bodyBackend(t, "new"))))
+	require.True(t, s.Running(), "the SLB keeps running if the next SLB cannot listen")
+	occupied.Close()
+
+	inFlight := make(chan string)
+	go func() {
+		body, _ := get(port)
+		inFlight <- body
+	}()
+	<-started
+	next := newFrontendSlb(t, nextPort, bodyBackend(t, "new"))
+	t.Cleanup(func() { next.Stop() })
+	replaced := make(chan error)
+	go func() { replaced <- s.Replace(next) }()
+	require.Eventually(t, func() bool {
+		body, err := get(nextPort)
+		return err == nil && body == "new"
+	}, time.Second, time.Millisecond, "the next SLB listens before the requests in flight are finished")
+
+	close(release)
+	require.Equal(t, "old", <-inFlight)
+	require.NoError(t, <-replaced)
+	_, err = get(port)
+	require.Error(t, err, "the replaced SLB stops listening")
+}
+
+func TestReplaceStopsSlbThatIsNotRunning(t *testing.T) {
+	port := mock.RandomPort()
+	s := newFrontendSlb(t, port, bodyBackend(t, "old"))
+	next := newFrontendSlb(t, port, bodyBackend(t, "new"))
+	require.NoError(t, s.Replace(next))
+	require.False(t, next.Running(), "the next SLB has to be run")
+
+	runSlb(t, next)
+	t.Cleanup(func() { next.Stop() })
+	body, err := get(port)
+	require.NoError(t, err)
+	require.Equal(t, "new", body)
+}
+
+func TestReplaceChangesTLSOnTheSameListener(t *testing.T) {
+	port := mock.RandomPort()
+	s := newFrontendSlb(t, port, bodyBackend(t, "plain"))
+	runSlb(t, s)
+
+	cert := writeCertificate(t, t.TempDir(), "a.example")
+	withTLS := func(minVersion string) *Slb {
+		next, err := New(Config{
+			Endpoints:     []*Endpoint{{Addr: bodyBackend(t, "tls").URL}},
+			ListenAddress: "localhost",
+			ListenPort:    port,
+			TLS:           TLSConfig{Certificates: []CertificateConfig{cert}, MinVersion: minVersion},
+		}, &setSelector{})
+		require.NoError(t, err)
+		return next
+	}
+	dial := func(version uint16) error {
+		conn, err := tls.Dial("tcp", "localhost:"+port, &tls.Config{InsecureSkipVerify: true, MinVersion: version, MaxVersion: version})
+		if err == nil {
+			conn.Close()
+		}
+		return err
+	}
+
+	next := withTLS("1.3")
+	require.NoError(t, s.Replace(next), "tls is enabled without listening again")
+	require.NoError(t, dial(tls.VersionTLS13))
+	require.Error(t, dial(tls.VersionTLS12))
+	body, _ := get(port)
+	require.NotEqual(t, "tls", body, "plain requests are not proxied")
+
+	s, next = next, withTLS("1.2")
+	require.NoError(t, s.Replace(next), "the min version changes without listening again")
+	require.NoError(t, dial(tls.VersionTLS12))
+
+	s, next = next, newFrontendSlb(t, port, bodyBackend(t, "plain"))
+	t.Cleanup(func() { next.Stop() })
+	require.NoError(t, s.Replace(next), "tls is disabled without listening again")
+	body, err := get(port)
+	require.NoError(t, err)
+	require.Equal(t, "plain", body)
+}
+
+func TestStoppedSlbIsNotRunning(t *testing.T) {
+	port := mock.RandomPort()
+	s := newFrontendSlb(t, port, bodyBackend(t, "old"))
+	runSlb(t, s)
+	require.NoError(t, s.Stop())
+	require.False(t, s.Running())
+
+	require.Error(t, s.Run(), "a stopped SLB cannot serve again")
+	require.False(t, s.Running())
+
+	next := newFrontendSlb(t, port, bodyBackend(t, "new"))
+	require.NoError(t, s.Replace(next))
+	runSlb(t, next)
+	t.Cleanup(func() { next.Stop() })
+	body, err := get(port)
+	require.NoError(t, err)
+	require.Equal(t, "new", body)
+}
+
+func TestReplaceKeepsMetrics(t *testing.T) {
+	port, metricsPort := mock.RandomPort(), mock.RandomPort()
+	withMetrics := func(backend *httptest.Server) *Slb {
+		s, err := New(Config{
+			Endpoints:     []*Endpoint{{Addr: backend.URL}},
+			ListenAddress: "localhost",
+			ListenPort:    port,
+			Metrics:       MetricsConfig{Port: metricsPort},
+		}, &setSelector{})
+		require.NoError(t, err)
+		return s
+	}
+	old, updated := bodyBackend(t, "old"), bodyBackend(t, "new")
+	s := withMetrics(old)
+	runSlb(t, s)
+	body, err := get(port)
+	require.NoError(t, err)
+	require.Equal(t, "old", body)
+
+	next := withMetrics(updated)
+	t.Cleanup(func() { next.Stop() })
+	calls := prometheus.NewCounter(prometheus.CounterOpts{Name: "api_calls_total"})
+	require.NoError(t, next.RegisterMetrics(calls))
+	require.NoError(t, s.Replace(next))
+	body, err = get(port)
+	require.NoError(t, err)
+	require.Equal(t, "new", body)
+
+	url := "http://localhost:" + metricsPort + DefaultMetricsPath
+	var metrics string
+	require.Eventually(t, func() bool {
+		resp, err := http.Get(url)
+		if err != nil {
+			return false
+		}
+		resp.Body.Close()
+		metrics = scrape(t, url)
+		return true
+	}, time.Second, time.Millisecond*10, "next serves the metrics on the same address")
+	require.Contains(t, metrics, fmt.Sprintf(`balance_requests_total{code="2xx",endpoint=%q,pool="default"} 1`, old.URL), "the counters are kept")
+	require.Contains(t, metrics, fmt.Sprintf(`balance_requests_total{code="2xx",endpoint=%q,pool="default"} 1`, updated.URL))
+	require.Contains(t, metrics, fmt.Sprintf(`balance_endpoint_healthy{endpoint=%q} 1`, updated.URL))
+	require.NotContains(t, metrics, fmt.Sprintf(`balance_endpoint_healthy{endpoint=%q}`, old.URL), "the health of the endpoints of next is reported")
+	require.Contains(t, metrics, "api_calls_total 0")
+}
+
+func TestReplaceKeepsRunningIfNextCannotServe(t *testing.T) {
+	port, nextPort := mock.RandomPort(), mock.RandomPort()
+	s := newFrontendSlb(t, port, bodyBackend(t, "old"))
+	runSlb(t, s)
+	t.Cleanup(func() { s.Stop() })
+
+	next := newFrontendSlb(t, nextPort, bodyBackend(t, "new"))
+	// a closed server cannot serve its listener
+	require.NoError(t, next.frontend.server.Close())
+	require.ErrorIs(t, s.Replace(next), http.ErrServerClosed)
+	require.NoError(t, next.Stop())
+	require.False(t, next.Running())
+
+	require.True(t, s.Running())
+	body, err := get(port)
+	require.NoError(t, err)
+	require.Equal(t, "old", body)
+}
+
+func TestDrainTimesOutWithoutBlockingRequests(t *testing.T) {
+	s := newFrontendSlb(t, mock.RandomPort(), bodyBackend(t, "old"))
+	s.requests.begin()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*10)
+	defer cancel()
+	s.drain(ctx)
+
+	// a request that started before the replacement is not blocked by the timed out drain
+	begun := make(chan struct{})
+	go func() {
+		s.requests.begin()
+		s.requests.end()
+		close(begun)
+	}()
+	require.Eventually(t, func() bool {
+		select {
+		case <-begun:
+			return true
+		default:
+			return false
+		}
+	}, time.Second, time.Millisecond)
+
+	drained := s.requests.drained()
+	select {
+	case <-drained:
+		require.Fail(t, "a request is in flight")
+	default:
+	}
+	s.requests.end()
+	<-drained
+	<-s.requests.drained()
+}
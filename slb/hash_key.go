@@ -0,0 +1,72 @@
+package slb
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+)
+
+// HashKeySource is the part of a request that is used as its hash key
+type HashKeySource string
+
+const (
+	HashKeySourceClientIP HashKeySource = "clientIP"
+	HashKeySourceHeader   HashKeySource = "header"
+	HashKeySourceCookie   HashKeySource = "cookie"
+	HashKeySourcePath     HashKeySource = "path"
+)
+
+var (
+	ErrInvalidHashKey = func(reason string) error { return fmt.Errorf("invalid hash key configuration: %s", reason) }
+)
+
+// HashKeyConfig configures the key of a request, that is passed to selectors implementing RequestSelector.
+// Requests with the same key are sent to the same endpoint.
+type HashKeyConfig struct {
+	// The source of the key, defaults to the client IP
+	Source HashKeySource `json:"source,omitempty"`
+	// Name of the header or cookie, if the key is taken from a header or cookie
+	Name string `json:"name,omitempty"`
+}
+
+// Validates the hash key configuration
+func (h HashKeyConfig) Validate() error {
+	switch h.Source {
+	case "", HashKeySourceClientIP, HashKeySourcePath:
+		return nil
+	case HashKeySourceHeader, HashKeySourceCookie:
+		if h.Name == "" {
+			return ErrInvalidHashKey(fmt.Sprintf("%s name is required", h.Source))
+		}
+		return nil
+	default:
+		return ErrInvalidHashKey(fmt.Sprintf("unknown source %q", h.Source))
+	}
+}
+
+// Key returns the hash key of r.
+// If the configured header or cookie is missing, the client IP is used instead.
+func (h HashKeyConfig) Key(r *http.Request) string {
+	switch h.Source {
+	case HashKeySourceHeader:
+		if key := r.Header.Get(h.Name); key != "" {
+			return key
+		}
+	case HashKeySourceCookie:
+		if cookie, err := r.Cookie(h.Name); err == nil && cookie.Value != "" {
+			return cookie.Value
+		}
+	case HashKeySourcePath:
+		return r.URL.Path
+	}
+	return clientIP(r)
+}
+
+// returns the ip of the client that sent r
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,82 @@
+package slb
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHashKeyConfigValidate(t *testing.T) {
+	require.NoError(t, HashKeyConfig{}.Validate())
+	require.NoError(t, HashKeyConfig{Source: HashKeySourcePath}.Validate())
+	require.NoError(t, HashKeyConfig{Source: HashKeySourceHeader, Name: "X-User-ID"}.Validate())
+	require.Error(t, HashKeyConfig{Source: HashKeySourceHeader}.Validate())
+	require.Error(t, HashKeyConfig{Source: HashKeySourceCookie}.Validate())
+	require.Error(t, HashKeyConfig{Source: "body"}.Validate())
+}
+
+func TestHashKey(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/users/1?page=2", nil)
+	r.RemoteAddr = "10.0.0.1:12345"
+	r.Header.Set("X-User-ID", "user-1")
+	r.AddCookie(&http.Cookie{Name: "session", Value: "session-1"})
+
+	scenarios := []struct {
+		name     string
+		cfg      HashKeyConfig
+		expected string
+	}{
+		{"default is client ip", HashKeyConfig{}, "10.0.0.1"},
+		{"client ip", HashKeyConfig{Source: HashKeySourceClientIP}, "10.0.0.1"},
+		{"header", HashKeyConfig{Source: HashKeySourceHeader, Name: "X-User-ID"}, "user-1"},
+		{"cookie", HashKeyConfig{Source: HashKeySourceCookie, Name: "session"}, "session-1"},
+		{"path", HashKeyConfig{Source: HashKeySourcePath}, "/users/1"},
+		{"missing header falls back to client ip", HashKeyConfig{Source: HashKeySourceHeader, Name: "X-Other"}, "10.0.0.1"},
+		{"missing cookie falls back to client ip", HashKeyConfig{Source: HashKeySourceCookie, Name: "other"}, "10.0.0.1"},
+	}
+	for _, scenario := range scenarios {
+		t.Run(scenario.name, func(t *testing.T) {
+			require.Equal(t, scenario.expected, scenario.cfg.Key(r))
+		})
+	}
+}
+
+// keySelector selects the endpoint named by the key, and records the keys it received
+type keySelector struct {
+	sequenceSelector
+	keys []string
+}
+
+func (k *keySelector) SelectRequest(r *http.Request, key string) (*Endpoint, error) {
+	k.keys = append(k.keys, key)
+	for _, server := range k.endpoints {
+		if server.Addr == key {
+			return server, nil
+		}
+	}
+	return k.endpoints[0], nil
+}
+
+func TestSelectEndpointByRequest(t *testing.T) {
+	selector := &keySelector{}
+	selector.endpoints = []*Endpoint{{Addr: "http://10.0.0.1:80"}, {Addr: "http://10.0.0.2:80"}}
+	s := &Slb{selector: selector, cfg: Config{HashKey: HashKeyConfig{Source: HashKeySourceHeader, Name: "X-Backend"}}}
+	s.health = newHealthChecker(HealthCheckConfig{}, "", selector.EndPoints)
+	s.outliers = newOutlierDetector(OutlierDetectionConfig{ConsecutiveFailures: 1}, onePool(selector.EndPoints))
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("X-Backend", "http://10.0.0.2:80")
+	server, err := s.selectEndpoint(r, s.selector)
+	require.NoError(t, err)
+	require.Same(t, selector.endpoints[1], server)
+
+	// an unavailable endpoint is selected again with salted keys
+	s.outliers.Failure(selector.endpoints[1].Addr)
+	selector.keys = nil
+	server, err = s.selectEndpoint(r, s.selector)
+	require.NoError(t, err)
+	require.Same(t, selector.endpoints[0], server)
+	require.Equal(t, []string{"http://10.0.0.2:80", "http://10.0.0.2:80#1"}, selector.keys)
+}
@@ -0,0 +1,246 @@
+package slb
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httputil"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultHealthCheckInterval = time.Second * 10
+	DefaultHealthCheckTimeout  = time.Second * 2
+	DefaultHealthyThreshold    = 2
+	DefaultUnhealthyThreshold  = 3
+)
+
+var (
+	ErrNoHealthyEndpoints  = func() error { return fmt.Errorf("no healthy endpoints available") }
+	ErrInvalidHealthCheck  = func(reason string) error { return fmt.Errorf("invalid health check configuration: %s", reason) }
+	ErrUnhealthyStatusCode = func(code int) error { return fmt.Errorf("unhealthy status code: %d", code) }
+)
+
+// HealthCheckConfig configures the active health checks of the backend endpoints.
+// Health checking is disabled if no Path is provided.
+type HealthCheckConfig struct {
+	// The http path that is probed on every endpoint (e.g "/health")
+	Path string `json:"path,omitempty"`
+	// Time between two probes of the same endpoint
+	Interval time.Duration `json:"interval,omitempty"`
+	// Time after which a probe is considered failed
+	Timeout time.Duration `json:"timeout,omitempty"`
+	// Consecutive successful probes needed to mark an unhealthy endpoint healthy
+	HealthyThreshold int `json:"healthyThreshold,omitempty"`
+	// Consecutive failed probes needed to mark a healthy endpoint unhealthy
+	UnhealthyThreshold int `json:"unhealthyThreshold,omitempty"`
+}
+
+// Returns true if a health check path is configured
+func (h HealthCheckConfig) Enabled() bool {
+	return h.Path != ""
+}
+
+// Validates the health check configuration
+func (h HealthCheckConfig) Validate() error {
+	if !h.Enabled() {
+		return nil
+	}
+	if !strings.HasPrefix(h.Path, "/") {
+		return ErrInvalidHealthCheck("path must start with \"/\"")
+	}
+	if h.Interval < 0 || h.Timeout < 0 {
+		return ErrInvalidHealthCheck("interval and timeout must not be negative")
+	}
+	if h.HealthyThreshold < 0 || h.UnhealthyThreshold < 0 {
+		return ErrInvalidHealthCheck("thresholds must not be negative")
+	}
+	return nil
+}
+
+// returns a copy of the configuration with default values for unset fields
+func (h HealthCheckConfig) withDefaults() HealthCheckConfig {
+	if h.Interval == 0 {
+		h.Interval = DefaultHealthCheckInterval
+	}
+	if h.Timeout == 0 {
+		h.Timeout = DefaultHealthCheckTimeout
+	}
+	if h.HealthyThreshold == 0 {
+		h.HealthyThreshold = DefaultHealthyThreshold
+	}
+	if h.UnhealthyThreshold == 0 {
+		h.UnhealthyThreshold = DefaultUnhealthyThreshold
+	}
+	return h
+}
+
+// HealthStatus is the result of the health checks of a single endpoint
+type HealthStatus struct {
+	Healthy              bool      `json:"healthy"`
+	ConsecutiveSuccesses int       `json:"consecutiveSuccesses,omitempty"`
+	ConsecutiveFailures  int       `json:"consecutiveFailures,omitempty"`
+	LastError            string    `json:"lastError,omitempty"`
+	LastCheck            time.Time `json:"lastCheck,omitempty"`
+}
+
+// healthChecker periodically probes the endpoints returned by endpoints
+// and keeps track of their health state by endpoint identity.
+// Endpoints that were not probed yet are considered healthy.
+type healthChecker struct {
+	cfg        HealthCheckConfig
+	listenPort string
+	client     *http.Client
+	endpoints  func() ([]*Endpoint, error)
+
+	mu     sync.RWMutex
+	status map[string]*HealthStatus
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+func newHealthChecker(cfg HealthCheckConfig, listenPort string, endpoints func() ([]*Endpoint, error)) *healthChecker {
+	cfg = cfg.withDefaults()
+	return &healthChecker{
+		cfg:        cfg,
+		listenPort: listenPort,
+		client:     &http.Client{Timeout: cfg.Timeout},
+		endpoints:  endpoints,
+		status:     make(map[string]*HealthStatus),
+	}
+}
+
+// Starts probing the endpoints in the background, if health checks are enabled
+func (h *healthChecker) Start() {
+	defer h.mu.Unlock()
+	h.mu.Lock()
+	if !h.cfg.Enabled() || h.stop != nil {
+		return
+	}
+	h.stop, h.done = make(chan struct{}), make(chan struct{})
+	go h.run(h.stop, h.done)
+}
+
+// Stops probing and waits for running probes to finish
+func (h *healthChecker) Stop() {
+	h.mu.Lock()
+	stop, done := h.stop, h.done
+	h.stop, h.done = nil, nil
+	h.mu.Unlock()
+	if stop == nil {
+		return
+	}
+	close(stop)
+	<-done
+}
+
+func (h *healthChecker) run(stop <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(h.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		h.checkAll()
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// probes all endpoints concurrently and forgets endpoints that were removed
+func (h *healthChecker) checkAll() {
+	endpoints, err := h.endpoints()
+	if err != nil {
+		return
+	}
+	wg := sync.WaitGroup{}
+	current := make(map[string]bool, len(endpoints))
+	for _, server := range endpoints {
+		current[server.Identity()] = true
+		wg.Add(1)
+		go func(server *Endpoint) {
+			defer wg.Done()
+			h.record(server.Identity(), h.probe(server))
+		}(server)
+	}
+	wg.Wait()
+
+	defer h.mu.Unlock()
+	h.mu.Lock()
+	for id := range h.status {
+		if !current[id] {
+			delete(h.status, id)
+		}
+	}
+}
+
+func (h *healthChecker) probe(server *Endpoint) error {
+	target, err := endpointURL(server.Addr, h.listenPort)
+	if err != nil {
+		return err
+	}
+	client := h.client
+	// https endpoints are probed with the tls of their proxy
+	if proxy, ok := server.Handler.(*httputil.ReverseProxy); ok && proxy.Transport != nil {
+		client = &http.Client{Timeout: h.client.Timeout, Transport: proxy.Transport}
+	}
+	resp, err := client.Get(target.JoinPath(h.cfg.Path).String())
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusBadRequest {
+		return ErrUnhealthyStatusCode(resp.StatusCode)
+	}
+	return nil
+}
+
+// updates the health state of the endpoint id with the result of a probe
+func (h *healthChecker) record(id string, err error) {
+	defer h.mu.Unlock()
+	h.mu.Lock()
+	status, ok := h.status[id]
+	if !ok {
+		status = &HealthStatus{Healthy: true}
+		h.status[id] = status
+	}
+	status.LastCheck = time.Now()
+	if err != nil {
+		status.LastError = err.Error()
+		status.ConsecutiveSuccesses = 0
+		status.ConsecutiveFailures++
+		if status.ConsecutiveFailures >= h.cfg.UnhealthyThreshold {
+			status.Healthy = false
+		}
+		return
+	}
+	status.LastError = ""
+	status.ConsecutiveFailures = 0
+	status.ConsecutiveSuccesses++
+	if status.ConsecutiveSuccesses >= h.cfg.HealthyThreshold {
+		status.Healthy = true
+	}
+}
+
+// Returns false only if the endpoint id was marked unhealthy
+func (h *healthChecker) IsHealthy(id string) bool {
+	defer h.mu.RUnlock()
+	h.mu.RLock()
+	if status, ok := h.status[id]; ok {
+		return status.Healthy
+	}
+	return true
+}
+
+// Returns a copy of the health state of all probed endpoints by identity
+func (h *healthChecker) Status() map[string]HealthStatus {
+	defer h.mu.RUnlock()
+	h.mu.RLock()
+	status := make(map[string]HealthStatus, len(h.status))
+	for id, s := range h.status {
+		status[id] = *s
+	}
+	return status
+}
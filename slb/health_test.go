@@ -0,0 +1,148 @@
+package slb
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// returns a backend whose health endpoint responds with the status stored in status
+func healthBackend(t *testing.T, status *atomic.Int32) *httptest.Server {
+	backend := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/health" {
+			rw.WriteHeader(http.StatusNotFound)
+			return
+		}
+		rw.WriteHeader(int(status.Load()))
+	}))
+	t.Cleanup(backend.Close)
+	return backend
+}
+
+func TestHealthCheckConfigValidate(t *testing.T) {
+	require.NoError(t, HealthCheckConfig{}.Validate())
+	require.NoError(t, HealthCheckConfig{Path: "/health"}.Validate())
+	require.Error(t, HealthCheckConfig{Path: "health"}.Validate())
+	require.Error(t, HealthCheckConfig{Path: "/health", Interval: -time.Second}.Validate())
+	require.Error(t, HealthCheckConfig{Path: "/health", UnhealthyThreshold: -1}.Validate())
+}
+
+func TestHealthCheckerThresholds(t *testing.T) {
+	status := &atomic.Int32{}
+	status.Store(http.StatusOK)
+	backend := healthBackend(t, status)
+	server := &Endpoint{Addr: backend.URL}
+
+	checker := newHealthChecker(
+		HealthCheckConfig{Path: "/health", HealthyThreshold: 2, UnhealthyThreshold: 2},
+		"",
+		func() ([]*Endpoint, error) { return []*Endpoint{server}, nil },
+	)
+	require.True(t, checker.IsHealthy(server.Addr), "unprobed endpoints are considered healthy")
+
+	checker.checkAll()
+	require.True(t, checker.IsHealthy(server.Addr))
+
+	status.Store(http.StatusInternalServerError)
+	checker.checkAll()
+	require.True(t, checker.IsHealthy(server.Addr), "unhealthy threshold not reached")
+	checker.checkAll()
+	require.False(t, checker.IsHealthy(server.Addr))
+	require.Equal(t, ErrUnhealthyStatusCode(http.StatusInternalServerError).Error(), checker.Status()[server.Addr].LastError)
+
+	status.Store(http.StatusOK)
+	checker.checkAll()
+	require.False(t, checker.IsHealthy(server.Addr), "healthy threshold not reached")
+	checker.checkAll()
+	require.True(t, checker.IsHealthy(server.Addr))
+	require.Equal(t, 2, checker.Status()[server.Addr].ConsecutiveSuccesses)
+}
+
+func TestHealthCheckerForgetsRemovedEndpoints(t *testing.T) {
+	status := &atomic.Int32{}
+	status.Store(http.StatusOK)
+	backend := healthBackend(t, status)
+	endpoints := []*Endpoint{{Addr: backend.URL}}
+
+	checker := newHealthChecker(HealthCheckConfig{Path: "/health"}, "",
+		func() ([]*Endpoint, error) { return endpoints, nil })
+	checker.checkAll()
+	require.Len(t, checker.Status(), 1)
+
+	endpoints = []*Endpoint{}
+	checker.checkAll()
+	require.Empty(t, checker.Status())
+}
+
+func TestHealthCheckerStartStop(t *testing.T) {
+	status := &atomic.Int32{}
+	status.Store(http.StatusServiceUnavailable)
+	backend := healthBackend(t, status)
+	server := &Endpoint{Addr: backend.URL}
+
+	checker := newHealthChecker(
+		HealthCheckConfig{Path: "/health", Interval: time.Millisecond * 10, UnhealthyThreshold: 1},
+		"",
+		func() ([]*Endpoint, error) { return []*Endpoint{server}, nil },
+	)
+	checker.Start()
+	checker.Start()
+	require.Eventually(t, func() bool { return !checker.IsHealthy(server.Addr) }, time.Second, time.Millisecond*10)
+	checker.Stop()
+	checker.Stop()
+}
+
+func TestSlbSkipsUnhealthyEndpoints(t *testing.T) {
+	healthy, unhealthy := &atomic.Int32{}, &atomic.Int32{}
+	healthy.Store(http.StatusOK)
+	unhealthy.Store(http.StatusInternalServerError)
+	healthyServer := &Endpoint{Addr: healthBackend(t, healthy).URL}
+	unhealthyServer := &Endpoint{Addr: healthBackend(t, unhealthy).URL}
+	selector := &sequenceSelector{endpoints: []*Endpoint{unhealthyServer, healthyServer}}
+
+	s := &Slb{selector: selector}
+	s.health = newHealthChecker(HealthCheckConfig{Path: "/health", UnhealthyThreshold: 1}, "", selector.EndPoints)
+	s.outliers = newOutlierDetector(OutlierDetectionConfig{}, onePool(selector.EndPoints))
+	s.discovery = newDiscovery("", discoveryHandler{})
+	s.health.checkAll()
+
+	for i := 0; i < 4; i++ {
+		server, err := s.selectEndpoint(httptest.NewRequest(http.MethodGet, "/", nil), s.selector)
+		require.NoError(t, err)
+		require.Same(t, healthyServer, server)
+	}
+	for _, endpoint := range s.Configuration().Endpoints {
+		status := s.health.Status()[endpoint.Addr]
+		require.Equal(t, &status, endpoint.Health)
+	}
+
+	healthy.Store(http.StatusInternalServerError)
+	s.health.checkAll()
+	_, err := s.selectEndpoint(httptest.NewRequest(http.MethodGet, "/", nil), s.selector)
+	require.ErrorContains(t, err, ErrNoHealthyEndpoints().Error())
+}
+
+// sequenceSelector selects its endpoints in order
+type sequenceSelector struct {
+	Selector
+	endpoints []*Endpoint
+	next      int
+}
+
+func (s *sequenceSelector) Select() (*Endpoint, error) {
+	if len(s.endpoints) == 0 {
+		return nil, fmt.Errorf("selector has no endpoints to select")
+	}
+	server := s.endpoints[s.next%len(s.endpoints)]
+	s.next++
+	return server, nil
+}
+
+func (s *sequenceSelector) EndPoints() ([]*Endpoint, error) {
+	return s.endpoints, nil
+}
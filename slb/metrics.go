@@ -0,0 +1,268 @@
+package slb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const (
+	DefaultMetricsPath = "/metrics"
+	metricsNamespace   = "balance"
+)
+
+var (
+	ErrInvalidMetrics = func(reason string) error { return fmt.Errorf("invalid metrics configuration: %s", reason) }
+)
+
+// MetricsConfig configures the admin server, that exposes the metrics in the prometheus text format.
+// Metrics are not served if no Port is provided.
+type MetricsConfig struct {
+	// Network port of the admin server
+	Port string `json:"port,omitempty"`
+	// Address of the admin server (without port), defaults to the listen address
+	Address string `json:"address,omitempty"`
+	// The http path the metrics are served at (default "/metrics")
+	Path string `json:"path,omitempty"`
+}
+
+// Returns true if a metrics port is configured
+func (m MetricsConfig) Enabled() bool {
+	return m.Port != ""
+}
+
+// Validates the metrics configuration
+func (m MetricsConfig) Validate() error {
+	if !m.Enabled() {
+		return nil
+	}
+	if _, err := strconv.ParseUint(m.Port, 10, 16); err != nil {
+		return ErrInvalidMetrics("invalid port " + m.Port)
+	}
+	if m.Path != "" && m.Path[0] != '/' {
+		return ErrInvalidMetrics("path must start with \"/\"")
+	}
+	return nil
+}
+
+// metrics of the proxied requests, by pool and endpoint identity.
+// They are handed over to the SLB that replaces the SLB, so that the counters are kept across reconfigurations.
+type metrics struct {
+	registry *prometheus.Registry
+	// health state of the endpoints of the SLB the metrics belong to (nil if it is not registered)
+	health *healthCollector
+	// additional collectors that were registered by RegisterMetrics
+	collectors      []prometheus.Collector
+	requests        *prometheus.CounterVec
+	duration        *prometheus.HistogramVec
+	inFlight        *prometheus.GaugeVec
+	selectionErrors *prometheus.CounterVec
+	upstreamErrors  *prometheus.CounterVec
+	retries         *prometheus.CounterVec
+}
+
+func newMetrics() *metrics {
+	m := &metrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "requests_total",
+			Help:      "Requests proxied to the endpoints by status class (e.g 2xx).",
+		}, []string{"pool", "endpoint", "code"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "request_duration_seconds",
+			Help:      "Latency of the requests proxied to the endpoints.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"pool", "endpoint"}),
+		inFlight: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "requests_in_flight",
+			Help:      "Requests currently proxied to the endpoints.",
+		}, []string{"pool", "endpoint"}),
+		selectionErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "selection_errors_total",
+			Help:      "Requests for which no endpoint could be selected.",
+		}, []string{"pool", "kind"}),
+		upstreamErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "upstream_errors_total",
+			Help:      "Requests that could not be proxied to the endpoints.",
+		}, []string{"endpoint", "kind"}),
+		retries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "retries_total",
+			Help:      "Requests that were retried on another endpoint.",
+		}, []string{"pool"}),
+	}
+	m.registry.MustRegister(
+		m.requests, m.duration, m.inFlight, m.selectionErrors, m.upstreamErrors, m.retries,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return m
+}
+
+// Tracks a request to endpoint of pool, the returned func records its outcome once it finished
+func (m *metrics) Track(pool string, endpoint string) func(Outcome) {
+	inFlight := m.inFlight.WithLabelValues(pool, endpoint)
+	inFlight.Inc()
+	return func(o Outcome) {
+		inFlight.Dec()
+		m.duration.WithLabelValues(pool, endpoint).Observe(o.Duration.Seconds())
+		m.requests.WithLabelValues(pool, endpoint, statusClass(o.StatusCode)).Inc()
+	}
+}
+
+// Deletes the series of endpoint (e.g when it is removed), so that endpoint churn does not grow the metrics
+func (m *metrics) Forget(endpoint string) {
+	if m == nil {
+		return
+	}
+	labels := prometheus.Labels{"endpoint": endpoint}
+	m.requests.DeletePartialMatch(labels)
+	m.duration.DeletePartialMatch(labels)
+	m.inFlight.DeletePartialMatch(labels)
+	m.upstreamErrors.DeletePartialMatch(labels)
+}
+
+// registers an additional collector, collectors that are registered already are accepted
+func (m *metrics) register(collector prometheus.Collector) error {
+	if err := m.registry.Register(collector); err != nil {
+		if errors.As(err, &prometheus.AlreadyRegisteredError{}) {
+			return nil
+		}
+		return err
+	}
+	m.collectors = append(m.collectors, collector)
+	return nil
+}
+
+// Returns a handler that serves the metrics in the prometheus text format
+func (m *metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// returns the class of a status code, e.g "2xx"
+func statusClass(status int) string {
+	return strconv.Itoa(status/100) + "xx"
+}
+
+// healthCollector collects the health state of all endpoints of its current SLB at scrape time
+type healthCollector struct {
+	slb     atomic.Pointer[Slb]
+	healthy *prometheus.Desc
+	ejected *prometheus.Desc
+}
+
+func newHealthCollector(s *Slb) *healthCollector {
+	h := &healthCollector{
+		healthy: prometheus.NewDesc(prometheus.BuildFQName(metricsNamespace, "endpoint", "healthy"),
+			"Health state of the endpoints by the active health checks (1 healthy, 0 unhealthy).", []string{"endpoint"}, nil),
+		ejected: prometheus.NewDesc(prometheus.BuildFQName(metricsNamespace, "endpoint", "ejected"),
+			"Ejection state of the endpoints by the outlier detection (1 ejected, 0 not ejected).", []string{"endpoint"}, nil),
+	}
+	h.slb.Store(s)
+	return h
+}
+
+func (h *healthCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- h.healthy
+	ch <- h.ejected
+}
+
+func (h *healthCollector) Collect(ch chan<- prometheus.Metric) {
+	s := h.slb.Load()
+	endpoints, err := s.endpoints()
+	if err != nil {
+		return
+	}
+	for _, server := range endpoints {
+		id := server.Identity()
+		ch <- prometheus.MustNewConstMetric(h.healthy, prometheus.GaugeValue, boolValue(s.health.IsHealthy(id)), id)
+		ch <- prometheus.MustNewConstMetric(h.ejected, prometheus.GaugeValue, boolValue(s.outliers.IsEjected(id)), id)
+	}
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// Registers additional collectors (e.g of the api service) with the metrics of the SLB.
+// They are kept by the SLB that replaces it.
+func (s *Slb) RegisterMetrics(collectors ...prometheus.Collector) error {
+	for _, collector := range collectors {
+		if err := s.metrics.register(collector); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// takes over the metrics of previous, that is replaced by this SLB, so that the counters are kept.
+// The collectors registered with this SLB are registered with the metrics of previous.
+func (s *Slb) adoptMetrics(previous *Slb) error {
+	for _, collector := range s.metrics.collectors {
+		if err := previous.metrics.register(collector); err != nil {
+			return err
+		}
+	}
+	s.metrics = previous.metrics
+	s.metrics.health.slb.Store(s)
+	s.metricsServer = s.newMetricsServer()
+	return nil
+}
+
+// returns the admin server that serves the metrics, it is nil if metrics are disabled
+func (s *Slb) newMetricsServer() *http.Server {
+	if !s.cfg.Metrics.Enabled() {
+		return nil
+	}
+	path := s.cfg.Metrics.Path
+	if path == "" {
+		path = DefaultMetricsPath
+	}
+	address := s.cfg.Metrics.Address
+	if address == "" {
+		address = s.cfg.ListenAddress
+	}
+	if address == "" {
+		address = DefaultListenAddress
+	}
+	mux := http.NewServeMux()
+	mux.Handle(path, s.metrics.Handler())
+	return &http.Server{Addr: address + ":" + s.cfg.Metrics.Port, Handler: mux}
+}
+
+// serves the metrics until the admin server is shut down
+func (s *Slb) serveMetrics() {
+	if s.metricsServer == nil {
+		return
+	}
+	slog.Info("SLB metrics served at: " + s.metricsServer.Addr)
+	if err := s.metricsServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		slog.Error(err.Error())
+	}
+}
+
+func (s *Slb) stopMetrics() {
+	if s.metricsServer == nil {
+		return
+	}
+	ctx, cancelFunc := context.WithTimeout(context.Background(), time.Second)
+	defer cancelFunc()
+	s.metricsServer.Shutdown(ctx)
+}
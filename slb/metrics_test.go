@@ -0,0 +1,123 @@
+package slb
+
+import (
+	"balance/internal/mock"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// returns the metrics served by handler in the prometheus text format
+func scrape(t *testing.T, url string) string {
+	resp, err := http.Get(url)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return string(body)
+}
+
+func TestMetricsConfigValidate(t *testing.T) {
+	require.NoError(t, MetricsConfig{}.Validate())
+	require.NoError(t, MetricsConfig{Port: "9090", Path: "/stats"}.Validate())
+	require.Error(t, MetricsConfig{Port: "http"}.Validate())
+	require.Error(t, MetricsConfig{Port: "9090", Path: "stats"}.Validate())
+}
+
+func TestMetricsAreScraped(t *testing.T) {
+	s, _ := newRetrySlb(t, RetryPolicyConfig{MaxRetries: 1}, refusingBackend(), echoBackend(t))
+	s.metrics.registry.MustRegister(newHealthCollector(s))
+	endpoints, _ := s.selector.EndPoints()
+	refused, ok := endpoints[0].Addr, endpoints[1].Addr
+
+	// every request is retried on the second endpoint
+	for i := 0; i < 2; i++ {
+		rw := httptest.NewRecorder()
+		s.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "/", nil))
+		require.Equal(t, http.StatusOK, rw.Code)
+	}
+	s.selector.(*trackerMock).endpoints = nil
+	s.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	admin := httptest.NewServer(s.metrics.Handler())
+	t.Cleanup(admin.Close)
+	metrics := scrape(t, admin.URL)
+	for _, expected := range []string{
+		fmt.Sprintf(`balance_requests_total{code="2xx",endpoint=%q,pool="default"} 2`, ok),
+		fmt.Sprintf(`balance_requests_total{code="5xx",endpoint=%q,pool="default"} 2`, refused),
+		fmt.Sprintf(`balance_request_duration_seconds_count{endpoint=%q,pool="default"} 2`, ok),
+		fmt.Sprintf(`balance_requests_in_flight{endpoint=%q,pool="default"} 0`, ok),
+		fmt.Sprintf(`balance_upstream_errors_total{endpoint=%q,kind="connectionRefused"} 2`, refused),
+		`balance_retries_total{pool="default"} 2`,
+		`balance_selection_errors_total{kind="noEndpoints",pool="default"} 1`,
+	} {
+		require.Contains(t, metrics, expected)
+	}
+}
+
+func TestMetricsForgetRemovedEndpoints(t *testing.T) {
+	selector := &setSelector{}
+	s, err := New(Config{
+		ListenAddress: "localhost",
+		Endpoints:     []*Endpoint{{Addr: echoBackend(t).URL}, {Addr: echoBackend(t).URL}},
+	}, selector)
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Stop() })
+	for i := 0; i < 2; i++ {
+		s.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	}
+	removed, kept := selector.endpoints[0], selector.endpoints[1]
+
+	require.NoError(t, s.Remove(removed))
+	admin := httptest.NewServer(s.metrics.Handler())
+	t.Cleanup(admin.Close)
+	metrics := scrape(t, admin.URL)
+	require.NotContains(t, metrics, removed.Addr)
+	require.Contains(t, metrics, fmt.Sprintf(`balance_requests_total{code="2xx",endpoint=%q,pool="default"} 1`, kept.Addr))
+}
+
+func TestMetricsReportHealth(t *testing.T) {
+	s, _ := newRetrySlb(t, RetryPolicyConfig{}, echoBackend(t), echoBackend(t))
+	s.metrics.registry.MustRegister(newHealthCollector(s))
+	s.health = newHealthChecker(HealthCheckConfig{Path: "/health", UnhealthyThreshold: 1}, "", s.selector.EndPoints)
+	endpoints, _ := s.selector.EndPoints()
+	s.health.record(endpoints[0].Addr, fmt.Errorf("probe failed"))
+
+	admin := httptest.NewServer(s.metrics.Handler())
+	t.Cleanup(admin.Close)
+	metrics := scrape(t, admin.URL)
+	require.Contains(t, metrics, fmt.Sprintf(`balance_endpoint_healthy{endpoint=%q} 0`, endpoints[0].Addr))
+	require.Contains(t, metrics, fmt.Sprintf(`balance_endpoint_healthy{endpoint=%q} 1`, endpoints[1].Addr))
+	require.Contains(t, metrics, fmt.Sprintf(`balance_endpoint_ejected{endpoint=%q} 0`, endpoints[0].Addr))
+}
+
+func TestRunServesMetrics(t *testing.T) {
+	metricsPort := mock.RandomPort()
+	s, err := New(Config{
+		Endpoints:     generateEndpoints(1),
+		ListenAddress: "localhost",
+		ListenPort:    mock.RandomPort(),
+		Metrics:       MetricsConfig{Port: metricsPort, Path: "/stats"},
+	}, &SelectorMock{})
+	require.NoError(t, err)
+	go s.Run()
+	t.Cleanup(func() { s.Stop() })
+
+	url := "http://localhost:" + metricsPort + "/stats"
+	require.Eventually(t, func() bool {
+		resp, err := http.Get(url)
+		if err != nil {
+			return false
+		}
+		resp.Body.Close()
+		return true
+	}, time.Second, time.Millisecond*10)
+	require.True(t, strings.Contains(scrape(t, url), "go_goroutines"))
+}
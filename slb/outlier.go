@@ -0,0 +1,231 @@
+package slb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultBaseEjectionTime   = time.Second * 30
+	DefaultMaxEjectionTime    = time.Minute * 5
+	DefaultMaxEjectionPercent = 50
+)
+
+var (
+	ErrInvalidOutlierDetection = func(reason string) error {
+		return fmt.Errorf("invalid outlier detection configuration: %s", reason)
+	}
+)
+
+// OutlierDetectionConfig configures the passive outlier detection, which ejects endpoints
+// that fail consecutive proxied requests (5xx responses or transport errors).
+// Outlier detection is disabled if ConsecutiveFailures is 0.
+type OutlierDetectionConfig struct {
+	// Consecutive failed requests after which an endpoint is ejected
+	ConsecutiveFailures int `json:"consecutiveFailures,omitempty"`
+	// Ejection time of the first ejection, it doubles with every following ejection
+	BaseEjectionTime time.Duration `json:"baseEjectionTime,omitempty"`
+	// Upper bound of the ejection time
+	MaxEjectionTime time.Duration `json:"maxEjectionTime,omitempty"`
+	// Maximal percentage of endpoints that can be ejected at once.
+	// At least one endpoint is allowed to be ejected, but never the whole pool.
+	MaxEjectionPercent int `json:"maxEjectionPercent,omitempty"`
+}
+
+// Returns true if a consecutive failures threshold is configured
+func (o OutlierDetectionConfig) Enabled() bool {
+	return o.ConsecutiveFailures > 0
+}
+
+// Validates the outlier detection configuration
+func (o OutlierDetectionConfig) Validate() error {
+	if o.ConsecutiveFailures < 0 {
+		return ErrInvalidOutlierDetection("consecutive failures must not be negative")
+	}
+	if o.BaseEjectionTime < 0 || o.MaxEjectionTime < 0 {
+		return ErrInvalidOutlierDetection("ejection times must not be negative")
+	}
+	if o.MaxEjectionPercent < 0 || o.MaxEjectionPercent > 100 {
+		return ErrInvalidOutlierDetection("max ejection percent must be between 0 and 100")
+	}
+	return nil
+}
+
+// returns a copy of the configuration with default values for unset fields
+func (o OutlierDetectionConfig) withDefaults() OutlierDetectionConfig {
+	if o.BaseEjectionTime == 0 {
+		o.BaseEjectionTime = DefaultBaseEjectionTime
+	}
+	if o.MaxEjectionTime == 0 {
+		o.MaxEjectionTime = DefaultMaxEjectionTime
+	}
+	if o.MaxEjectionTime < o.BaseEjectionTime {
+		o.MaxEjectionTime = o.BaseEjectionTime
+	}
+	if o.MaxEjectionPercent == 0 {
+		o.MaxEjectionPercent = DefaultMaxEjectionPercent
+	}
+	return o
+}
+
+type outlierState struct {
+	consecutiveFailures int
+	// number of ejections, which determines the length of the next ejection
+	ejections    int
+	ejectedUntil time.Time
+}
+
+// outlierDetector records the outcome of proxied requests by endpoint identity
+// and ejects endpoints that exceed the consecutive failures threshold.
+// The max ejection percentage applies to every pool of pools on its own.
+type outlierDetector struct {
+	cfg   OutlierDetectionConfig
+	pools func() ([][]*Endpoint, error)
+	now   func() time.Time
+
+	mu    sync.Mutex
+	state map[string]*outlierState
+}
+
+func newOutlierDetector(cfg OutlierDetectionConfig, pools func() ([][]*Endpoint, error)) *outlierDetector {
+	return &outlierDetector{
+		cfg:   cfg.withDefaults(),
+		pools: pools,
+		now:   time.Now,
+		state: make(map[string]*outlierState),
+	}
+}
+
+// Records a successful request to the endpoint id
+func (o *outlierDetector) Success(id string) {
+	if !o.cfg.Enabled() {
+		return
+	}
+	defer o.mu.Unlock()
+	o.mu.Lock()
+	state, ok := o.state[id]
+	if !ok {
+		return
+	}
+	state.consecutiveFailures = 0
+	// forget previous ejections once the endpoint behaved for as long as it was ejected
+	if state.ejections > 0 && o.now().After(state.ejectedUntil.Add(o.ejectionTime(state.ejections))) {
+		delete(o.state, id)
+	}
+}
+
+// Records a failed request to the endpoint id, and ejects it if the failure threshold is reached
+func (o *outlierDetector) Failure(id string) {
+	if !o.cfg.Enabled() {
+		return
+	}
+	defer o.mu.Unlock()
+	o.mu.Lock()
+	state, ok := o.state[id]
+	if !ok {
+		state = &outlierState{}
+		o.state[id] = state
+	}
+	now := o.now()
+	if now.Before(state.ejectedUntil) {
+		return
+	}
+	state.consecutiveFailures++
+	if state.consecutiveFailures < o.cfg.ConsecutiveFailures || !o.canEject(id, now) {
+		return
+	}
+	state.ejections++
+	state.consecutiveFailures = 0
+	state.ejectedUntil = now.Add(o.ejectionTime(state.ejections))
+	slog.Warn(fmt.Sprintf("ejecting endpoint %s until %s", id, state.ejectedUntil.Format(time.RFC3339)))
+}
+
+// Returns true if the endpoint id is currently ejected
+func (o *outlierDetector) IsEjected(id string) bool {
+	defer o.mu.Unlock()
+	o.mu.Lock()
+	state, ok := o.state[id]
+	return ok && o.now().Before(state.ejectedUntil)
+}
+
+// Forgets the state of the endpoint id (e.g when it is removed)
+func (o *outlierDetector) Forget(id string) {
+	defer o.mu.Unlock()
+	o.mu.Lock()
+	delete(o.state, id)
+}
+
+// returns the ejection time of the nth ejection: base * 2^(n-1), capped by the max ejection time
+func (o *outlierDetector) ejectionTime(n int) time.Duration {
+	ejectionTime := o.cfg.BaseEjectionTime
+	for i := 1; i < n && ejectionTime < o.cfg.MaxEjectionTime; i++ {
+		ejectionTime *= 2
+	}
+	return min(ejectionTime, o.cfg.MaxEjectionTime)
+}
+
+// checks if the endpoint id can be ejected without exceeding the max ejection percentage of any pool it belongs to.
+// must be called with the lock held.
+func (o *outlierDetector) canEject(id string, now time.Time) bool {
+	pools, err := o.pools()
+	if err != nil {
+		return false
+	}
+	member := false
+	for _, endpoints := range pools {
+		if !slices.ContainsFunc(endpoints, func(server *Endpoint) bool { return server.Identity() == id }) {
+			continue
+		}
+		member = true
+		allowed := len(endpoints) * o.cfg.MaxEjectionPercent / 100
+		allowed = min(max(allowed, 1), len(endpoints)-1)
+		ejected := 0
+		for _, server := range endpoints {
+			if state, ok := o.state[server.Identity()]; ok && now.Before(state.ejectedUntil) {
+				ejected++
+			}
+		}
+		if ejected >= allowed {
+			return false
+		}
+	}
+	return member
+}
+
+// returns hooks for a reverse proxy to the endpoint id, that feed the outlier detector.
+// The error handler responds to the client with respond.
+func (o *outlierDetector) proxyHooks(id string, respond func(http.ResponseWriter, *http.Request, error)) (func(*http.Response) error, func(http.ResponseWriter, *http.Request, error)) {
+	modifyResponse := func(resp *http.Response) error {
+		if resp.StatusCode >= http.StatusInternalServerError {
+			o.Failure(id)
+		} else {
+			o.Success(id)
+		}
+		return nil
+	}
+	errorHandler := func(rw http.ResponseWriter, r *http.Request, err error) {
+		// only transport errors count against the endpoint, not clients that hang up
+		if !clientAborted(r, err) {
+			o.Failure(id)
+		}
+		slog.Error(fmt.Sprintf("proxy error from %s: %s", id, err))
+		respond(rw, r, err)
+	}
+	return modifyResponse, errorHandler
+}
+
+// returns true if the client ended r (e.g it disconnected or timed out) before the endpoint responded,
+// which is not a failure of the endpoint. The upstream timeout of the SLB is a failure of the endpoint.
+func clientAborted(r *http.Request, err error) bool {
+	ctx := r.Context()
+	if ctx.Err() != nil {
+		return !errors.Is(context.Cause(ctx), errUpstreamTimeout)
+	}
+	return errors.Is(err, context.Canceled)
+}
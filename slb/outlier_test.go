@@ -0,0 +1,231 @@
+package slb
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// returns an outlier detector for n endpoints, with a clock controlled by the returned func
+func newTestOutlierDetector(cfg OutlierDetectionConfig, n int) (*outlierDetector, []string, func(time.Duration)) {
+	endpoints := make([]*Endpoint, 0, n)
+	addrs := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		addr := "http://10.0.0." + string(rune('1'+i)) + ":80"
+		endpoints = append(endpoints, &Endpoint{Addr: addr})
+		addrs = append(addrs, addr)
+	}
+	detector := newOutlierDetector(cfg, func() ([][]*Endpoint, error) { return [][]*Endpoint{endpoints}, nil })
+	now := time.Now()
+	detector.now = func() time.Time { return now }
+	return detector, addrs, func(d time.Duration) { now = now.Add(d) }
+}
+
+// returns the endpoints of a single pool
+func onePool(endpoints func() ([]*Endpoint, error)) func() ([][]*Endpoint, error) {
+	return func() ([][]*Endpoint, error) {
+		pool, err := endpoints()
+		return [][]*Endpoint{pool}, err
+	}
+}
+
+func TestOutlierDetectionConfigValidate(t *testing.T) {
+	require.NoError(t, OutlierDetectionConfig{}.Validate())
+	require.NoError(t, OutlierDetectionConfig{ConsecutiveFailures: 3, MaxEjectionPercent: 100}.Validate())
+	require.Error(t, OutlierDetectionConfig{ConsecutiveFailures: -1}.Validate())
+	require.Error(t, OutlierDetectionConfig{BaseEjectionTime: -time.Second}.Validate())
+	require.Error(t, OutlierDetectionConfig{MaxEjectionPercent: 101}.Validate())
+}
+
+func TestOutlierDetectorDisabled(t *testing.T) {
+	detector, addrs, _ := newTestOutlierDetector(OutlierDetectionConfig{}, 2)
+	for i := 0; i < 10; i++ {
+		detector.Failure(addrs[0])
+	}
+	require.False(t, detector.IsEjected(addrs[0]))
+}
+
+func TestOutlierDetectorEjection(t *testing.T) {
+	detector, addrs, advance := newTestOutlierDetector(OutlierDetectionConfig{
+		ConsecutiveFailures: 3,
+		BaseEjectionTime:    time.Second * 10,
+		MaxEjectionTime:     time.Second * 30,
+	}, 2)
+	addr := addrs[0]
+
+	detector.Failure(addr)
+	detector.Failure(addr)
+	detector.Success(addr)
+	detector.Failure(addr)
+	detector.Failure(addr)
+	require.False(t, detector.IsEjected(addr), "a success resets the consecutive failures")
+	detector.Failure(addr)
+	require.True(t, detector.IsEjected(addr))
+
+	// ejection times grow exponentially up to the max ejection time
+	for _, ejectionTime := range []time.Duration{time.Second * 10, time.Second * 20, time.Second * 30, time.Second * 30} {
+		advance(ejectionTime - time.Millisecond)
+		require.True(t, detector.IsEjected(addr))
+		advance(time.Millisecond)
+		require.False(t, detector.IsEjected(addr), "endpoint should be re-admitted after %s", ejectionTime)
+		for i := 0; i < 3; i++ {
+			detector.Failure(addr)
+		}
+	}
+
+	// the ejection history is forgotten after the endpoint recovered
+	advance(time.Second * 30)
+	advance(time.Second*30 + time.Millisecond)
+	detector.Success(addr)
+	for i := 0; i < 3; i++ {
+		detector.Failure(addr)
+	}
+	advance(time.Second * 10)
+	require.False(t, detector.IsEjected(addr))
+}
+
+func TestOutlierDetectorMaxEjectionPercent(t *testing.T) {
+	scenarios := []struct {
+		name               string
+		endpoints          int
+		maxEjectionPercent int
+		expectedEjected    int
+	}{
+		{"single endpoint is never ejected", 1, 100, 0},
+		{"at least one endpoint can be ejected", 4, 10, 1},
+		{"percentage of the pool", 10, 30, 3},
+		{"never the whole pool", 3, 100, 2},
+	}
+	for _, scenario := range scenarios {
+		t.Run(scenario.name, func(t *testing.T) {
+			detector, addrs, _ := newTestOutlierDetector(OutlierDetectionConfig{
+				ConsecutiveFailures: 1,
+				MaxEjectionPercent:  scenario.maxEjectionPercent,
+			}, scenario.endpoints)
+			ejected := 0
+			for _, addr := range addrs {
+				detector.Failure(addr)
+				if detector.IsEjected(addr) {
+					ejected++
+				}
+			}
+			require.Equal(t, scenario.expectedEjected, ejected)
+		})
+	}
+}
+
+func TestOutlierDetectorMaxEjectionPercentByPool(t *testing.T) {
+	detector, addrs, _ := newTestOutlierDetector(OutlierDetectionConfig{ConsecutiveFailures: 1}, 10)
+	large := make([]*Endpoint, 0, len(addrs))
+	for _, addr := range addrs {
+		large = append(large, &Endpoint{Addr: addr})
+	}
+	small := []*Endpoint{{Addr: "http://10.0.1.1:80"}, {Addr: "http://10.0.1.2:80"}}
+	detector.pools = func() ([][]*Endpoint, error) { return [][]*Endpoint{large, small}, nil }
+
+	for _, server := range small {
+		detector.Failure(server.Addr)
+	}
+	require.True(t, detector.IsEjected(small[0].Addr))
+	require.False(t, detector.IsEjected(small[1].Addr), "the whole pool is never ejected, even if other pools are below the max ejection percentage")
+
+	// an endpoint of both pools is only ejected if neither pool exceeds its max ejection percentage
+	small[1] = large[0]
+	detector.Failure(large[0].Addr)
+	require.False(t, detector.IsEjected(large[0].Addr))
+	detector.Forget(small[0].Addr)
+	detector.Failure(large[0].Addr)
+	require.True(t, detector.IsEjected(large[0].Addr))
+}
+
+func TestSlbEjectsFailingEndpoints(t *testing.T) {
+	failing := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.WriteHeader(http.StatusInternalServerError)
+	}))
+	t.Cleanup(failing.Close)
+	ok := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		io.WriteString(rw, "OK")
+	}))
+	t.Cleanup(ok.Close)
+
+	selector := &sequenceSelector{}
+	s := &Slb{selector: selector}
+	s.health = newHealthChecker(HealthCheckConfig{}, "", selector.EndPoints)
+	s.outliers = newOutlierDetector(OutlierDetectionConfig{ConsecutiveFailures: 2}, onePool(selector.EndPoints))
+	s.retries = newRetrier(RetryPolicyConfig{})
+	s.errors = newErrorResponder(nil)
+	s.metrics = newMetrics()
+	for _, backend := range []*httptest.Server{failing, ok} {
+		// every backend listens on its own port, which is set as listen port before setting its proxy
+		host, port, err := net.SplitHostPort(strings.TrimPrefix(backend.URL, "http://"))
+		require.NoError(t, err)
+		server := &Endpoint{Addr: host}
+		s.cfg.ListenPort = port
+		require.NoError(t, s.setServerProxy(server))
+		selector.endpoints = append(selector.endpoints, server)
+	}
+
+	statuses := []int{}
+	for i := 0; i < 6; i++ {
+		rw := httptest.NewRecorder()
+		s.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "/", nil))
+		statuses = append(statuses, rw.Code)
+	}
+	require.Equal(t, []int{500, 200, 500, 200, 200, 200}, statuses)
+	require.True(t, s.outliers.IsEjected(selector.endpoints[0].Addr))
+}
+
+func TestOutlierDetectorTransportErrors(t *testing.T) {
+	detector, addrs, _ := newTestOutlierDetector(OutlierDetectionConfig{ConsecutiveFailures: 1}, 2)
+	_, errorHandler := detector.proxyHooks(addrs[0], func(rw http.ResponseWriter, r *http.Request, err error) {
+		newErrorResponder(nil).Write(rw, proxyErrorKind(err), err)
+	})
+	rw := httptest.NewRecorder()
+	errorHandler(rw, httptest.NewRequest(http.MethodGet, "/", nil), io.ErrUnexpectedEOF)
+	require.Equal(t, http.StatusBadGateway, rw.Code)
+	require.True(t, detector.IsEjected(addrs[0]))
+}
+
+func TestClientCancellationDoesNotEjectEndpoint(t *testing.T) {
+	started := make(chan struct{}, 1)
+	blocking := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-r.Context().Done()
+	}))
+	t.Cleanup(blocking.Close)
+
+	selector := &sequenceSelector{}
+	s := &Slb{selector: selector}
+	s.health = newHealthChecker(HealthCheckConfig{}, "", selector.EndPoints)
+	s.outliers = newOutlierDetector(OutlierDetectionConfig{ConsecutiveFailures: 1}, onePool(selector.EndPoints))
+	s.retries = newRetrier(RetryPolicyConfig{})
+	s.errors = newErrorResponder(nil)
+	s.metrics = newMetrics()
+	for _, backend := range []*httptest.Server{blocking, echoBackend(t)} {
+		server := &Endpoint{Addr: backend.URL}
+		require.NoError(t, s.setServerProxy(server))
+		selector.endpoints = append(selector.endpoints, server)
+	}
+	addr := selector.endpoints[0].Addr
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-started
+		cancel()
+	}()
+	s.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx))
+	require.False(t, s.outliers.IsEjected(addr), "the client hung up, the endpoint did not fail")
+
+	// the upstream timeout is a failure of the endpoint
+	s.cfg.UpstreamTimeout = time.Millisecond * 10
+	selector.next = 0
+	s.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	require.True(t, s.outliers.IsEjected(addr))
+}
@@ -0,0 +1,44 @@
+package slb
+
+import "net/http"
+
+// responseRecorder records the status code and size of a response written to the wrapped ResponseWriter
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func newResponseRecorder(rw http.ResponseWriter) *responseRecorder {
+	return &responseRecorder{ResponseWriter: rw}
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	// informational responses precede the final response
+	if r.status == 0 && status >= http.StatusOK {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	n, err := r.ResponseWriter.Write(b)
+	r.bytes += int64(n)
+	return n, err
+}
+
+// Returns the recorded status, http.StatusOK if nothing was written as the http.Server does
+func (r *responseRecorder) Status() int {
+	if r.status == 0 {
+		return http.StatusOK
+	}
+	return r.status
+}
+
+// Unwrap allows http.ResponseController to access the wrapped ResponseWriter (e.g to flush)
+func (r *responseRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
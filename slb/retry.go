@@ -0,0 +1,255 @@
+package slb
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net"
+	"net/http"
+	"slices"
+	"sync"
+)
+
+const (
+	DefaultRetryMaxBodyBytes  = 64 << 10
+	DefaultRetryBudgetPercent = 20
+	// Requests with this header set to "true" are retried, even if their method is not idempotent
+	RetryableHeader = "X-Balance-Retryable"
+	// retries that can be saved up while there are no failures
+	retryBudgetCapacity = 10
+)
+
+var (
+	ErrInvalidRetryPolicy = func(reason string) error { return fmt.Errorf("invalid retry policy: %s", reason) }
+)
+
+// RetryPolicyConfig configures the retries of failed requests on other endpoints.
+// A request is retried if the endpoint refused the connection, or responded with one of StatusCodes,
+// and only if its method is idempotent (or it is marked with the RetryableHeader).
+// Retries are disabled if MaxRetries is 0.
+type RetryPolicyConfig struct {
+	// Maximal retries of a single request
+	MaxRetries int `json:"maxRetries,omitempty"`
+	// Status codes of responses that are retried
+	StatusCodes []int `json:"statusCodes,omitempty"`
+	// Requests with larger bodies are not retried, as their bodies are buffered for the retries
+	MaxBodyBytes int64 `json:"maxBodyBytes,omitempty"`
+	// Maximal retries in percent of the requests, so that retries cannot amplify an outage
+	BudgetPercent int `json:"budgetPercent,omitempty"`
+}
+
+// Returns true if retries are configured
+func (p RetryPolicyConfig) Enabled() bool {
+	return p.MaxRetries > 0
+}
+
+// Validates the retry policy
+func (p RetryPolicyConfig) Validate() error {
+	if p.MaxRetries < 0 {
+		return ErrInvalidRetryPolicy("max retries must not be negative")
+	}
+	if p.MaxBodyBytes < 0 {
+		return ErrInvalidRetryPolicy("max body bytes must not be negative")
+	}
+	if p.BudgetPercent < 0 || p.BudgetPercent > 100 {
+		return ErrInvalidRetryPolicy("budget percent must be between 0 and 100")
+	}
+	for _, code := range p.StatusCodes {
+		if code < 100 || code > 599 {
+			return ErrInvalidRetryPolicy(fmt.Sprintf("invalid status code %d", code))
+		}
+	}
+	return nil
+}
+
+// returns a copy of the configuration with default values for unset fields
+func (p RetryPolicyConfig) withDefaults() RetryPolicyConfig {
+	if p.MaxBodyBytes == 0 {
+		p.MaxBodyBytes = DefaultRetryMaxBodyBytes
+	}
+	if p.BudgetPercent == 0 {
+		p.BudgetPercent = DefaultRetryBudgetPercent
+	}
+	return p
+}
+
+// retrier decides which requests are retried, within its retry budget.
+// The budget is a token bucket: every request deposits BudgetPercent/100 of a token, and every retry withdraws one.
+type retrier struct {
+	cfg RetryPolicyConfig
+
+	mu     sync.Mutex
+	tokens float64
+}
+
+func newRetrier(cfg RetryPolicyConfig) *retrier {
+	return &retrier{cfg: cfg.withDefaults(), tokens: retryBudgetCapacity}
+}
+
+// Begin prepares r to be sent once per attempt, and returns false if it must not be retried.
+// The body of a retryable request is buffered, and reset by the returned func before every attempt.
+func (rt *retrier) Begin(r *http.Request) (func(), bool) {
+	noop := func() {}
+	if !rt.cfg.Enabled() {
+		return noop, false
+	}
+	rt.deposit()
+	if !idempotent(r) {
+		return noop, false
+	}
+	if r.Body == nil || r.Body == http.NoBody {
+		return noop, true
+	}
+	if r.ContentLength > rt.cfg.MaxBodyBytes {
+		return noop, false
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, rt.cfg.MaxBodyBytes+1))
+	if err != nil || int64(len(body)) > rt.cfg.MaxBodyBytes {
+		// the body is too large to be buffered, the remaining body is still sent on the first attempt
+		r.Body = readCloser{io.MultiReader(bytes.NewReader(body), r.Body), r.Body}
+		return noop, false
+	}
+	r.Body.Close()
+	reset := func() {
+		r.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	reset()
+	return reset, true
+}
+
+// Retry returns true if the attempt with the response status and proxy error is retried, given the previous attempts.
+// A retry withdraws a token from the retry budget.
+func (rt *retrier) Retry(status int, err error, attempts int) bool {
+	if attempts > rt.cfg.MaxRetries {
+		return false
+	}
+	if !connectError(err) && (err != nil || !slices.Contains(rt.cfg.StatusCodes, status)) {
+		return false
+	}
+	return rt.withdraw()
+}
+
+func (rt *retrier) deposit() {
+	defer rt.mu.Unlock()
+	rt.mu.Lock()
+	rt.tokens = min(rt.tokens+float64(rt.cfg.BudgetPercent)/100, retryBudgetCapacity)
+}
+
+func (rt *retrier) withdraw() bool {
+	defer rt.mu.Unlock()
+	rt.mu.Lock()
+	if rt.tokens < 1 {
+		return false
+	}
+	rt.tokens--
+	return true
+}
+
+// returns true if the method of r is idempotent, or r is explicitly marked as retryable
+func idempotent(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return r.Header.Get(RetryableHeader) == "true"
+}
+
+// returns true if err happened while connecting, before the request was sent
+func connectError(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// retryWriter holds back the response of an attempt until it is known that it is not retried.
+// A retried response is discarded. Once the response is passed on, its header is the header of rw,
+// so that the trailers set after the body are sent.
+type retryWriter struct {
+	rw     http.ResponseWriter
+	header http.Header
+	// decides if the response with status is retried
+	retry     func(status int, err error) bool
+	err       error
+	status    int
+	discarded bool
+}
+
+func newRetryWriter(rw http.ResponseWriter, retry func(status int, err error) bool) *retryWriter {
+	return &retryWriter{rw: rw, header: http.Header{}, retry: retry}
+}
+
+func (w *retryWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *retryWriter) WriteHeader(status int) {
+	if w.status != 0 {
+		return
+	}
+	if status < http.StatusOK {
+		// informational responses are forwarded with their own header, they precede the final response
+		header := w.rw.Header()
+		previous := header.Clone()
+		maps.Copy(header, w.header)
+		w.rw.WriteHeader(status)
+		clear(header)
+		maps.Copy(header, previous)
+		return
+	}
+	w.status = status
+	if w.retry(status, w.err) {
+		w.discarded = true
+		return
+	}
+	maps.Copy(w.rw.Header(), w.header)
+	w.header = w.rw.Header()
+	w.rw.WriteHeader(status)
+}
+
+func (w *retryWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.discarded {
+		return len(b), nil
+	}
+	return w.rw.Write(b)
+}
+
+func (w *retryWriter) Flush() {
+	if w.status == 0 || w.discarded {
+		return
+	}
+	http.NewResponseController(w.rw).Flush()
+}
+
+// records the error of the reverse proxy, before it writes its error response
+func (w *retryWriter) proxyError(err error) {
+	w.err = err
+}
+
+// Unwrap allows http.ResponseController to access the wrapped ResponseWriter
+func (w *retryWriter) Unwrap() http.ResponseWriter {
+	return w.rw
+}
+
+// records err on the retryWriter that rw wraps (if any)
+func recordProxyError(rw http.ResponseWriter, err error) {
+	for {
+		if w, ok := rw.(*retryWriter); ok {
+			w.proxyError(err)
+			return
+		}
+		unwrapper, ok := rw.(interface{ Unwrap() http.ResponseWriter })
+		if !ok {
+			return
+		}
+		rw = unwrapper.Unwrap()
+	}
+}
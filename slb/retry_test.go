@@ -0,0 +1,197 @@
+package slb
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// returns a Slb with the retry policy, that selects the backends in order
+func newRetrySlb(t *testing.T, policy RetryPolicyConfig, backends ...*httptest.Server) (*Slb, *trackerMock) {
+	selector := &trackerMock{}
+	s := &Slb{selector: selector, cfg: Config{RetryPolicy: policy}}
+	s.health = newHealthChecker(HealthCheckConfig{}, "", selector.EndPoints)
+	s.outliers = newOutlierDetector(OutlierDetectionConfig{}, onePool(selector.EndPoints))
+	s.retries = newRetrier(policy)
+	s.errors = newErrorResponder(nil)
+	s.metrics = newMetrics()
+	for _, backend := range backends {
+		server := &Endpoint{Addr: backend.URL}
+		require.NoError(t, s.setServerProxy(server))
+		selector.endpoints = append(selector.endpoints, server)
+	}
+	return s, selector
+}
+
+// returns the url of a backend that refuses connections
+func refusingBackend() *httptest.Server {
+	backend := httptest.NewServer(http.NotFoundHandler())
+	backend.Close()
+	return backend
+}
+
+func echoBackend(t *testing.T) *httptest.Server {
+	backend := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		io.Copy(rw, r.Body)
+	}))
+	t.Cleanup(backend.Close)
+	return backend
+}
+
+func TestRetryPolicyValidate(t *testing.T) {
+	require.NoError(t, RetryPolicyConfig{}.Validate())
+	require.NoError(t, RetryPolicyConfig{MaxRetries: 2, StatusCodes: []int{http.StatusServiceUnavailable}}.Validate())
+	require.Error(t, RetryPolicyConfig{MaxRetries: -1}.Validate())
+	require.Error(t, RetryPolicyConfig{MaxRetries: 1, BudgetPercent: 101}.Validate())
+	require.Error(t, RetryPolicyConfig{MaxRetries: 1, StatusCodes: []int{600}}.Validate())
+	require.Error(t, RetryPolicyConfig{MaxRetries: 1, MaxBodyBytes: -1}.Validate())
+}
+
+func TestServeHTTPRetriesConnectErrors(t *testing.T) {
+	type retryTest struct {
+		name   string
+		policy RetryPolicyConfig
+		method string
+		header http.Header
+		status int
+	}
+	scenarios := []retryTest{
+		{name: "Idempotent method", policy: RetryPolicyConfig{MaxRetries: 1}, method: http.MethodGet, status: http.StatusOK},
+		{name: "Retries disabled", policy: RetryPolicyConfig{}, method: http.MethodGet, status: http.StatusBadGateway},
+		{name: "Non idempotent method", policy: RetryPolicyConfig{MaxRetries: 1}, method: http.MethodPost, status: http.StatusBadGateway},
+		{
+			name:   "Non idempotent method marked retryable",
+			policy: RetryPolicyConfig{MaxRetries: 1},
+			method: http.MethodPost,
+			header: http.Header{RetryableHeader: []string{"true"}},
+			status: http.StatusOK,
+		},
+	}
+	for _, scenario := range scenarios {
+		t.Run(scenario.name, func(t *testing.T) {
+			s, tracker := newRetrySlb(t, scenario.policy, refusingBackend(), echoBackend(t))
+			r := httptest.NewRequest(scenario.method, "/", strings.NewReader("body"))
+			for name, values := range scenario.header {
+				r.Header[name] = values
+			}
+			rw := httptest.NewRecorder()
+			s.ServeHTTP(rw, r)
+			require.Equal(t, scenario.status, rw.Code)
+			if scenario.status == http.StatusOK {
+				require.Equal(t, "body", rw.Body.String(), "the body is sent again on the retry")
+				require.Len(t, tracker.outcomes, 2, "every attempt is reported")
+				require.Equal(t, http.StatusBadGateway, tracker.outcomes[0].StatusCode)
+			}
+		})
+	}
+}
+
+func TestServeHTTPRetriesStatusCodes(t *testing.T) {
+	unavailable := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("X-Failed", "true")
+		rw.WriteHeader(http.StatusServiceUnavailable)
+		io.WriteString(rw, "unavailable")
+	}))
+	t.Cleanup(unavailable.Close)
+
+	policy := RetryPolicyConfig{MaxRetries: 2, StatusCodes: []int{http.StatusServiceUnavailable}}
+	s, _ := newRetrySlb(t, policy, unavailable, unavailable, echoBackend(t))
+	rw := httptest.NewRecorder()
+	s.ServeHTTP(rw, httptest.NewRequest(http.MethodPut, "/", strings.NewReader("body")))
+	require.Equal(t, http.StatusOK, rw.Code)
+	require.Equal(t, "body", rw.Body.String())
+	require.Empty(t, rw.Header().Get("X-Failed"), "the retried response is discarded")
+
+	// the last attempt is returned once the retries are exhausted
+	policy.MaxRetries = 1
+	s, _ = newRetrySlb(t, policy, unavailable, unavailable, echoBackend(t))
+	rw = httptest.NewRecorder()
+	s.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "/", nil))
+	require.Equal(t, http.StatusServiceUnavailable, rw.Code)
+	require.Equal(t, "unavailable", rw.Body.String())
+	require.Equal(t, "true", rw.Header().Get("X-Failed"))
+}
+
+func TestServeHTTPSendsTrailers(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("Trailer", "X-Checksum")
+		io.WriteString(rw, "body")
+		rw.Header().Set("X-Checksum", "abc")
+	}))
+	t.Cleanup(backend.Close)
+
+	for _, policy := range []RetryPolicyConfig{{}, {MaxRetries: 1}} {
+		s, _ := newRetrySlb(t, policy, backend)
+		rw := httptest.NewRecorder()
+		s.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "/", nil))
+		require.Equal(t, "body", rw.Body.String())
+		require.Equal(t, "abc", rw.Result().Trailer.Get("X-Checksum"), "retries: %d", policy.MaxRetries)
+	}
+}
+
+// statusWriter records the written statuses with the header they were written with
+type statusWriter struct {
+	*httptest.ResponseRecorder
+	statuses []int
+	headers  []http.Header
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	w.statuses = append(w.statuses, status)
+	w.headers = append(w.headers, w.Header().Clone())
+}
+
+func TestRetryWriterForwardsInformationalResponses(t *testing.T) {
+	rw := &statusWriter{ResponseRecorder: httptest.NewRecorder()}
+	rw.Header().Set("Set-Cookie", "session")
+	writer := newRetryWriter(rw, func(int, error) bool { return false })
+	writer.Header().Set("Link", "</style.css>; rel=preload")
+	writer.WriteHeader(http.StatusEarlyHints)
+	clear(writer.Header())
+	writer.WriteHeader(http.StatusOK)
+
+	require.Equal(t, []int{http.StatusEarlyHints, http.StatusOK}, rw.statuses, "informational responses are not the final status")
+	require.Equal(t, "</style.css>; rel=preload", rw.headers[0].Get("Link"))
+	require.Empty(t, rw.headers[1].Get("Link"), "the header of the informational response is not kept")
+	require.Equal(t, "session", rw.headers[1].Get("Set-Cookie"))
+
+	recorder := newResponseRecorder(&statusWriter{ResponseRecorder: httptest.NewRecorder()})
+	recorder.WriteHeader(http.StatusContinue)
+	recorder.WriteHeader(http.StatusCreated)
+	require.Equal(t, http.StatusCreated, recorder.Status())
+}
+
+func TestServeHTTPDoesNotRetryLargeBodies(t *testing.T) {
+	s, _ := newRetrySlb(t, RetryPolicyConfig{MaxRetries: 1, MaxBodyBytes: 4}, echoBackend(t), refusingBackend())
+	rw := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPut, "/", strings.NewReader("larger body"))
+	r.ContentLength = -1
+	s.ServeHTTP(rw, r)
+	require.Equal(t, "larger body", rw.Body.String(), "the whole body is sent on the first attempt")
+
+	s, _ = newRetrySlb(t, RetryPolicyConfig{MaxRetries: 1, MaxBodyBytes: 4}, refusingBackend(), echoBackend(t))
+	rw = httptest.NewRecorder()
+	s.ServeHTTP(rw, httptest.NewRequest(http.MethodPut, "/", strings.NewReader("larger body")))
+	require.Equal(t, http.StatusBadGateway, rw.Code)
+}
+
+func TestRetryBudget(t *testing.T) {
+	retries := newRetrier(RetryPolicyConfig{MaxRetries: 1, BudgetPercent: 20})
+	for i := 0; i < retryBudgetCapacity; i++ {
+		require.True(t, retries.withdraw(), "saved up retries are available")
+	}
+	require.False(t, retries.withdraw())
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	for i := 0; i < 4; i++ {
+		retries.Begin(r)
+	}
+	require.False(t, retries.withdraw(), "4 requests allow less than one retry")
+	retries.Begin(r)
+	require.True(t, retries.withdraw(), "5 requests allow one retry")
+	require.False(t, retries.withdraw())
+}
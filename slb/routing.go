@@ -0,0 +1,147 @@
+package slb
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// DefaultPoolName is the name of the pool of Config.Endpoints,
+// which receives the requests that do not match any route
+const DefaultPoolName = "default"
+
+var (
+	ErrInvalidPool   = func(name string, reason string) error { return fmt.Errorf("invalid pool %q: %s", name, reason) }
+	ErrInvalidRoute  = func(name string, reason string) error { return fmt.Errorf("invalid route %q: %s", name, reason) }
+	ErrPoolNotFound  = func(name string) error { return fmt.Errorf("pool %q not found", name) }
+	ErrRouteNotFound = func(name string) error { return fmt.Errorf("route %q not found", name) }
+	ErrPoolInUse     = func(name string, route string) error {
+		return fmt.Errorf("pool %q is used by route %q", name, route)
+	}
+)
+
+// PoolConfig is a named pool of backend endpoints with its own selector
+type PoolConfig struct {
+	Name string `json:"name"`
+	// Backend endpoints of the pool
+	Endpoints []*Endpoint `json:"endpoints"`
+	// Discovery of endpoints, that are added to the Endpoints
+	Discovery DiscoveryConfig `json:"discovery,omitempty"`
+	// The selector of the pool
+	Selector Selector `json:"-"`
+}
+
+// Validates the pool configuration
+func (p PoolConfig) Validate() error {
+	if p.Name == "" {
+		return ErrInvalidPool(p.Name, "name is required")
+	}
+	if p.Name == DefaultPoolName {
+		return ErrInvalidPool(p.Name, "name is reserved for the endpoints of the configuration")
+	}
+	if p.Selector == nil {
+		return ErrInvalidPool(p.Name, ErrNoSelector().Error())
+	}
+	for _, server := range p.Endpoints {
+		if server.Weight < 0 {
+			return ErrInvalidWeight(server.Addr, server.Weight)
+		}
+	}
+	if err := p.Discovery.Validate(); err != nil {
+		return ErrInvalidPool(p.Name, err.Error())
+	}
+	return nil
+}
+
+// RouteConfig sends the requests that match all of its set conditions to a pool
+type RouteConfig struct {
+	Name string `json:"name"`
+	// Host of the request (without port), a leading "*." matches any subdomain
+	Host string `json:"host,omitempty"`
+	// Prefix of the request path
+	PathPrefix string `json:"pathPrefix,omitempty"`
+	// Regular expression the request path matches
+	PathRegex string `json:"pathRegex,omitempty"`
+	// Methods of the request, any method matches if none are set
+	Methods []string `json:"methods,omitempty"`
+	// Headers the request must have with the exact values
+	Headers map[string]string `json:"headers,omitempty"`
+	// Name of the pool the matching requests are sent to
+	Pool string `json:"pool"`
+}
+
+// Validates the route configuration
+func (r RouteConfig) Validate() error {
+	if r.Name == "" {
+		return ErrInvalidRoute(r.Name, "name is required")
+	}
+	if r.Pool == "" {
+		return ErrInvalidRoute(r.Name, "pool is required")
+	}
+	if _, err := regexp.Compile(r.PathRegex); err != nil {
+		return ErrInvalidRoute(r.Name, err.Error())
+	}
+	return nil
+}
+
+// route is a compiled RouteConfig
+type route struct {
+	cfg       RouteConfig
+	pathRegex *regexp.Regexp
+}
+
+func newRoute(cfg RouteConfig) (*route, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	r := &route{cfg: cfg}
+	if cfg.PathRegex != "" {
+		r.pathRegex = regexp.MustCompile(cfg.PathRegex)
+	}
+	return r, nil
+}
+
+// Returns true if req matches all conditions of the route
+func (r *route) Match(req *http.Request) bool {
+	if r.cfg.Host != "" && !matchHost(r.cfg.Host, req.Host) {
+		return false
+	}
+	if !strings.HasPrefix(req.URL.Path, r.cfg.PathPrefix) {
+		return false
+	}
+	if r.pathRegex != nil && !r.pathRegex.MatchString(req.URL.Path) {
+		return false
+	}
+	if len(r.cfg.Methods) > 0 && !contains(r.cfg.Methods, req.Method) {
+		return false
+	}
+	for name, value := range r.cfg.Headers {
+		if req.Header.Get(name) != value {
+			return false
+		}
+	}
+	return true
+}
+
+// matches host (that may have a port) with pattern, which may start with a "*." wildcard
+func matchHost(pattern string, host string) bool {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host, pattern = strings.ToLower(host), strings.ToLower(pattern)
+	if suffix, wildcard := strings.CutPrefix(pattern, "*"); wildcard {
+		return strings.HasSuffix(host, suffix) && len(host) > len(suffix)
+	}
+	return host == pattern
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,197 @@
+package slb
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// listSelector selects its endpoints in order, and supports adding and removing them
+type listSelector struct {
+	sequenceSelector
+}
+
+func (l *listSelector) Add(server *Endpoint) error {
+	l.endpoints = append(l.endpoints, server)
+	return nil
+}
+
+func (l *listSelector) Remove(server *Endpoint) error {
+	for i, endpoint := range l.endpoints {
+		if endpoint.Addr == server.Addr {
+			l.endpoints = append(l.endpoints[:i], l.endpoints[i+1:]...)
+			return nil
+		}
+	}
+	return nil
+}
+
+// returns a backend that responds with its name
+func namedBackend(t *testing.T, name string) *Endpoint {
+	backend := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		io.WriteString(rw, name)
+	}))
+	t.Cleanup(backend.Close)
+	return &Endpoint{Addr: backend.URL}
+}
+
+// returns a Slb with a default pool of a single backend named "default"
+func newRoutingSlb(t *testing.T) *Slb {
+	selector := &listSelector{}
+	s := &Slb{selector: selector, pools: make(map[string]Selector)}
+	s.health = newHealthChecker(HealthCheckConfig{}, "", s.endpoints)
+	s.outliers = newOutlierDetector(OutlierDetectionConfig{}, s.endpointsByPool)
+	s.discovery = newDiscovery("", discoveryHandler{add: s.add, remove: s.remove, setWeight: s.setWeight})
+	s.retries = newRetrier(RetryPolicyConfig{})
+	s.errors = newErrorResponder(nil)
+	s.metrics = newMetrics()
+	require.NoError(t, s.Add(namedBackend(t, DefaultPoolName)))
+	return s
+}
+
+func serve(s *Slb, r *http.Request) string {
+	rw := httptest.NewRecorder()
+	s.ServeHTTP(rw, r)
+	return rw.Body.String()
+}
+
+func TestRouteMatch(t *testing.T) {
+	type matchTest struct {
+		name    string
+		route   RouteConfig
+		request func() *http.Request
+		match   bool
+	}
+	get := func(target string) func() *http.Request {
+		return func() *http.Request { return httptest.NewRequest(http.MethodGet, target, nil) }
+	}
+	scenarios := []matchTest{
+		{name: "Empty route matches everything", route: RouteConfig{}, request: get("/any"), match: true},
+		{name: "Host", route: RouteConfig{Host: "api.example.com"}, request: get("http://API.example.com:8080/"), match: true},
+		{name: "Other host", route: RouteConfig{Host: "api.example.com"}, request: get("http://www.example.com/"), match: false},
+		{name: "Wildcard host", route: RouteConfig{Host: "*.example.com"}, request: get("http://a.b.example.com/"), match: true},
+		{name: "Wildcard does not match apex", route: RouteConfig{Host: "*.example.com"}, request: get("http://example.com/"), match: false},
+		{name: "Path prefix", route: RouteConfig{PathPrefix: "/api/"}, request: get("/api/users"), match: true},
+		{name: "Other path prefix", route: RouteConfig{PathPrefix: "/api/"}, request: get("/static/app.js"), match: false},
+		{name: "Path regex", route: RouteConfig{PathRegex: `^/users/\d+$`}, request: get("/users/42"), match: true},
+		{name: "Path regex mismatch", route: RouteConfig{PathRegex: `^/users/\d+$`}, request: get("/users/me"), match: false},
+		{name: "Method", route: RouteConfig{Methods: []string{"post", http.MethodGet}}, request: get("/"), match: true},
+		{name: "Other method", route: RouteConfig{Methods: []string{http.MethodPost}}, request: get("/"), match: false},
+		{
+			name:  "Header",
+			route: RouteConfig{Headers: map[string]string{"X-Canary": "true"}},
+			request: func() *http.Request {
+				r := get("/")()
+				r.Header.Set("X-Canary", "true")
+				return r
+			},
+			match: true,
+		},
+		{name: "Missing header", route: RouteConfig{Headers: map[string]string{"X-Canary": "true"}}, request: get("/"), match: false},
+		{
+			name:    "All conditions",
+			route:   RouteConfig{Host: "api.example.com", PathPrefix: "/v2", Methods: []string{http.MethodGet}},
+			request: get("http://api.example.com/v1/users"),
+			match:   false,
+		},
+	}
+	for _, scenario := range scenarios {
+		t.Run(scenario.name, func(t *testing.T) {
+			scenario.route.Name, scenario.route.Pool = "route", "pool"
+			route, err := newRoute(scenario.route)
+			require.NoError(t, err)
+			require.Equal(t, scenario.match, route.Match(scenario.request()))
+		})
+	}
+}
+
+func TestRoutingConfigValidate(t *testing.T) {
+	pool := PoolConfig{Name: "api", Selector: &listSelector{}}
+	route := RouteConfig{Name: "api", PathPrefix: "/api", Pool: "api"}
+	valid := func() Config {
+		return Config{Endpoints: []*Endpoint{{Addr: "localhost"}}, Pools: []PoolConfig{pool}, Routes: []RouteConfig{route}}
+	}
+
+	cfg := valid()
+	require.NoError(t, cfg.Validate())
+
+	cfg = valid()
+	cfg.Pools = append(cfg.Pools, pool)
+	require.ErrorContains(t, cfg.Validate(), ErrInvalidPool(pool.Name, "name is not unique").Error())
+
+	cfg = valid()
+	cfg.Pools[0].Name = DefaultPoolName
+	require.Error(t, cfg.Validate())
+
+	cfg = valid()
+	cfg.Pools[0].Selector = nil
+	require.Error(t, cfg.Validate())
+
+	cfg = valid()
+	cfg.Routes = append(cfg.Routes, route)
+	require.ErrorContains(t, cfg.Validate(), ErrInvalidRoute(route.Name, "name is not unique").Error())
+
+	cfg = valid()
+	cfg.Routes[0].Pool = "missing"
+	require.ErrorContains(t, cfg.Validate(), ErrPoolNotFound("missing").Error())
+
+	cfg = valid()
+	cfg.Routes[0].PathRegex = "("
+	require.Error(t, cfg.Validate())
+
+	cfg = valid()
+	cfg.Routes[0].Pool = DefaultPoolName
+	require.NoError(t, cfg.Validate())
+}
+
+func TestServeHTTPRoutesToPools(t *testing.T) {
+	s := newRoutingSlb(t)
+	require.NoError(t, s.SetPool(PoolConfig{Name: "api", Selector: &listSelector{}, Endpoints: []*Endpoint{namedBackend(t, "api")}}))
+	require.NoError(t, s.SetPool(PoolConfig{Name: "admin", Selector: &listSelector{}, Endpoints: []*Endpoint{namedBackend(t, "admin")}}))
+	require.NoError(t, s.SetRoute(RouteConfig{Name: "admin", Host: "admin.example.com", Pool: "admin"}))
+	require.NoError(t, s.SetRoute(RouteConfig{Name: "api", PathPrefix: "/api/", Pool: "api"}))
+
+	require.Equal(t, "api", serve(s, httptest.NewRequest(http.MethodGet, "/api/users", nil)))
+	require.Equal(t, "admin", serve(s, httptest.NewRequest(http.MethodGet, "http://admin.example.com/api/users", nil)), "first matching route wins")
+	require.Equal(t, DefaultPoolName, serve(s, httptest.NewRequest(http.MethodGet, "/", nil)))
+
+	endpoints, err := s.endpoints()
+	require.NoError(t, err)
+	require.Len(t, endpoints, 3, "health checks and outlier detection cover all pools")
+
+	// replacing a route keeps its position
+	require.NoError(t, s.SetRoute(RouteConfig{Name: "admin", Host: "admin.example.com", Pool: DefaultPoolName}))
+	require.Equal(t, DefaultPoolName, serve(s, httptest.NewRequest(http.MethodGet, "http://admin.example.com/api/users", nil)))
+
+	require.NoError(t, s.DeleteRoute("api"))
+	require.Equal(t, DefaultPoolName, serve(s, httptest.NewRequest(http.MethodGet, "/api/users", nil)))
+}
+
+func TestPoolAndRouteLifecycle(t *testing.T) {
+	s := newRoutingSlb(t)
+	require.ErrorContains(t, s.SetRoute(RouteConfig{Name: "api", Pool: "api"}), ErrPoolNotFound("api").Error())
+	require.ErrorContains(t, s.SetPool(PoolConfig{Name: "api"}), ErrNoSelector().Error())
+	require.ErrorContains(t, s.SetPool(PoolConfig{Name: "api", Selector: &listSelector{}, Endpoints: []*Endpoint{{Addr: "http://a", Weight: 2}}}),
+		ErrNoWeights().Error())
+
+	api := namedBackend(t, "api")
+	require.NoError(t, s.SetPool(PoolConfig{Name: "api", Selector: &listSelector{}, Endpoints: []*Endpoint{api}}))
+	require.NoError(t, s.SetRoute(RouteConfig{Name: "api", PathPrefix: "/api", Pool: "api"}))
+
+	cfg := s.Configuration()
+	require.Len(t, cfg.Pools, 1)
+	require.Equal(t, "api", cfg.Pools[0].Name)
+	require.Len(t, cfg.Pools[0].Endpoints, 1)
+	require.Equal(t, api.Addr, cfg.Pools[0].Endpoints[0].Addr)
+	require.Equal(t, []RouteConfig{{Name: "api", PathPrefix: "/api", Pool: "api"}}, cfg.Routes)
+
+	require.ErrorContains(t, s.DeletePool("api"), ErrPoolInUse("api", "api").Error())
+	require.NoError(t, s.DeleteRoute("api"))
+	require.ErrorContains(t, s.DeleteRoute("api"), ErrRouteNotFound("api").Error())
+	require.NoError(t, s.DeletePool("api"))
+	require.ErrorContains(t, s.DeletePool("api"), ErrPoolNotFound("api").Error())
+	require.Empty(t, s.Configuration().Pools)
+}
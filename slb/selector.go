@@ -1,13 +1,50 @@
 package slb
 
-import "net/http"
+import (
+	"net/http"
+	"time"
+)
 
 type EndpointsHandler interface {
-	Add(*http.Server) error
-	Remove(*http.Server) error
+	Add(*Endpoint) error
+	Remove(*Endpoint) error
 }
 type Selector interface {
-	Select() (*http.Server, error)
-	EndPoints() ([]*http.Server, error)
+	Select() (*Endpoint, error)
+	EndPoints() ([]*Endpoint, error)
 	EndpointsHandler
 }
+
+// Weighter is implemented by selectors that select endpoints by weight
+type Weighter interface {
+	SetWeight(*Endpoint, int) error
+	Weight(*Endpoint) (int, error)
+}
+
+// Outcome of a request that was proxied to an endpoint
+type Outcome struct {
+	// Time it took to proxy the request and its response
+	Duration time.Duration
+	// Status code of the response (the status of the error response, if the endpoint could not be reached)
+	StatusCode int
+	// The client ended the request before the response (e.g it disconnected),
+	// so that the duration and status code do not reflect the endpoint
+	Aborted bool
+}
+
+// Done reports the outcome of a request once it finished
+type Done func(Outcome)
+
+// Tracker is implemented by selectors that track the requests in flight to their endpoints.
+// Acquire is called before a request is proxied to the selected endpoint,
+// and the returned Done is called once the request finished.
+type Tracker interface {
+	Acquire(*Endpoint) Done
+}
+
+// RequestSelector is implemented by selectors that select endpoints by the incoming request.
+// key is the hash key of the request as configured by Config.HashKey,
+// it is salted if a previously selected endpoint was unavailable.
+type RequestSelector interface {
+	SelectRequest(r *http.Request, key string) (*Endpoint, error)
+}
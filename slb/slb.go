@@ -4,17 +4,29 @@ import (
 	"context"
 	"fmt"
 	"log/slog"
+	"maps"
+	"net"
 	"net/http"
 	"net/http/httputil"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
 	"time"
+
+	"go.opentelemetry.io/otel/trace"
 )
 
 var (
 	ErrSelectionFailed = func(err error) error { return fmt.Errorf("could not select server: %s", err) }
 	ErrFailedSetProxy  = func(err error) error { return fmt.Errorf("failed to set server proxy: %s", err) }
 	ErrNoSelector      = func() error { return fmt.Errorf("no selector provided") }
+	ErrNoWeights       = func() error { return fmt.Errorf("selector does not support weights") }
 )
 
+// cause of the cancellation of requests that exceed the upstream timeout
+var errUpstreamTimeout = fmt.Errorf("upstream timeout: %w", context.DeadlineExceeded)
+
 // SoftwareLoadBalance implements the http.Handler interface,
 // so that it itself can be used as a handler with http.Server
 // example: s := &http.Server{Handle: New(<cfg>, <selector>)}
@@ -25,8 +37,39 @@ type SoftwareLoadBalancer interface {
 type Slb struct {
 	cfg      Config
 	selector Selector
+	// selectors of the pools by name, and the routes to them (guarded by mu)
+	mu       sync.RWMutex
+	pools    map[string]Selector
+	routes   []*route
 	serveMux *http.ServeMux
-	server   *http.Server
+	// listener of the requests, it is shared with the Slb that replaces this one
+	frontend *frontend
+	// requests in flight, so that a replaced Slb can wait for them to finish
+	requests requestsInFlight
+	health   *healthChecker
+	// discovery of the endpoints of the pools by their DiscoveryConfig
+	discovery *discovery
+	outliers  *outlierDetector
+	// requests in flight by endpoint, and the drained endpoints
+	drains *drainer
+	// slow start of the endpoints that were added to the running SLB
+	slowStart *slowStarter
+	// statistics of the requests by endpoint
+	stats    *endpointStats
+	affinity *sessionAffinity
+	retries  *retrier
+	errors   *errorResponder
+	metrics  *metrics
+	// access log of the proxied requests (nil if it is disabled)
+	accessLog *accessLogger
+	// tracing of the proxied requests (nil if it is disabled)
+	tracing *tracing
+	// certificates of the frontend listener (nil if tls is disabled)
+	certificates *certificates
+	// admin server of the metrics (nil if metrics are disabled)
+	metricsServer *http.Server
+	// tls of the connections to https endpoints by identity, instead of cfg.UpstreamTLS (guarded by mu)
+	endpointTLS map[string]UpstreamTLSConfig
 	SoftwareLoadBalancer
 }
 
@@ -40,77 +83,673 @@ func New(config Config, selector Selector) (*Slb, error) {
 	}
 
 	s := &Slb{
-		cfg: config,
+		cfg:         config,
+		pools:       make(map[string]Selector),
+		endpointTLS: maps.Clone(config.EndpointTLS),
 	}
 	s.selector = selector
-
-	setServerProxy := func(server *http.Server, listenPort string) error {
-		url, err := resolveAddress(server.Addr, listenPort)
-		if err != nil {
-			return err
-		}
-		server.Addr = url.String()
-
-		proxyHandler := httputil.NewSingleHostReverseProxy(url)
-		server.Handler = proxyHandler
-		return nil
+	s.health = newHealthChecker(s.cfg.HealthCheck, s.cfg.ListenPort, s.endpoints)
+	s.outliers = newOutlierDetector(s.cfg.OutlierDetection, s.endpointsByPool)
+	s.drains = newDrainer()
+	s.slowStart = newSlowStarter(s.cfg.SlowStart)
+	s.stats = newEndpointStats()
+	s.discovery = newDiscovery(s.cfg.ListenPort, discoveryHandler{
+		add:       s.add,
+		remove:    s.remove,
+		resolve:   s.resolveServerAddress,
+		setWeight: s.setWeight,
+		slowStart: func(server *Endpoint) { s.slowStart.Start(server.Identity(), s.cfg.SlowStart.Duration) },
+	})
+	s.affinity = newSessionAffinity(s.cfg.SessionAffinity)
+	s.retries = newRetrier(s.cfg.RetryPolicy)
+	s.errors = newErrorResponder(s.cfg.ErrorResponses)
+	s.metrics = newMetrics()
+	s.metrics.health = newHealthCollector(s)
+	s.metrics.registry.MustRegister(s.metrics.health)
+	s.metricsServer = s.newMetricsServer()
+	var err error
+	if s.accessLog, err = newAccessLogger(s.cfg.AccessLog); err != nil {
+		return nil, err
+	}
+	if s.tracing, err = newTracing(s.cfg.Tracing); err != nil {
+		return nil, err
+	}
+	if s.certificates, err = newCertificates(s.cfg.TLS); err != nil {
+		return nil, err
 	}
 
-	for _, server := range s.cfg.Endpoints {
-		if err := s.selector.Add(server); err != nil {
+	if err := s.addEndpoints(s.selector, s.cfg.Endpoints); err != nil {
+		return nil, err
+	}
+	if err := s.discovery.Set(DefaultPoolName, s.cfg.Discovery, s.selector); err != nil {
+		return nil, err
+	}
+	for _, pool := range s.cfg.Pools {
+		if err := s.SetPool(pool); err != nil {
 			return nil, err
 		}
-		if err := setServerProxy(server, config.ListenPort); err != nil {
-			return nil, ErrFailedSetProxy(err)
+	}
+	for _, route := range s.cfg.Routes {
+		if err := s.SetRoute(route); err != nil {
+			return nil, err
 		}
 	}
 
 	s.serveMux = http.NewServeMux()
 	s.serveMux.Handle(s.cfg.Postfix(), s)
-	s.server = &http.Server{Addr: s.cfg.Address(), Handler: s.serveMux}
+	s.frontend = newFrontend(s)
 	return s, nil
 }
 
 // Runs the SLB with a server that listens to requests on the ListenAddress, and ListenPort.
-// The server is proxying the requests to the backend servers.
+// The server is proxying the requests to the backend servers, and terminates TLS if certificates are configured.
+// It blocks until the server is stopped, which may be by an Slb that replaced this one.
 func (s *Slb) Run() error {
-	defer s.server.Close()
-
-	slog.Info("SLB started at: " + s.server.Addr + s.cfg.Postfix())
+	ln, err := s.frontend.listen()
+	if err != nil {
+		slog.Error(err.Error())
+		return err
+	}
+	return s.serveOn(ln)
+}
 
-	err := s.server.ListenAndServe()
+// starts the background jobs, and serves the requests of ln until the server is stopped
+func (s *Slb) serveOn(ln net.Listener) error {
+	s.start()
+	slog.Info("SLB started at: " + s.frontend.server.Addr + s.cfg.Postfix())
+	err := s.frontend.serve(ln)
 	if err != nil {
 		slog.Error(err.Error())
 	}
 	return err
 }
 
-// ServeHTTP wraps the endpoint selection and backend ServerHTTP call so that it can be used as a http.HandlerFunc / by server Mux
+// starts the health checks, the discovery, the reloads of the certificates and the metrics server
+func (s *Slb) start() {
+	s.health.Start()
+	s.discovery.Start()
+	s.certificates.Start()
+	go s.serveMetrics()
+}
+
+// stops the background jobs that were started by start
+func (s *Slb) stopJobs() {
+	s.certificates.Stop()
+	s.stopMetrics()
+	s.discovery.Stop()
+	s.health.Stop()
+	s.drains.Stop()
+}
+
+// Returns true if the SLB serves the requests of its listener
+func (s *Slb) Running() bool {
+	return s.frontend.listening.Load() && s.frontend.current.Load() == s
+}
+
+// Replaces the running SLB by next, a new SLB that was not run (e.g with a changed configuration).
+// New requests are served by next, while the requests in flight are finished by this SLB before it is stopped.
+// next takes over the metrics of this SLB, and serves its listener if the listen address is unchanged, with its own tls settings.
+// Otherwise next serves its own address before this SLB stops listening, and this SLB keeps running if that fails.
+// If this SLB is not running, it is stopped and next has to be run.
+func (s *Slb) Replace(next *Slb) error {
+	if !s.Running() {
+		return s.Stop()
+	}
+	if err := next.adoptMetrics(s); err != nil {
+		return err
+	}
+	if s.frontend.accepts(next.cfg) {
+		next.frontend = s.frontend
+		// the jobs of this SLB are stopped first, as next serves the metrics on the same address
+		s.stopJobs()
+		next.frontend.current.Store(next)
+		next.start()
+		slog.Info("SLB reconfigured at: " + next.frontend.server.Addr + next.cfg.Postfix())
+		return s.Stop()
+	}
+	if err := next.frontend.serveInBackground(); err != nil {
+		s.metrics.health.slb.Store(s)
+		return err
+	}
+	s.stopJobs()
+	next.start()
+	slog.Info("SLB started at: " + next.frontend.server.Addr + next.cfg.Postfix())
+	return s.Stop()
+}
+
+// ServeHTTP wraps the endpoint selection and backend ServerHTTP call so that it can be used as a http.HandlerFunc / by server Mux.
+// Requests are traced and recorded in the access log, if they are enabled.
 func (s *Slb) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
-	server, err := s.selector.Select()
+	s.tracing.Handle(rw, r, func(rw http.ResponseWriter, r *http.Request) {
+		s.accessLog.Handle(rw, r, s.proxy)
+	})
+}
+
+// proxies the request to an endpoint of the pool it is routed to.
+// Failed attempts of retryable requests are retried on other endpoints, as configured by the retry policy.
+func (s *Slb) proxy(rw http.ResponseWriter, r *http.Request) {
+	pool, selector := s.route(r)
+	accessLogEntryOf(r).routed(pool)
+	server, err := s.selectSessionEndpoint(rw, r, selector)
 	if err != nil {
 		slog.Error(ErrSelectionFailed(err).Error())
+		kind := selectionErrorKind(selector)
+		s.metrics.selectionErrors.WithLabelValues(pool, string(kind)).Inc()
+		s.errors.Write(rw, kind, err)
+		return
+	}
+
+	resetBody, retryable := s.retries.Begin(r)
+	if !retryable {
+		s.serve(rw, r, pool, selector, server)
+		return
+	}
+	tried := []*Endpoint{server}
+	for {
+		var next *Endpoint
+		writer := newRetryWriter(rw, func(status int, err error) bool {
+			if !s.retries.Retry(status, err, len(tried)) {
+				return false
+			}
+			next, err = s.selectEndpoint(r, selector, tried...)
+			return err == nil
+		})
+		s.serve(writer, r, pool, selector, server)
+		if next == nil {
+			return
+		}
+		slog.Warn(fmt.Sprintf("retrying request to %s on %s", server.Addr, next.Addr))
+		s.metrics.retries.WithLabelValues(pool).Inc()
+		server = next
+		tried = append(tried, server)
+		resetBody()
+		if s.cfg.SessionAffinity.Enabled() {
+			rw.Header().Del("Set-Cookie")
+			http.SetCookie(rw, s.affinity.Cookie(r, server.Identity()))
+		}
+	}
+}
+
+// proxies r to server of pool within the upstream timeout,
+// and reports the outcome to the selector, the metrics, the trace and the access log
+func (s *Slb) serve(rw http.ResponseWriter, r *http.Request, pool string, selector Selector, server *Endpoint) {
+	if s.cfg.UpstreamTimeout > 0 {
+		ctx, cancel := context.WithTimeoutCause(r.Context(), s.cfg.UpstreamTimeout, errUpstreamTimeout)
+		defer cancel()
+		r = r.WithContext(ctx)
+	}
+	r, end := s.tracing.Attempt(r, pool, server)
+	done := acquire(selector, server)
+	defer s.drains.Track(server.Identity())()
+	track := s.metrics.Track(pool, server.Identity())
+	recorder := newResponseRecorder(rw)
+	start := time.Now()
+	defer func() {
+		outcome := Outcome{Duration: time.Since(start), StatusCode: recorder.Status(), Aborted: clientAborted(r, nil)}
+		done(outcome)
+		track(outcome)
+		s.stats.Record(server.Identity(), outcome)
+		end(outcome)
+		accessLogEntryOf(r).attempt(server.Addr, outcome.Duration)
+	}()
+	server.Handler.ServeHTTP(recorder, r)
+}
+
+// returns the kind of a selection error of selector
+func selectionErrorKind(selector Selector) ErrorKind {
+	endpoints, err := selector.EndPoints()
+	if err != nil || len(endpoints) == 0 {
+		return ErrorNoEndpoints
+	}
+	return ErrorAllUnhealthy
+}
+
+// reports a request to server to the selector, if it implements Tracker
+func acquire(selector Selector, server *Endpoint) Done {
+	if tracker, ok := selector.(Tracker); ok {
+		return tracker.Acquire(server)
+	}
+	return func(Outcome) {}
+}
+
+// Sets the proxy handler of a backend endpoint, and adds it to the selector of the default pool.
+// The endpoint is ramped up in the configured slow start window.
+func (s *Slb) Add(server *Endpoint) error {
+	return s.addSlowStart(s.selector, server, s.cfg.SlowStart.Duration)
+}
+
+// Removes a backend endpoint from the selector of the default pool
+func (s *Slb) Remove(server *Endpoint) error {
+	if err := s.resolveServerAddress(server); err != nil {
+		return err
+	}
+	return s.remove(s.selector, server)
+}
+
+func (s *Slb) remove(selector Selector, server *Endpoint) error {
+	if err := selector.Remove(server); err != nil {
+		return err
+	}
+	s.outliers.Forget(server.Identity())
+	s.slowStart.Forget(server.Identity())
+	s.stats.Forget(server.Identity())
+	s.metrics.Forget(server.Identity())
+	return nil
+}
+
+// Sets the weight of a backend endpoint of the default pool, if the selector implements Weighter
+func (s *Slb) SetWeight(server *Endpoint, weight int) error {
+	return s.setWeight(s.selector, server, weight)
+}
+
+// Creates or replaces a pool of endpoints, that routes can send requests to
+func (s *Slb) SetPool(pool PoolConfig) error {
+	if err := pool.Validate(); err != nil {
+		return err
+	}
+	if err := s.addEndpoints(pool.Selector, pool.Endpoints); err != nil {
+		return err
+	}
+	if err := s.discovery.Set(pool.Name, pool.Discovery, pool.Selector); err != nil {
+		return err
+	}
+	defer s.mu.Unlock()
+	s.mu.Lock()
+	s.pools[pool.Name] = pool.Selector
+	return nil
+}
+
+// Deletes a pool that is not used by any route
+func (s *Slb) DeletePool(name string) error {
+	if err := s.deletePool(name); err != nil {
+		return err
+	}
+	// outside of the lock, as running discoveries add endpoints with it
+	s.discovery.Delete(name)
+	return nil
+}
+
+func (s *Slb) deletePool(name string) error {
+	defer s.mu.Unlock()
+	s.mu.Lock()
+	if _, ok := s.pools[name]; !ok {
+		return ErrPoolNotFound(name)
+	}
+	for _, route := range s.routes {
+		if route.cfg.Pool == name {
+			return ErrPoolInUse(name, route.cfg.Name)
+		}
+	}
+	delete(s.pools, name)
+	return nil
+}
+
+// Creates or replaces (by name) a route to a pool.
+// New routes are matched after the existing routes.
+func (s *Slb) SetRoute(cfg RouteConfig) error {
+	route, err := newRoute(cfg)
+	if err != nil {
+		return err
+	}
+	defer s.mu.Unlock()
+	s.mu.Lock()
+	if _, ok := s.pools[cfg.Pool]; !ok && cfg.Pool != DefaultPoolName {
+		return ErrPoolNotFound(cfg.Pool)
+	}
+	for i, r := range s.routes {
+		if r.cfg.Name == cfg.Name {
+			s.routes[i] = route
+			return nil
+		}
+	}
+	s.routes = append(s.routes, route)
+	return nil
+}
+
+// Deletes a route
+func (s *Slb) DeleteRoute(name string) error {
+	defer s.mu.Unlock()
+	s.mu.Lock()
+	for i, r := range s.routes {
+		if r.cfg.Name == name {
+			s.routes = append(s.routes[:i], s.routes[i+1:]...)
+			return nil
+		}
+	}
+	return ErrRouteNotFound(name)
+}
+
+// returns the name and selector of the pool of the first route matching r, or of the default pool
+func (s *Slb) route(r *http.Request) (string, Selector) {
+	defer s.mu.RUnlock()
+	s.mu.RLock()
+	for _, route := range s.routes {
+		if !route.Match(r) {
+			continue
+		}
+		if selector, ok := s.pools[route.cfg.Pool]; ok {
+			return route.cfg.Pool, selector
+		}
+		break
+	}
+	return DefaultPoolName, s.selector
+}
+
+// returns the endpoints of all pools
+func (s *Slb) endpoints() ([]*Endpoint, error) {
+	pools, err := s.endpointsByPool()
+	if err != nil {
+		return nil, err
+	}
+	return slices.Concat(pools...), nil
+}
+
+// returns the endpoints of every pool, starting with the default pool
+func (s *Slb) endpointsByPool() ([][]*Endpoint, error) {
+	defaultEndpoints, err := s.selector.EndPoints()
+	if err != nil {
+		return nil, err
+	}
+	pools := [][]*Endpoint{defaultEndpoints}
+	defer s.mu.RUnlock()
+	s.mu.RLock()
+	for _, selector := range s.pools {
+		poolEndpoints, err := selector.EndPoints()
+		if err != nil {
+			return nil, err
+		}
+		pools = append(pools, poolEndpoints)
+	}
+	return pools, nil
+}
+
+// adds endpoints to selector
+func (s *Slb) addEndpoints(selector Selector, endpoints []*Endpoint) error {
+	for _, server := range endpoints {
+		if err := s.add(selector, server); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// adds server to selector with its weight, which requires a selector that implements Weighter if it is set
+func (s *Slb) add(selector Selector, server *Endpoint) error {
+	if server.Weight < 0 {
+		return ErrInvalidWeight(server.Addr, server.Weight)
+	}
+	if _, ok := selector.(Weighter); !ok && server.Weight > 1 {
+		return ErrNoWeights()
+	}
+	if err := s.setServerProxy(server); err != nil {
+		return ErrFailedSetProxy(err)
+	}
+	return selector.Add(server)
+}
+
+func (s *Slb) setWeight(selector Selector, server *Endpoint, weight int) error {
+	weighter, ok := selector.(Weighter)
+	if !ok {
+		return ErrNoWeights()
+	}
+	if weight <= 0 {
+		return ErrInvalidWeight(server.Addr, weight)
+	}
+	if err := s.resolveServerAddress(server); err != nil {
+		return err
+	}
+	return weighter.SetWeight(server, weight)
+}
+
+// sets the server address to its resolved url (if it is not resolved already)
+func (s *Slb) resolveServerAddress(server *Endpoint) error {
+	url, err := endpointURL(server.Addr, s.cfg.ListenPort)
+	if err != nil {
+		return err
+	}
+	server.Addr = url.String()
+	return nil
+}
+
+// resolves the server address, and sets a reverse proxy to it as the server handler
+// Sets the tls of the connections to the https endpoint id, it applies to endpoints that are added afterwards
+func (s *Slb) SetEndpointTLS(id string, cfg UpstreamTLSConfig) error {
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	defer s.mu.Unlock()
+	s.mu.Lock()
+	if s.endpointTLS == nil {
+		s.endpointTLS = make(map[string]UpstreamTLSConfig)
+	}
+	s.endpointTLS[id] = cfg
+	return nil
+}
+
+// returns the tls of the connections to the endpoint id
+func (s *Slb) upstreamTLS(id string) UpstreamTLSConfig {
+	defer s.mu.RUnlock()
+	s.mu.RLock()
+	if cfg, ok := s.endpointTLS[id]; ok {
+		return cfg
+	}
+	return s.cfg.UpstreamTLS
+}
+
+func (s *Slb) setServerProxy(server *Endpoint) error {
+	url, err := endpointURL(server.Addr, s.cfg.ListenPort)
+	if err != nil {
+		return err
+	}
+	server.Addr = url.String()
+
+	proxyHandler := httputil.NewSingleHostReverseProxy(url)
+	if url.Scheme == "https" {
+		if proxyHandler.Transport, err = s.upstreamTLS(server.Identity()).transport(); err != nil {
+			return err
+		}
+	}
+	id := server.Identity()
+	proxyHandler.ModifyResponse, proxyHandler.ErrorHandler = s.outliers.proxyHooks(id,
+		func(rw http.ResponseWriter, r *http.Request, err error) {
+			// the retry writer needs the error to decide if the attempt is retried
+			recordProxyError(rw, err)
+			kind := proxyErrorKind(err)
+			s.metrics.upstreamErrors.WithLabelValues(id, string(kind)).Inc()
+			trace.SpanFromContext(r.Context()).RecordError(err)
+			s.errors.Write(rw, kind, err)
+		})
+	server.Handler = proxyHandler
+	return nil
+}
+
+// selectEndpoint selects the next healthy endpoint for r from selector, that is not ejected and was not tried before.
+// Every endpoint is given at most one chance, before giving up on selection.
+// Endpoints in their slow start window are skipped by chance, but selected if no other endpoint is available.
+func (s *Slb) selectEndpoint(r *http.Request, selector Selector, tried ...*Endpoint) (*Endpoint, error) {
+	endpoints, err := selector.EndPoints()
+	if err != nil {
+		return nil, err
+	}
+	requestSelector, byRequest := selector.(RequestSelector)
+	key := s.cfg.HashKey.Key(r)
+	var starting *Endpoint
+	for attempt := 0; attempt <= len(endpoints); attempt++ {
+		var server *Endpoint
+		if byRequest {
+			server, err = requestSelector.SelectRequest(r, saltKey(key, attempt))
+		} else {
+			server, err = selector.Select()
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !s.available(server) || slices.Contains(tried, server) {
+			continue
+		}
+		if s.slowStart.Accept(server) {
+			return server, nil
+		}
+		if starting == nil {
+			starting = server
+		}
+	}
+	if starting != nil {
+		return starting, nil
+	}
+	return nil, ErrNoHealthyEndpoints()
+}
+
+// selectSessionEndpoint selects the endpoint of the affinity cookie of r from selector, if it is available.
+// Otherwise it selects the next endpoint, and sets the affinity cookie for it on rw.
+// Cookies with a TTL are re-issued on every request, so that active sessions do not expire.
+func (s *Slb) selectSessionEndpoint(rw http.ResponseWriter, r *http.Request, selector Selector) (*Endpoint, error) {
+	if !s.cfg.SessionAffinity.Enabled() {
+		return s.selectEndpoint(r, selector)
+	}
+	if id, ok := s.affinity.Endpoint(r); ok {
+		endpoints, err := selector.EndPoints()
+		if err != nil {
+			return nil, err
+		}
+		for _, server := range endpoints {
+			if s.affinity.ID(server.Identity()) == id && s.available(server) {
+				if s.cfg.SessionAffinity.TTL > 0 {
+					http.SetCookie(rw, s.affinity.Cookie(r, server.Identity()))
+				}
+				return server, nil
+			}
+		}
+	}
+	server, err := s.selectEndpoint(r, selector)
+	if err != nil {
+		return nil, err
+	}
+	http.SetCookie(rw, s.affinity.Cookie(r, server.Identity()))
+	return server, nil
+}
+
+// returns true if server is healthy, not ejected and not drained
+func (s *Slb) available(server *Endpoint) bool {
+	id := server.Identity()
+	return s.health.IsHealthy(id) && !s.outliers.IsEjected(id) && !s.drains.IsDraining(id)
+}
+
+// returns key for the first attempt, and a salted key for the following attempts
+func saltKey(key string, attempt int) string {
+	if attempt == 0 {
+		return key
 	}
-	server.Handler.ServeHTTP(rw, r)
+	return key + "#" + strconv.Itoa(attempt)
 }
 
 // Gracefully stops the SLB server, if it cannot gracefully shut down, it will stop it immediately
+// A replaced SLB does not stop the listener it handed over, it waits for its requests in flight to finish.
 func (s *Slb) Stop() error {
-	defer s.server.Close()
 	ctx, cancelFunc := context.WithTimeout(context.Background(), time.Second*10)
 	defer cancelFunc()
+	defer s.stopJobs()
+	defer s.accessLog.Close()
+	defer s.tracing.Shutdown(ctx)
 
 	slog.Info("SLB stopping")
-	return s.server.Shutdown(ctx)
+	if s.frontend.current.Load() != s {
+		s.drain(ctx)
+		return nil
+	}
+	defer s.frontend.server.Close()
+	s.frontend.listening.Store(false)
+	return s.frontend.server.Shutdown(ctx)
+}
+
+// waits until the requests in flight are finished, or ctx is done
+func (s *Slb) drain(ctx context.Context) {
+	select {
+	case <-s.requests.drained():
+	case <-ctx.Done():
+	}
+}
+
+// Reloads the certificates of the frontend listener, e.g after they were renewed.
+// Open connections are not affected, and the current certificates are kept if any fails to load.
+func (s *Slb) ReloadCertificates() error {
+	return s.certificates.Reload()
 }
 
-// returns the current configuration of the SLB with updated endpoints
+// Returns the tracer of the proxied requests, e.g to trace other calls of the SLB (a noop tracer if tracing is disabled)
+func (s *Slb) Tracer() trace.Tracer {
+	return s.tracing.Tracer()
+}
+
+// returns the current configuration of the SLB with updated endpoints, their weights, health state, labels and statistics,
+// the pools and routes. Secrets (e.g the session affinity signing key and private keys) are omitted.
 func (s *Slb) Configuration() Config {
 	cfg := s.cfg
-	var err error
-	cfg.Endpoints, err = s.selector.EndPoints()
+	health, metadata := s.health.Status(), s.discovery.Metadata()
+	endpoints, err := s.selector.EndPoints()
 	if err != nil {
 		slog.Error("could not update endpoints list")
+		endpoints = s.cfg.Endpoints
+	}
+	cfg.Endpoints = s.describe(s.selector, endpoints, health, metadata)
+	cfg.SessionAffinity.SigningKey = ""
+	cfg.TLS.Certificates = slices.Clone(cfg.TLS.Certificates)
+	for i := range cfg.TLS.Certificates {
+		cfg.TLS.Certificates[i].KeyPEM = ""
+	}
+	cfg.DiscoveryStatus = s.discovery.Status()
+	cfg.Draining = s.drains.Status()
+
+	defer s.mu.RUnlock()
+	s.mu.RLock()
+	cfg.EndpointTLS = maps.Clone(s.endpointTLS)
+	cfg.Pools = make([]PoolConfig, 0, len(s.pools))
+	for name, selector := range s.pools {
+		endpoints, err := selector.EndPoints()
+		if err != nil {
+			slog.Error("could not update endpoints list of pool " + name)
+		}
+		cfg.Pools = append(cfg.Pools, PoolConfig{
+			Name:      name,
+			Endpoints: s.describe(selector, endpoints, health, metadata),
+			Discovery: s.discovery.Config(name),
+			Selector:  selector,
+		})
+	}
+	sort.Slice(cfg.Pools, func(i, j int) bool { return cfg.Pools[i].Name < cfg.Pools[j].Name })
+	cfg.Routes = make([]RouteConfig, 0, len(s.routes))
+	for _, route := range s.routes {
+		cfg.Routes = append(cfg.Routes, route.cfg)
+	}
+	return cfg
+}
+
+// returns copies of the endpoints of selector with their weights (if selector implements Weighter),
+// health state, statistics and the discovered metadata as labels, which are all kept by identity
+func (s *Slb) describe(selector Selector, endpoints []*Endpoint, health map[string]HealthStatus, metadata map[string]map[string]string) []*Endpoint {
+	weighter, weighted := selector.(Weighter)
+	described := make([]*Endpoint, 0, len(endpoints))
+	for _, server := range endpoints {
+		endpoint := server.clone()
+		endpoint.Weight = 0
+		if weighted {
+			if weight, err := weighter.Weight(server); err == nil {
+				endpoint.Weight = weight
+			}
+		}
+		if status, ok := health[server.Identity()]; ok {
+			endpoint.Health = &status
+		}
+		if labels, ok := metadata[server.Identity()]; ok {
+			if endpoint.Labels == nil {
+				endpoint.Labels = make(map[string]string, len(labels))
+			}
+			maps.Copy(endpoint.Labels, labels)
+		}
+		stats := s.stats.Get(server.Identity())
+		stats.InFlight = s.drains.InFlight(server.Identity())
+		endpoint.Stats = &stats
+		described = append(described, endpoint)
 	}
-	return s.cfg
+	return described
 }
@@ -2,10 +2,12 @@ package slb
 
 import (
 	"balance/internal/mock"
+	"context"
 	"fmt"
 	"io"
 	"log/slog"
 	"net/http"
+	"net/http/httptest"
 	"net/http/httputil"
 	"net/url"
 	"strings"
@@ -27,15 +29,19 @@ type SelectorMock struct {
 	err              error
 }
 
-func (s *SelectorMock) Add(*http.Server) error {
+func (s *SelectorMock) Add(*Endpoint) error {
 	return nil
 }
 
-func (s *SelectorMock) Remove(*http.Server) error {
+func (s *SelectorMock) Remove(*Endpoint) error {
 	return nil
 }
 
-func (s *SelectorMock) Select() (*http.Server, error) {
+func (s *SelectorMock) EndPoints() ([]*Endpoint, error) {
+	return []*Endpoint{}, nil
+}
+
+func (s *SelectorMock) Select() (*Endpoint, error) {
 	url := &url.URL{}
 	transport := mock.TransPortResponseFunc(func(req *http.Request) (*http.Response, error) {
 		r := &s.expectedResponse
@@ -45,7 +51,16 @@ func (s *SelectorMock) Select() (*http.Server, error) {
 	})
 	proxyHandler := httputil.NewSingleHostReverseProxy(url)
 	proxyHandler.Transport = transport
-	return &http.Server{Handler: proxyHandler}, s.err
+	return &Endpoint{Handler: proxyHandler}, s.err
+}
+
+// returns n endpoints with random addresses
+func generateEndpoints(n int) []*Endpoint {
+	endpoints := []*Endpoint{}
+	for i := 0; i < n; i++ {
+		endpoints = append(endpoints, &Endpoint{Addr: mock.RandomAddress(), Handler: http.NewServeMux()})
+	}
+	return endpoints
 }
 
 func (s slbTest) Run() {
@@ -69,7 +84,7 @@ func TestSLB(t *testing.T) {
 			name: "New: No Selector",
 			t:    t,
 			testFunc: func(t *testing.T) {
-				mockServers := mock.GenerateServers(3)
+				mockServers := generateEndpoints(3)
 				_, err := New(Config{Endpoints: mockServers}, nil)
 				require.Error(t, err)
 				require.Exactly(t, err, ErrNoSelector())
@@ -79,7 +94,7 @@ func TestSLB(t *testing.T) {
 			name: "Config: Bad Listen Address",
 			t:    t,
 			testFunc: func(t *testing.T) {
-				mockServers := mock.GenerateServers(1)
+				mockServers := generateEndpoints(1)
 				_, err := New(Config{Endpoints: mockServers, ListenAddress: badParseString}, &SelectorMock{})
 				require.Error(t, err)
 				require.Containsf(t, err.Error(), ErrFailedToParseServerUrl(fmt.Errorf("")).Error(), "")
@@ -89,7 +104,7 @@ func TestSLB(t *testing.T) {
 			name: "Config: Bad Backened Address",
 			t:    t,
 			testFunc: func(t *testing.T) {
-				mockServers := mock.GenerateServers(1)
+				mockServers := generateEndpoints(1)
 				for _, m := range mockServers {
 					m.Addr = badParseString
 				}
@@ -98,12 +113,44 @@ func TestSLB(t *testing.T) {
 				require.Containsf(t, err.Error(), ErrFailedToParseServerUrl(fmt.Errorf("")).Error(), "")
 			},
 		},
+		{
+			name: "Config: Bad Weight",
+			t:    t,
+			testFunc: func(t *testing.T) {
+				mockServers := generateEndpoints(1)
+				mockServers[0].Weight = -1
+				_, err := New(Config{Endpoints: mockServers}, &SelectorMock{})
+				require.Error(t, err)
+				require.Exactly(t, ErrInvalidWeight(mockServers[0].Addr, -1), err)
+			},
+		},
+		{
+			name: "New: Default weight without Weighter",
+			t:    t,
+			testFunc: func(t *testing.T) {
+				mockServers := generateEndpoints(1)
+				mockServers[0].Weight = 1
+				_, err := New(Config{Endpoints: mockServers}, &SelectorMock{})
+				require.NoError(t, err)
+			},
+		},
+		{
+			name: "New: Weights without Weighter",
+			t:    t,
+			testFunc: func(t *testing.T) {
+				mockServers := generateEndpoints(1)
+				mockServers[0].Weight = 2
+				_, err := New(Config{Endpoints: mockServers}, &SelectorMock{})
+				require.Error(t, err)
+				require.Exactly(t, ErrNoWeights(), err)
+			},
+		},
 		{
 			name: "Run Happy Flow",
 			t:    t,
 			testFunc: func(t *testing.T) {
 				// Generate mock servers
-				mockServers := mock.GenerateServers(3)
+				mockServers := generateEndpoints(3)
 				// Chose a random but realistic port range
 				listenPort := mock.RandomPort()
 				listenAddress := "localhost"
@@ -150,3 +197,115 @@ func TestSLB(t *testing.T) {
 		t.Run(scenario.name, func(t *testing.T) { scenario.Run() })
 	}
 }
+
+// trackerMock records the outcomes of the requests to its only endpoint
+type trackerMock struct {
+	sequenceSelector
+	inFlight int
+	outcomes []Outcome
+}
+
+func (t *trackerMock) Acquire(*Endpoint) Done {
+	t.inFlight++
+	return func(o Outcome) {
+		t.inFlight--
+		t.outcomes = append(t.outcomes, o)
+	}
+}
+
+func TestServeHTTPReportsOutcomeToTracker(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.WriteHeader(http.StatusTeapot)
+	}))
+	t.Cleanup(backend.Close)
+
+	selector := &trackerMock{}
+	s := &Slb{selector: selector}
+	s.health = newHealthChecker(HealthCheckConfig{}, "", selector.EndPoints)
+	s.outliers = newOutlierDetector(OutlierDetectionConfig{}, onePool(selector.EndPoints))
+	s.retries = newRetrier(RetryPolicyConfig{})
+	s.errors = newErrorResponder(nil)
+	s.metrics = newMetrics()
+	server := &Endpoint{Addr: backend.URL}
+	require.NoError(t, s.setServerProxy(server))
+	selector.endpoints = []*Endpoint{server}
+
+	rw := httptest.NewRecorder()
+	s.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "/", nil))
+	require.Equal(t, http.StatusTeapot, rw.Code)
+	require.Zero(t, selector.inFlight)
+	require.Len(t, selector.outcomes, 1)
+	require.Equal(t, http.StatusTeapot, selector.outcomes[0].StatusCode)
+	require.Positive(t, selector.outcomes[0].Duration)
+}
+
+func TestServeHTTPReportsAbortedOutcome(t *testing.T) {
+	started := make(chan struct{}, 1)
+	backend := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-r.Context().Done()
+	}))
+	t.Cleanup(backend.Close)
+	s, tracker := newRetrySlb(t, RetryPolicyConfig{}, backend)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-started
+		cancel()
+	}()
+	s.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx))
+	require.Len(t, tracker.outcomes, 1)
+	require.True(t, tracker.outcomes[0].Aborted, "the client cancelled the request")
+
+	s.cfg.UpstreamTimeout = time.Millisecond * 10
+	s.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	require.Len(t, tracker.outcomes, 2)
+	require.False(t, tracker.outcomes[1].Aborted, "the endpoint exceeded the upstream timeout")
+	require.Equal(t, http.StatusGatewayTimeout, tracker.outcomes[1].StatusCode)
+}
+
+func TestEndpointStateIsKeptByIdentity(t *testing.T) {
+	backend := echoBackend(t)
+	blue, green := &Endpoint{ID: "blue", Addr: backend.URL}, &Endpoint{ID: "green", Addr: backend.URL}
+	selector := &setSelector{}
+	s, err := New(Config{
+		Endpoints:        []*Endpoint{blue, green},
+		ListenAddress:    "localhost",
+		HealthCheck:      HealthCheckConfig{Path: "/health", UnhealthyThreshold: 1},
+		OutlierDetection: OutlierDetectionConfig{ConsecutiveFailures: 1},
+	}, selector)
+	require.NoError(t, err)
+	for i := 0; i < 2; i++ {
+		rw := httptest.NewRecorder()
+		s.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "/", nil))
+		require.Equal(t, http.StatusOK, rw.Code)
+	}
+
+	s.health.record(blue.Identity(), fmt.Errorf("probe failed"))
+	s.outliers.Failure(green.Identity())
+	s.slowStart.Start(blue.Identity(), time.Minute)
+	require.False(t, s.available(blue))
+	require.False(t, s.available(green))
+	require.False(t, s.health.IsHealthy(blue.Identity()))
+	require.True(t, s.health.IsHealthy(green.Identity()), "endpoints on the same address have their own health")
+	require.False(t, s.outliers.IsEjected(blue.Identity()), "endpoints on the same address have their own ejection")
+	require.Equal(t, 1.0, s.slowStart.Factor(green.Identity()))
+
+	endpoints := s.Configuration().Endpoints
+	require.Len(t, endpoints, 2)
+	require.Equal(t, "blue", endpoints[0].ID)
+	require.False(t, endpoints[0].Health.Healthy)
+	require.Nil(t, endpoints[1].Health, "the health of blue is not attached to green")
+
+	metrics := httptest.NewRecorder()
+	s.metrics.Handler().ServeHTTP(metrics, httptest.NewRequest(http.MethodGet, "/", nil))
+	for _, id := range []string{"blue", "green"} {
+		require.Contains(t, metrics.Body.String(), fmt.Sprintf(`balance_requests_total{code="2xx",endpoint=%q,pool="default"} 1`, id))
+	}
+	require.Contains(t, metrics.Body.String(), `balance_endpoint_ejected{endpoint="green"} 1`)
+	require.Contains(t, metrics.Body.String(), `balance_endpoint_healthy{endpoint="blue"} 0`)
+
+	require.NoError(t, s.SetEndpointTLS("blue", UpstreamTLSConfig{ServerName: "blue.internal"}))
+	require.Equal(t, "blue.internal", s.upstreamTLS(blue.Identity()).ServerName)
+	require.Empty(t, s.upstreamTLS(green.Identity()).ServerName)
+}
@@ -0,0 +1,158 @@
+package slb
+
+import (
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultSlowStartMinWeightPercent = 10
+	DefaultSlowStartAggression       = 1.0
+)
+
+var (
+	ErrInvalidSlowStart = func(reason string) error {
+		return fmt.Errorf("invalid slow start configuration: %s", reason)
+	}
+)
+
+// SlowStartConfig configures the slow start of endpoints that are added to a running SLB (e.g freshly booted backends).
+// In the slow start window the effective weight of an endpoint ramps up from MinWeightPercent to its full weight.
+// Slow start is disabled if Duration is 0.
+type SlowStartConfig struct {
+	// Window after an endpoint was added, in which its effective weight ramps up
+	Duration time.Duration `json:"duration,omitempty"`
+	// Effective weight at the start of the window, in percent of the full weight (default 10)
+	MinWeightPercent int `json:"minWeightPercent,omitempty"`
+	// Curve of the ramp: the weight grows with (elapsed / duration)^(1 / aggression).
+	// 1 is linear (default), larger values ramp up faster at the start of the window.
+	Aggression float64 `json:"aggression,omitempty"`
+}
+
+// Returns true if a slow start window is configured
+func (s SlowStartConfig) Enabled() bool {
+	return s.Duration > 0
+}
+
+// Validates the slow start configuration
+func (s SlowStartConfig) Validate() error {
+	if s.Duration < 0 {
+		return ErrInvalidSlowStart("duration must not be negative")
+	}
+	if s.MinWeightPercent < 0 || s.MinWeightPercent > 100 {
+		return ErrInvalidSlowStart("min weight percent must be between 0 and 100")
+	}
+	if s.Aggression < 0 {
+		return ErrInvalidSlowStart("aggression must not be negative")
+	}
+	return nil
+}
+
+// returns a copy of the configuration with default values for unset fields
+func (s SlowStartConfig) withDefaults() SlowStartConfig {
+	if s.MinWeightPercent == 0 {
+		s.MinWeightPercent = DefaultSlowStartMinWeightPercent
+	}
+	if s.Aggression == 0 {
+		s.Aggression = DefaultSlowStartAggression
+	}
+	return s
+}
+
+// slowStarter tracks the endpoints in their slow start window by identity.
+// Selected endpoints are accepted with the probability of their effective weight, which works with every selector.
+// A nil slowStarter accepts every endpoint.
+type slowStarter struct {
+	cfg    SlowStartConfig
+	now    func() time.Time
+	random func() float64
+
+	mu       sync.Mutex
+	starting map[string]slowStart
+}
+
+type slowStart struct {
+	started  time.Time
+	duration time.Duration
+}
+
+func newSlowStarter(cfg SlowStartConfig) *slowStarter {
+	return &slowStarter{
+		cfg:      cfg.withDefaults(),
+		now:      time.Now,
+		random:   rand.Float64,
+		starting: make(map[string]slowStart),
+	}
+}
+
+// Starts the slow start of the endpoint id, it is ramped up for duration (no slow start if duration is 0)
+func (s *slowStarter) Start(id string, duration time.Duration) {
+	if s == nil {
+		return
+	}
+	defer s.mu.Unlock()
+	s.mu.Lock()
+	if duration <= 0 {
+		delete(s.starting, id)
+		return
+	}
+	s.starting[id] = slowStart{started: s.now(), duration: duration}
+}
+
+// Returns the effective weight of the endpoint id as a fraction of its full weight, 1 once its slow start is over
+func (s *slowStarter) Factor(id string) float64 {
+	if s == nil {
+		return 1
+	}
+	defer s.mu.Unlock()
+	s.mu.Lock()
+	start, ok := s.starting[id]
+	if !ok {
+		return 1
+	}
+	elapsed := s.now().Sub(start.started)
+	if elapsed >= start.duration {
+		delete(s.starting, id)
+		return 1
+	}
+	ramp := math.Pow(max(float64(elapsed), 0)/float64(start.duration), 1/s.cfg.Aggression)
+	return max(ramp, float64(s.cfg.MinWeightPercent)/100)
+}
+
+// Returns true if a selected server is accepted: always once its slow start is over,
+// and with the probability of its effective weight in the slow start window.
+func (s *slowStarter) Accept(server *Endpoint) bool {
+	factor := s.Factor(server.Identity())
+	return factor >= 1 || s.random() < factor
+}
+
+// Forgets the slow start of the endpoint id (e.g when it is removed)
+func (s *slowStarter) Forget(id string) {
+	if s == nil {
+		return
+	}
+	defer s.mu.Unlock()
+	s.mu.Lock()
+	delete(s.starting, id)
+}
+
+// Adds a backend endpoint to the selector of the default pool like Add,
+// with a slow start window of duration instead of the configured SlowStart.Duration (no slow start if 0).
+func (s *Slb) AddWithSlowStart(server *Endpoint, duration time.Duration) error {
+	if duration < 0 {
+		return ErrInvalidSlowStart("duration must not be negative")
+	}
+	return s.addSlowStart(s.selector, server, duration)
+}
+
+// adds server to selector, and ramps it up for duration
+func (s *Slb) addSlowStart(selector Selector, server *Endpoint, duration time.Duration) error {
+	if err := s.add(selector, server); err != nil {
+		return err
+	}
+	s.slowStart.Start(server.Identity(), duration)
+	return nil
+}
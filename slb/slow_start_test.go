@@ -0,0 +1,130 @@
+package slb
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// returns a slow starter with a clock controlled by the returned func
+func newTestSlowStarter(cfg SlowStartConfig) (*slowStarter, func(time.Duration)) {
+	starter := newSlowStarter(cfg)
+	now := time.Now()
+	starter.now = func() time.Time { return now }
+	return starter, func(d time.Duration) { now = now.Add(d) }
+}
+
+func TestSlowStartConfigValidate(t *testing.T) {
+	require.NoError(t, SlowStartConfig{}.Validate())
+	require.NoError(t, SlowStartConfig{Duration: time.Minute, MinWeightPercent: 100, Aggression: 2}.Validate())
+	require.Error(t, SlowStartConfig{Duration: -time.Second}.Validate())
+	require.Error(t, SlowStartConfig{MinWeightPercent: 101}.Validate())
+	require.Error(t, SlowStartConfig{Aggression: -1}.Validate())
+}
+
+func TestSlowStarterFactor(t *testing.T) {
+	addr := "http://10.0.0.1:80"
+	scenarios := []struct {
+		name    string
+		cfg     SlowStartConfig
+		elapsed time.Duration
+		factor  float64
+	}{
+		{name: "min weight at the start", elapsed: 0, factor: 0.1},
+		{name: "linear ramp", elapsed: time.Second * 50, factor: 0.5},
+		{name: "configured min weight", cfg: SlowStartConfig{MinWeightPercent: 60}, elapsed: time.Second * 50, factor: 0.6},
+		{name: "aggressive ramp", cfg: SlowStartConfig{Aggression: 2}, elapsed: time.Second * 25, factor: 0.5},
+		{name: "full weight after the window", elapsed: time.Second * 100, factor: 1},
+	}
+	for _, scenario := range scenarios {
+		t.Run(scenario.name, func(t *testing.T) {
+			starter, advance := newTestSlowStarter(scenario.cfg)
+			starter.Start(addr, time.Second*100)
+			advance(scenario.elapsed)
+			require.InDelta(t, scenario.factor, starter.Factor(addr), 0.0001)
+		})
+	}
+
+	starter, _ := newTestSlowStarter(SlowStartConfig{})
+	require.Equal(t, 1.0, starter.Factor(addr), "endpoints that were not started have their full weight")
+	starter.Start(addr, time.Minute)
+	starter.Forget(addr)
+	require.Equal(t, 1.0, starter.Factor(addr))
+	starter.Start(addr, 0)
+	require.Equal(t, 1.0, starter.Factor(addr), "a slow start of 0 disables it")
+	require.Equal(t, 1.0, (*slowStarter)(nil).Factor(addr))
+}
+
+func TestAddWithSlowStart(t *testing.T) {
+	old, added := bodyBackend(t, "old"), bodyBackend(t, "new")
+	s, selector := newDrainSlb(t, old)
+	starter, advance := newTestSlowStarter(SlowStartConfig{})
+	s.slowStart = starter
+	chance := 0.5
+	starter.random = func() float64 { return chance }
+
+	require.NoError(t, s.AddWithSlowStart(&Endpoint{Addr: added.URL}, time.Second*100))
+	require.Error(t, s.AddWithSlowStart(&Endpoint{Addr: added.URL}, -time.Second))
+	for range 4 {
+		require.Equal(t, "old", serveBody(s), "the endpoint is skipped with the probability of its effective weight")
+	}
+
+	advance(time.Second * 60)
+	bodies := []string{}
+	for range 4 {
+		bodies = append(bodies, serveBody(s))
+	}
+	require.ElementsMatch(t, []string{"old", "old", "new", "new"}, bodies, "the endpoint is selected once its weight exceeds the chance")
+
+	chance = 0.99
+	require.NoError(t, selector.Remove(selector.endpoints[0]))
+	require.Equal(t, []string{added.URL}, addresses(t, selector))
+	require.Equal(t, "new", serveBody(s), "an endpoint in slow start is selected if no other endpoint is available")
+}
+
+func TestAddUsesConfiguredSlowStart(t *testing.T) {
+	configured, added := bodyBackend(t, "configured"), bodyBackend(t, "added")
+	s, err := New(Config{
+		Endpoints:     []*Endpoint{{Addr: configured.URL}},
+		ListenAddress: "localhost",
+		SlowStart:     SlowStartConfig{Duration: time.Minute},
+	}, &setSelector{})
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Stop() })
+
+	require.NoError(t, s.Add(&Endpoint{Addr: added.URL}))
+	require.Equal(t, 1.0, s.slowStart.Factor(configured.URL), "configured endpoints are not slow started")
+	require.Less(t, s.slowStart.Factor(added.URL), 1.0)
+
+	_, err = New(Config{
+		Endpoints:     []*Endpoint{{Addr: configured.URL}},
+		ListenAddress: "localhost",
+		SlowStart:     SlowStartConfig{MinWeightPercent: -1},
+	}, &setSelector{})
+	require.Error(t, err)
+}
+
+func TestDiscoverySlowStartsEndpointsAfterTheFirstDiscovery(t *testing.T) {
+	endpoints := []discoveredEndpoint{{Address: "http://10.0.0.1:80"}}
+	started := []string{}
+	handler := discoveryHandler{
+		add:       func(selector Selector, server *Endpoint) error { return selector.Add(server) },
+		remove:    func(selector Selector, server *Endpoint) error { return selector.Remove(server) },
+		slowStart: func(server *Endpoint) { started = append(started, server.Addr) },
+	}
+	pool := &poolDiscovery{
+		source: discovererFunc(func() ([]discoveredEndpoint, time.Duration, error) {
+			return endpoints, time.Second, nil
+		}),
+		selector:  &setSelector{},
+		handler:   handler,
+		endpoints: make(map[string]*discoveredServer),
+	}
+	require.NoError(t, pool.refresh())
+	require.Empty(t, started)
+
+	endpoints = append(endpoints, discoveredEndpoint{Address: "http://10.0.0.2:80"})
+	require.NoError(t, pool.refresh())
+	require.Equal(t, []string{"http://10.0.0.2:80"}, started)
+}
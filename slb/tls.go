@@ -0,0 +1,278 @@
+package slb
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"sync"
+	"time"
+)
+
+var (
+	ErrInvalidTLS = func(reason string) error { return fmt.Errorf("invalid tls configuration: %s", reason) }
+)
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// CertificateConfig is a certificate chain with its private key, either as files or as PEM contents
+type CertificateConfig struct {
+	// Paths of the PEM encoded certificate chain and private key
+	CertFile string `json:"certFile,omitempty"`
+	KeyFile  string `json:"keyFile,omitempty"`
+	// PEM encoded certificate chain and private key, used if no files are provided (e.g set through the api)
+	CertPEM string `json:"certPem,omitempty"`
+	KeyPEM  string `json:"-"`
+}
+
+// returns true if the certificate is loaded from files
+func (c CertificateConfig) fromFiles() bool {
+	return c.CertFile != "" || c.KeyFile != ""
+}
+
+// Validates that either both files or both PEM contents are provided
+func (c CertificateConfig) Validate() error {
+	if c.fromFiles() {
+		if c.CertFile == "" || c.KeyFile == "" {
+			return ErrInvalidTLS("certificate and key file are required")
+		}
+		return nil
+	}
+	if c.CertPEM == "" || c.KeyPEM == "" {
+		return ErrInvalidTLS("certificate and key files or PEM contents are required")
+	}
+	return nil
+}
+
+// loads the certificate, and parses its leaf for the selection by server name
+func (c CertificateConfig) load() (*tls.Certificate, error) {
+	var cert tls.Certificate
+	var err error
+	if c.fromFiles() {
+		cert, err = tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+	} else {
+		cert, err = tls.X509KeyPair([]byte(c.CertPEM), []byte(c.KeyPEM))
+	}
+	if err != nil {
+		return nil, err
+	}
+	if cert.Leaf == nil {
+		if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			return nil, err
+		}
+	}
+	return &cert, nil
+}
+
+// TLSConfig configures TLS termination on the frontend listener.
+// TLS is disabled if no Certificates are provided.
+type TLSConfig struct {
+	// Certificates are selected by the server name (SNI) of the client, the first is served if none matches
+	Certificates []CertificateConfig `json:"certificates,omitempty"`
+	// Minimum TLS version: "1.0", "1.1", "1.2" or "1.3" (default "1.2")
+	MinVersion string `json:"minVersion,omitempty"`
+	// Names of the cipher suites of TLS 1.2 and lower (e.g "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"),
+	// the go defaults are used if empty. TLS 1.3 cipher suites are not configurable.
+	CipherSuites []string `json:"cipherSuites,omitempty"`
+	// Interval in which the certificate files are reloaded if they changed, they are not reloaded if 0
+	ReloadInterval time.Duration `json:"reloadInterval,omitempty"`
+}
+
+// Returns true if certificates are configured
+func (t TLSConfig) Enabled() bool {
+	return len(t.Certificates) > 0
+}
+
+// Validates the tls configuration, the certificates are loaded by New
+func (t TLSConfig) Validate() error {
+	if !t.Enabled() {
+		return nil
+	}
+	for _, cert := range t.Certificates {
+		if err := cert.Validate(); err != nil {
+			return err
+		}
+	}
+	if _, ok := tlsVersions[t.MinVersion]; t.MinVersion != "" && !ok {
+		return ErrInvalidTLS("unknown min version " + t.MinVersion)
+	}
+	if _, err := cipherSuiteIDs(t.CipherSuites); err != nil {
+		return err
+	}
+	if t.ReloadInterval < 0 {
+		return ErrInvalidTLS("reload interval must not be negative")
+	}
+	return nil
+}
+
+// returns the ids of the cipher suites by name
+func cipherSuiteIDs(names []string) ([]uint16, error) {
+	suites := append(tls.CipherSuites(), tls.InsecureCipherSuites()...)
+	ids := make([]uint16, 0, len(names))
+	for _, name := range names {
+		i := slices.IndexFunc(suites, func(suite *tls.CipherSuite) bool { return suite.Name == name })
+		if i < 0 {
+			return nil, ErrInvalidTLS("unknown cipher suite " + name)
+		}
+		ids = append(ids, suites[i].ID)
+	}
+	return ids, nil
+}
+
+// certificates serves the loaded certificates to the tls handshakes.
+// Reloaded certificates are served to new connections, open connections are not affected.
+type certificates struct {
+	cfg   TLSConfig
+	mu    sync.RWMutex
+	certs []*tls.Certificate
+	// modification times of the files, when they were loaded
+	modTimes map[string]time.Time
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+func newCertificates(cfg TLSConfig) (*certificates, error) {
+	if !cfg.Enabled() {
+		return nil, nil
+	}
+	c := &certificates{cfg: cfg}
+	if err := c.Reload(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Reloads all certificates, the current certificates are kept if any fails to load
+func (c *certificates) Reload() error {
+	if c == nil {
+		return nil
+	}
+	certs := make([]*tls.Certificate, 0, len(c.cfg.Certificates))
+	modTimes := c.fileModTimes()
+	for i, cfg := range c.cfg.Certificates {
+		cert, err := cfg.load()
+		if err != nil {
+			return ErrInvalidTLS(fmt.Sprintf("failed to load certificate %d: %s", i, err))
+		}
+		certs = append(certs, cert)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.certs, c.modTimes = certs, modTimes
+	return nil
+}
+
+// returns the modification times of the certificate files
+func (c *certificates) fileModTimes() map[string]time.Time {
+	modTimes := make(map[string]time.Time)
+	for _, cfg := range c.cfg.Certificates {
+		if !cfg.fromFiles() {
+			continue
+		}
+		for _, file := range []string{cfg.CertFile, cfg.KeyFile} {
+			if info, err := os.Stat(file); err == nil {
+				modTimes[file] = info.ModTime()
+			}
+		}
+	}
+	return modTimes
+}
+
+// reloads the certificates if a file changed since they were loaded
+func (c *certificates) reloadChanged() {
+	c.mu.RLock()
+	loaded := c.modTimes
+	c.mu.RUnlock()
+	modTimes := c.fileModTimes()
+	for file, modTime := range modTimes {
+		if !loaded[file].Equal(modTime) {
+			if err := c.Reload(); err != nil {
+				slog.Error(err.Error())
+				return
+			}
+			slog.Info("reloaded the tls certificates")
+			return
+		}
+	}
+}
+
+// Returns the certificate for a client hello: the first that supports its server name, or the first certificate
+func (c *certificates) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for _, cert := range c.certs {
+		if hello.SupportsCertificate(cert) == nil {
+			return cert, nil
+		}
+	}
+	return c.certs[0], nil
+}
+
+// Returns the tls configuration of the frontend server
+func (c *certificates) serverConfig() *tls.Config {
+	minVersion, ok := tlsVersions[c.cfg.MinVersion]
+	if !ok {
+		minVersion = tls.VersionTLS12
+	}
+	// validated by TLSConfig.Validate
+	cipherSuites, _ := cipherSuiteIDs(c.cfg.CipherSuites)
+	if len(cipherSuites) == 0 {
+		cipherSuites = nil
+	}
+	return &tls.Config{
+		MinVersion:     minVersion,
+		CipherSuites:   cipherSuites,
+		GetCertificate: c.GetCertificate,
+	}
+}
+
+// Starts reloading changed certificate files in the reload interval
+func (c *certificates) Start() {
+	if c == nil || c.cfg.ReloadInterval == 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.stop != nil {
+		return
+	}
+	c.stop, c.done = make(chan struct{}), make(chan struct{})
+	go c.run(c.stop, c.done)
+}
+
+// Stops reloading the certificates
+func (c *certificates) Stop() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	stop, done := c.stop, c.done
+	c.stop, c.done = nil, nil
+	c.mu.Unlock()
+	if stop == nil {
+		return
+	}
+	close(stop)
+	<-done
+}
+
+func (c *certificates) run(stop <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(c.cfg.ReloadInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			c.reloadChanged()
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package slb
+
+import (
+	"balance/internal/mock"
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// returns a PEM encoded self signed certificate for names, and its key
+func newCertificatePEM(t *testing.T, names ...string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: names[0]},
+		DNSNames:              names,
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}))
+}
+
+// writes a self signed certificate for names to dir, and returns its configuration
+func writeCertificate(t *testing.T, dir string, names ...string) CertificateConfig {
+	certPEM, keyPEM := newCertificatePEM(t, names...)
+	cfg := CertificateConfig{CertFile: filepath.Join(dir, names[0]+".pem"), KeyFile: filepath.Join(dir, names[0]+"-key.pem")}
+	require.NoError(t, os.WriteFile(cfg.CertFile, []byte(certPEM), 0o600))
+	require.NoError(t, os.WriteFile(cfg.KeyFile, []byte(keyPEM), 0o600))
+	return cfg
+}
+
+func TestTLSConfigValidate(t *testing.T) {
+	cert := CertificateConfig{CertFile: "cert.pem", KeyFile: "key.pem"}
+	require.NoError(t, TLSConfig{}.Validate())
+	require.NoError(t, TLSConfig{
+		Certificates: []CertificateConfig{cert, {CertPEM: "cert", KeyPEM: "key"}},
+		MinVersion:   "1.3",
+		CipherSuites: []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"},
+	}.Validate())
+	require.Error(t, TLSConfig{Certificates: []CertificateConfig{{CertFile: "cert.pem"}}}.Validate())
+	require.Error(t, TLSConfig{Certificates: []CertificateConfig{{CertPEM: "cert"}}}.Validate())
+	require.Error(t, TLSConfig{Certificates: []CertificateConfig{cert}, MinVersion: "2.0"}.Validate())
+	require.Error(t, TLSConfig{Certificates: []CertificateConfig{cert}, CipherSuites: []string{"TLS_NULL"}}.Validate())
+	require.Error(t, TLSConfig{Certificates: []CertificateConfig{cert}, ReloadInterval: -time.Second}.Validate())
+}
+
+func TestCertificatesAreSelectedByServerName(t *testing.T) {
+	dir := t.TempDir()
+	certPEM, keyPEM := newCertificatePEM(t, "b.example")
+	certs, err := newCertificates(TLSConfig{Certificates: []CertificateConfig{
+		writeCertificate(t, dir, "a.example"),
+		{CertPEM: certPEM, KeyPEM: keyPEM},
+	}})
+	require.NoError(t, err)
+
+	for serverName, expected := range map[string]string{"a.example": "a.example", "b.example": "b.example", "": "a.example", "c.example": "a.example"} {
+		cert, err := certs.GetCertificate(&tls.ClientHelloInfo{ServerName: serverName, SupportedVersions: []uint16{tls.VersionTLS13}})
+		require.NoError(t, err)
+		require.Equal(t, expected, cert.Leaf.Subject.CommonName, serverName)
+	}
+
+	_, err = newCertificates(TLSConfig{Certificates: []CertificateConfig{{CertPEM: certPEM, KeyPEM: "invalid"}}})
+	require.Error(t, err)
+}
+
+func TestCertificatesReloadChangedFiles(t *testing.T) {
+	dir := t.TempDir()
+	cfg := writeCertificate(t, dir, "a.example")
+	certs, err := newCertificates(TLSConfig{Certificates: []CertificateConfig{cfg}})
+	require.NoError(t, err)
+	hello := &tls.ClientHelloInfo{ServerName: "a.example", SupportedVersions: []uint16{tls.VersionTLS13}}
+	first, err := certs.GetCertificate(hello)
+	require.NoError(t, err)
+
+	certs.reloadChanged()
+	cert, _ := certs.GetCertificate(hello)
+	require.Same(t, first, cert, "unchanged files are not reloaded")
+
+	renewed := writeCertificate(t, t.TempDir(), "a.example")
+	for _, file := range [][2]string{{renewed.CertFile, cfg.CertFile}, {renewed.KeyFile, cfg.KeyFile}} {
+		require.NoError(t, os.Rename(file[0], file[1]))
+		require.NoError(t, os.Chtimes(file[1], time.Now(), time.Now().Add(time.Minute)))
+	}
+	certs.reloadChanged()
+	cert, _ = certs.GetCertificate(hello)
+	require.NotEqual(t, first.Leaf.SerialNumber, cert.Leaf.SerialNumber, "changed files are reloaded")
+
+	require.NoError(t, os.WriteFile(cfg.KeyFile, []byte("invalid"), 0o600))
+	require.Error(t, certs.Reload())
+	current, _ := certs.GetCertificate(hello)
+	require.Same(t, cert, current, "the certificates are kept if the reload fails")
+}
+
+func TestRunTerminatesTLS(t *testing.T) {
+	dir := t.TempDir()
+	cfg := writeCertificate(t, dir, "b.example")
+	port := mock.RandomPort()
+	s, err := New(Config{
+		Endpoints:     []*Endpoint{{Addr: "localhost"}},
+		ListenAddress: "localhost",
+		ListenPort:    port,
+		TLS: TLSConfig{
+			Certificates: []CertificateConfig{writeCertificate(t, dir, "a.example"), cfg},
+			MinVersion:   "1.3",
+		},
+	}, &SelectorMock{expectedResponse: http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader("ok"))}})
+	require.NoError(t, err)
+	go s.Run()
+	t.Cleanup(func() { s.Stop() })
+
+	dial := func(serverName string, version uint16) (*tls.Conn, error) {
+		return tls.Dial("tcp", "localhost:"+port, &tls.Config{
+			ServerName:         serverName,
+			InsecureSkipVerify: true,
+			MinVersion:         version,
+			MaxVersion:         version,
+		})
+	}
+	var conn *tls.Conn
+	require.Eventually(t, func() bool {
+		conn, err = dial("b.example", tls.VersionTLS13)
+		return err == nil
+	}, time.Second, time.Millisecond*10)
+	t.Cleanup(func() { conn.Close() })
+	require.Equal(t, "b.example", conn.ConnectionState().PeerCertificates[0].Subject.CommonName)
+	_, err = dial("b.example", tls.VersionTLS12)
+	require.Error(t, err, "versions below the min version are rejected")
+
+	// renew the certificate of b.example while the connection is open
+	renewed := writeCertificate(t, t.TempDir(), "b.example")
+	require.NoError(t, os.Rename(renewed.CertFile, cfg.CertFile))
+	require.NoError(t, os.Rename(renewed.KeyFile, cfg.KeyFile))
+	require.NoError(t, s.ReloadCertificates())
+
+	second, err := dial("b.example", tls.VersionTLS13)
+	require.NoError(t, err)
+	defer second.Close()
+	require.NotEqual(t, conn.ConnectionState().PeerCertificates[0].SerialNumber, second.ConnectionState().PeerCertificates[0].SerialNumber)
+
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: b.example\r\n\r\n"))
+	require.NoError(t, err, "open connections are not dropped by the reload")
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+}
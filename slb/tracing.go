@@ -0,0 +1,212 @@
+package slb
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.25.0"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// TracingExporter is where the spans are exported to
+type TracingExporter string
+
+const (
+	// Exports the spans with OTLP over http to a collector
+	TracingExporterOTLP TracingExporter = "otlp"
+	// Writes the spans as json lines to a file
+	TracingExporterFile TracingExporter = "file"
+
+	DefaultTracingServiceName = "balance"
+	tracerName                = "balance/slb"
+)
+
+var (
+	ErrInvalidTracing = func(reason string) error { return fmt.Errorf("invalid tracing configuration: %s", reason) }
+)
+
+// TracingConfig configures the OpenTelemetry tracing of the proxied requests:
+// a server span per request, and a client span per attempt to proxy it to an endpoint.
+// The W3C trace context of the attempt is propagated to the endpoints with the traceparent header.
+// Tracing is disabled if no Exporter is provided.
+type TracingConfig struct {
+	Exporter TracingExporter `json:"exporter,omitempty"`
+	// Url of the OTLP collector (e.g "http://localhost:4318"), or the path of the file
+	Endpoint string `json:"endpoint,omitempty"`
+	// Service name of the spans (default "balance")
+	ServiceName string `json:"serviceName,omitempty"`
+	// Fraction of the traces that is sampled if the request has no sampled parent (e.g 0.1), all are sampled if 0
+	SampleRatio float64 `json:"sampleRatio,omitempty"`
+}
+
+// Returns true if an exporter is configured
+func (t TracingConfig) Enabled() bool {
+	return t.Exporter != ""
+}
+
+// Validates the tracing configuration
+func (t TracingConfig) Validate() error {
+	if !t.Enabled() {
+		return nil
+	}
+	if t.Endpoint == "" {
+		return ErrInvalidTracing("endpoint is required")
+	}
+	switch t.Exporter {
+	case TracingExporterOTLP:
+		endpoint, err := url.Parse(t.Endpoint)
+		if err != nil || (endpoint.Scheme != "http" && endpoint.Scheme != "https") || endpoint.Host == "" {
+			return ErrInvalidTracing("otlp endpoint must be a http(s) url")
+		}
+	case TracingExporterFile:
+	default:
+		return ErrInvalidTracing("unknown exporter " + string(t.Exporter))
+	}
+	if t.SampleRatio < 0 || t.SampleRatio > 1 {
+		return ErrInvalidTracing("sample ratio must be between 0 and 1")
+	}
+	return nil
+}
+
+// tracing traces the proxied requests, a nil tracing traces nothing
+type tracing struct {
+	provider   *sdktrace.TracerProvider
+	tracer     trace.Tracer
+	propagator propagation.TextMapPropagator
+	// file of the file exporter, it is closed after the provider is shut down
+	file *os.File
+}
+
+func newTracing(cfg TracingConfig) (*tracing, error) {
+	if !cfg.Enabled() {
+		return nil, nil
+	}
+	var file *os.File
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch cfg.Exporter {
+	case TracingExporterFile:
+		file, err = os.OpenFile(cfg.Endpoint, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return nil, ErrInvalidTracing(err.Error())
+		}
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(file))
+	default:
+		exporter, err = otlptracehttp.New(context.Background(), otlptracehttp.WithEndpointURL(cfg.Endpoint))
+	}
+	if err != nil {
+		if file != nil {
+			file.Close()
+		}
+		return nil, ErrInvalidTracing(err.Error())
+	}
+	t := newTracingWith(cfg, sdktrace.WithBatcher(exporter))
+	t.file = file
+	return t, nil
+}
+
+// returns a tracing with a tracer provider of the configuration and opts (e.g its exporter)
+func newTracingWith(cfg TracingConfig, opts ...sdktrace.TracerProviderOption) *tracing {
+	serviceName := cfg.ServiceName
+	if serviceName == "" {
+		serviceName = DefaultTracingServiceName
+	}
+	sampler := sdktrace.AlwaysSample()
+	if cfg.SampleRatio > 0 {
+		sampler = sdktrace.TraceIDRatioBased(cfg.SampleRatio)
+	}
+	opts = append([]sdktrace.TracerProviderOption{
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(serviceName))),
+		sdktrace.WithSampler(sdktrace.ParentBased(sampler)),
+	}, opts...)
+	provider := sdktrace.NewTracerProvider(opts...)
+	return &tracing{
+		provider:   provider,
+		tracer:     provider.Tracer(tracerName),
+		propagator: propagation.TraceContext{},
+	}
+}
+
+// Returns the tracer of the spans, a noop tracer if tracing is disabled
+func (t *tracing) Tracer() trace.Tracer {
+	if t == nil {
+		return noop.NewTracerProvider().Tracer(tracerName)
+	}
+	return t.tracer
+}
+
+// Serves the request with next within a server span, that continues the trace of the request
+func (t *tracing) Handle(rw http.ResponseWriter, r *http.Request, next func(http.ResponseWriter, *http.Request)) {
+	if t == nil {
+		next(rw, r)
+		return
+	}
+	client, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		client = r.RemoteAddr
+	}
+	ctx := t.propagator.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+	ctx, span := t.tracer.Start(ctx, r.Method, trace.WithSpanKind(trace.SpanKindServer), trace.WithAttributes(
+		semconv.HTTPRequestMethodKey.String(r.Method),
+		semconv.URLPath(r.URL.Path),
+		semconv.ServerAddress(r.Host),
+		semconv.ClientAddress(client),
+	))
+	defer span.End()
+	recorder := newResponseRecorder(rw)
+
+	next(recorder, r.WithContext(ctx))
+
+	span.SetAttributes(semconv.HTTPResponseStatusCode(recorder.Status()))
+	if recorder.Status() >= http.StatusInternalServerError {
+		span.SetStatus(codes.Error, http.StatusText(recorder.Status()))
+	}
+}
+
+// Starts a client span of an attempt to proxy r to server of pool, and injects its context into the request headers.
+// The returned func ends the span with the outcome of the attempt.
+func (t *tracing) Attempt(r *http.Request, pool string, server *Endpoint) (*http.Request, func(Outcome)) {
+	if t == nil {
+		return r, func(Outcome) {}
+	}
+	ctx, span := t.tracer.Start(r.Context(), r.Method, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+		semconv.HTTPRequestMethodKey.String(r.Method),
+		semconv.URLFull(server.Addr+r.URL.RequestURI()),
+		attribute.String("balance.pool", pool),
+		attribute.String("balance.endpoint", server.Addr),
+	))
+	r = r.WithContext(ctx)
+	r.Header = r.Header.Clone()
+	t.propagator.Inject(ctx, propagation.HeaderCarrier(r.Header))
+	return r, func(o Outcome) {
+		span.SetAttributes(semconv.HTTPResponseStatusCode(o.StatusCode))
+		if o.StatusCode >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(o.StatusCode))
+		}
+		span.End()
+	}
+}
+
+// Exports the remaining spans, and stops the exporter
+func (t *tracing) Shutdown(ctx context.Context) error {
+	if t == nil {
+		return nil
+	}
+	err := t.provider.Shutdown(ctx)
+	if t.file != nil {
+		t.file.Close()
+	}
+	return err
+}
@@ -0,0 +1,95 @@
+package slb
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	parentTraceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	parentSpanID  = "00f067aa0ba902b7"
+)
+
+func TestTracingConfigValidate(t *testing.T) {
+	require.NoError(t, TracingConfig{}.Validate())
+	require.NoError(t, TracingConfig{Exporter: TracingExporterOTLP, Endpoint: "http://localhost:4318"}.Validate())
+	require.NoError(t, TracingConfig{Exporter: TracingExporterFile, Endpoint: "spans.json", SampleRatio: 0.5}.Validate())
+	require.Error(t, TracingConfig{Exporter: TracingExporterOTLP}.Validate())
+	require.Error(t, TracingConfig{Exporter: TracingExporterOTLP, Endpoint: "localhost:4318"}.Validate())
+	require.Error(t, TracingConfig{Exporter: "jaeger", Endpoint: "http://localhost:14268"}.Validate())
+	require.Error(t, TracingConfig{Exporter: TracingExporterFile, Endpoint: "spans.json", SampleRatio: 2}.Validate())
+}
+
+func TestTracingSpans(t *testing.T) {
+	var traceparent string
+	backend := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		traceparent = r.Header.Get("traceparent")
+	}))
+	t.Cleanup(backend.Close)
+	s, tracker := newRetrySlb(t, RetryPolicyConfig{MaxRetries: 1}, refusingBackend(), backend)
+	recorder := tracetest.NewSpanRecorder()
+	s.tracing = newTracingWith(TracingConfig{}, sdktrace.WithSpanProcessor(recorder))
+
+	r := httptest.NewRequest(http.MethodGet, "/items", nil)
+	r.Header.Set("traceparent", "00-"+parentTraceID+"-"+parentSpanID+"-01")
+	rw := httptest.NewRecorder()
+	s.ServeHTTP(rw, r)
+	require.Equal(t, http.StatusOK, rw.Code)
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 3, "a span per attempt and the server span")
+	refused, succeeded, server := spans[0], spans[1], spans[2]
+	require.Equal(t, trace.SpanKindServer, server.SpanKind())
+	require.Equal(t, parentTraceID, server.SpanContext().TraceID().String(), "the trace of the request is continued")
+	require.Equal(t, parentSpanID, server.Parent().SpanID().String())
+
+	for i, attempt := range []sdktrace.ReadOnlySpan{refused, succeeded} {
+		require.Equal(t, trace.SpanKindClient, attempt.SpanKind())
+		require.Equal(t, server.SpanContext().SpanID(), attempt.Parent().SpanID())
+		require.Equal(t, tracker.endpoints[i].Addr, stringAttribute(attempt, "balance.endpoint"))
+	}
+	require.Equal(t, codes.Error, refused.Status().Code)
+	require.Len(t, refused.Events(), 1, "the proxy error is recorded")
+	require.Equal(t, codes.Unset, succeeded.Status().Code)
+
+	expected := "00-" + parentTraceID + "-" + succeeded.SpanContext().SpanID().String() + "-01"
+	require.Equal(t, expected, traceparent, "the context of the attempt is propagated to the backend")
+}
+
+// returns the value of the string attribute key of span
+func stringAttribute(span sdktrace.ReadOnlySpan, key string) string {
+	for _, attribute := range span.Attributes() {
+		if string(attribute.Key) == key {
+			return attribute.Value.AsString()
+		}
+	}
+	return ""
+}
+
+func TestTracingFileExporter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "spans.json")
+	s, _ := newRetrySlb(t, RetryPolicyConfig{}, echoBackend(t))
+	var err error
+	s.tracing, err = newTracing(TracingConfig{Exporter: TracingExporterFile, Endpoint: path, ServiceName: "test-balance"})
+	require.NoError(t, err)
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("traceparent", "00-"+parentTraceID+"-"+parentSpanID+"-01")
+	s.ServeHTTP(httptest.NewRecorder(), r)
+	require.NoError(t, s.tracing.Shutdown(context.Background()))
+
+	spans, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Contains(t, string(spans), parentTraceID)
+	require.Contains(t, string(spans), "test-balance")
+}
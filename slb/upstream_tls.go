@@ -0,0 +1,55 @@
+package slb
+
+import (
+	internalTls "balance/internal/tls"
+	"crypto/tls"
+	"fmt"
+	"net/http"
+)
+
+var (
+	ErrInvalidUpstreamTLS = func(reason string) error { return fmt.Errorf("invalid upstream tls configuration: %s", reason) }
+)
+
+// UpstreamTLSConfig configures the TLS connections to https endpoints (e.g "https://backend:8443")
+type UpstreamTLSConfig struct {
+	// Path of the PEM encoded CA certificates that verify the endpoints, the system roots are used if empty
+	CAFile string `json:"caFile,omitempty"`
+	// Paths of the PEM encoded client certificate and key for mTLS, no client certificate is sent if empty
+	CertFile string `json:"certFile,omitempty"`
+	KeyFile  string `json:"keyFile,omitempty"`
+	// Server name that is sent (SNI) and verified instead of the endpoint host
+	ServerName string `json:"serverName,omitempty"`
+	// Skips the verification of the endpoint certificates, only for test environments
+	InsecureSkipVerify bool `json:"insecureSkipVerify,omitempty"`
+}
+
+// Validates that either both or none of the client certificate files are provided
+func (u UpstreamTLSConfig) Validate() error {
+	if (u.CertFile == "") != (u.KeyFile == "") {
+		return ErrInvalidUpstreamTLS("client certificate and key file are required")
+	}
+	return nil
+}
+
+// returns the tls configuration of the connections to an endpoint
+func (u UpstreamTLSConfig) clientConfig() (*tls.Config, error) {
+	cfg, err := internalTls.GetTlsConfig(u.CAFile, u.CertFile, u.KeyFile)
+	if err != nil {
+		return nil, ErrInvalidUpstreamTLS(err.Error())
+	}
+	cfg.ServerName = u.ServerName
+	cfg.InsecureSkipVerify = u.InsecureSkipVerify
+	return cfg, nil
+}
+
+// returns a transport of the connections to an endpoint with the tls configuration
+func (u UpstreamTLSConfig) transport() (*http.Transport, error) {
+	cfg, err := u.clientConfig()
+	if err != nil {
+		return nil, err
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = cfg
+	return transport, nil
+}
@@ -0,0 +1,139 @@
+package slb
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// returns a https backend with the certificate cert, that requires client certificates signed by clientCA if it is set.
+// The backend responds with the common name of the client certificate.
+func tlsBackend(t *testing.T, cert CertificateConfig, clientCA *CertificateConfig) *httptest.Server {
+	backend := httptest.NewUnstartedServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if len(r.TLS.PeerCertificates) > 0 {
+			rw.Write([]byte(r.TLS.PeerCertificates[0].Subject.CommonName))
+		}
+	}))
+	serverCert, err := cert.load()
+	require.NoError(t, err)
+	backend.TLS = &tls.Config{Certificates: []tls.Certificate{*serverCert}}
+	if clientCA != nil {
+		ca, err := clientCA.load()
+		require.NoError(t, err)
+		backend.TLS.ClientCAs = x509.NewCertPool()
+		backend.TLS.ClientCAs.AddCert(ca.Leaf)
+		backend.TLS.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	backend.StartTLS()
+	t.Cleanup(backend.Close)
+	return backend
+}
+
+func TestUpstreamTLSConfigValidate(t *testing.T) {
+	require.NoError(t, UpstreamTLSConfig{}.Validate())
+	require.NoError(t, UpstreamTLSConfig{CAFile: "ca.pem", CertFile: "cert.pem", KeyFile: "key.pem", ServerName: "backend"}.Validate())
+	require.Error(t, UpstreamTLSConfig{CertFile: "cert.pem"}.Validate())
+	require.Error(t, UpstreamTLSConfig{KeyFile: "key.pem"}.Validate())
+	require.Error(t, (&Config{
+		Endpoints:   []*Endpoint{{Addr: "https://localhost:8443"}},
+		EndpointTLS: map[string]UpstreamTLSConfig{"https://localhost:8443": {CertFile: "cert.pem"}},
+	}).Validate())
+	require.Error(t, (&Config{Endpoints: []*Endpoint{{Addr: "ftp://localhost:21"}}}).Validate())
+}
+
+func TestProxyToHTTPSEndpoints(t *testing.T) {
+	dir := t.TempDir()
+	serverCert := writeCertificate(t, dir, "backend.example")
+	clientCert := writeCertificate(t, dir, "client.example")
+	mtls := tlsBackend(t, serverCert, &clientCert)
+	type upstreamTLSTest struct {
+		name           string
+		backend        *httptest.Server
+		cfg            UpstreamTLSConfig
+		expectedStatus int
+		expectedBody   string
+	}
+	scenarios := []upstreamTLSTest{
+		{
+			name:    "mtls",
+			backend: mtls,
+			cfg: UpstreamTLSConfig{
+				CAFile:     serverCert.CertFile,
+				CertFile:   clientCert.CertFile,
+				KeyFile:    clientCert.KeyFile,
+				ServerName: "backend.example",
+			},
+			expectedStatus: http.StatusOK,
+			expectedBody:   "client.example",
+		},
+		{
+			name:           "without client certificate",
+			backend:        mtls,
+			cfg:            UpstreamTLSConfig{CAFile: serverCert.CertFile},
+			expectedStatus: http.StatusBadGateway,
+		},
+		{
+			name:           "unknown certificate authority",
+			backend:        tlsBackend(t, serverCert, nil),
+			cfg:            UpstreamTLSConfig{},
+			expectedStatus: http.StatusBadGateway,
+		},
+		{
+			name:           "server name mismatch",
+			backend:        tlsBackend(t, serverCert, nil),
+			cfg:            UpstreamTLSConfig{CAFile: serverCert.CertFile, ServerName: "other.example"},
+			expectedStatus: http.StatusBadGateway,
+		},
+		{
+			name:           "insecure skip verify",
+			backend:        tlsBackend(t, serverCert, nil),
+			cfg:            UpstreamTLSConfig{InsecureSkipVerify: true},
+			expectedStatus: http.StatusOK,
+		},
+	}
+	for _, scenario := range scenarios {
+		t.Run(scenario.name, func(t *testing.T) {
+			s, tracker := newRetrySlb(t, RetryPolicyConfig{})
+			require.NoError(t, s.SetEndpointTLS(scenario.backend.URL, scenario.cfg))
+			server := &Endpoint{Addr: scenario.backend.URL}
+			require.NoError(t, s.setServerProxy(server))
+			tracker.endpoints = append(tracker.endpoints, server)
+
+			rw := httptest.NewRecorder()
+			s.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "/", nil))
+			require.Equal(t, scenario.expectedStatus, rw.Code)
+			if scenario.expectedStatus == http.StatusOK {
+				require.Equal(t, scenario.expectedBody, rw.Body.String())
+				require.NoError(t, s.health.probe(server), "https endpoints are probed with their tls")
+			} else {
+				require.Error(t, s.health.probe(server))
+			}
+		})
+	}
+}
+
+func TestNewUsesUpstreamTLSForHTTPSEndpoints(t *testing.T) {
+	dir := t.TempDir()
+	serverCert := writeCertificate(t, dir, "backend.example")
+	backend := tlsBackend(t, serverCert, nil)
+	s, err := New(Config{
+		Endpoints:     []*Endpoint{{Addr: backend.URL}, {Addr: "localhost"}},
+		ListenAddress: "localhost",
+		UpstreamTLS:   UpstreamTLSConfig{CAFile: serverCert.CertFile},
+		EndpointTLS:   map[string]UpstreamTLSConfig{"https://localhost:8443": {InsecureSkipVerify: true}},
+	}, &SelectorMock{})
+	require.NoError(t, err)
+	require.Equal(t, map[string]UpstreamTLSConfig{"https://localhost:8443": {InsecureSkipVerify: true}}, s.Configuration().EndpointTLS)
+	require.Equal(t, UpstreamTLSConfig{CAFile: serverCert.CertFile}, s.upstreamTLS(backend.URL))
+
+	_, err = New(Config{
+		Endpoints:     []*Endpoint{{Addr: backend.URL}},
+		ListenAddress: "localhost",
+		UpstreamTLS:   UpstreamTLSConfig{CAFile: dir + "/missing.pem"},
+	}, &SelectorMock{})
+	require.Error(t, err, "the tls of https endpoints is loaded by New")
+}